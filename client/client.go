@@ -2,6 +2,7 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -31,16 +32,32 @@ type DataExportClient interface {
 	// CreateExport starts an asynchronous export of the "Everyone" segment for the specified time range.
 	// The time bounds for start and stop are inclusive and exclusive, respectively.
 	// If successful, returns the id for the created export which can be used to check the progress.
-	CreateExport(start time.Time, end time.Time, fields []string) (string, error)
+	CreateExport(ctx context.Context, start time.Time, end time.Time, fields []string) (string, error)
 
 	// GetExportProgress returns the estimated progress of the export for the provided operation and the id
 	// of the export if ready for download. The progress value is an integer between 1 and 100
 	// and represents and estimated completion percentage.
-	GetExportProgress(operationId string) (progress int, exportId string, err error)
+	GetExportProgress(ctx context.Context, operationId string) (progress int, exportId string, err error)
 
 	// GetExport returns a stream for the provided export ID. If the export is not ready, this
-	// will fail with ErrExportNotReady.
-	GetExport(exportId string) (io.ReadCloser, error)
+	// will fail with ErrExportNotReady. Reading the stream fails once the context is cancelled.
+	GetExport(ctx context.Context, exportId string) (io.ReadCloser, error)
+}
+
+// ExportStatus is the state of an export operation.
+type ExportStatus struct {
+	// Progress is the estimated completion percentage, between 1 and 100.
+	Progress int
+	// ExportId is set once the export is ready for download.
+	ExportId string
+	// Expires is when the results of the completed export expire, or the zero time if unknown.
+	Expires time.Time
+}
+
+// ExportStatusClient is implemented by clients that can also report when the results of an export expire,
+// along with its progress. An export that was interrupted by a restart is only resumed until then.
+type ExportStatusClient interface {
+	GetExportStatus(ctx context.Context, operationId string) (ExportStatus, error)
 }
 
 // Client represents a HTTP client for making requests to the FullStory API.
@@ -69,6 +86,7 @@ func WithHttpClient(httpClient *http.Client) Option {
 }
 
 var _ DataExportClient = (*Client)(nil)
+var _ ExportStatusClient = (*Client)(nil)
 
 // NewClient returns a Client initialized with http.DefaultClient and the
 // supplied apiToken.
@@ -2,6 +2,7 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -47,7 +48,7 @@ type getExportResultsResponse struct {
 	Location string `json:"location"`
 }
 
-func (c *Client) CreateExport(start time.Time, end time.Time, fields []string) (string, error) {
+func (c *Client) CreateExport(ctx context.Context, start time.Time, end time.Time, fields []string) (string, error) {
 	params := createParams{
 		SegmentId: c.Config.SegmentId,
 		Type:      exportType_Event,
@@ -67,7 +68,7 @@ func (c *Client) CreateExport(start time.Time, end time.Time, fields []string) (
 	}
 
 	url := fmt.Sprintf("%s/segments/v1/exports", c.Config.ApiURL)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(reqBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
 	if err != nil {
 		return "", err
 	}
@@ -88,20 +89,14 @@ func (c *Client) CreateExport(start time.Time, end time.Time, fields []string) (
 	return resp.OperationId, err
 }
 
-func (c *Client) GetExportProgress(operationId string) (int, string, error) {
-	resp, err := c.getExportOperation(operationId)
-	if err != nil {
-		return 0, "", err
-	}
-	if resp.State == operationComplete {
-		return resp.EstimatedPctComplete, resp.Results.SearchExportId, nil
-	}
-	return resp.EstimatedPctComplete, "", nil
+func (c *Client) GetExportProgress(ctx context.Context, operationId string) (int, string, error) {
+	status, err := c.GetExportStatus(ctx, operationId)
+	return status.Progress, status.ExportId, err
 }
 
-func (c *Client) GetExport(exportId string) (io.ReadCloser, error) {
+func (c *Client) GetExport(ctx context.Context, exportId string) (io.ReadCloser, error) {
 	url := fmt.Sprintf("%s/search/v1/exports/%s/results", c.Config.ApiURL, exportId)
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -118,7 +113,11 @@ func (c *Client) GetExport(exportId string) (io.ReadCloser, error) {
 
 	// Use a vanilla http client for downloading the stream since auth
 	// is built into the URL itself.
-	streamRsp, err := http.Get(rsp.Location)
+	streamReq, err := http.NewRequestWithContext(ctx, "GET", rsp.Location, nil)
+	if err != nil {
+		return nil, err
+	}
+	streamRsp, err := http.DefaultClient.Do(streamReq)
 	if err != nil {
 		return nil, err
 	}
@@ -1,10 +1,12 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 )
 
 type operationType string
@@ -37,10 +39,30 @@ func (o *operationsResponse) Err() error {
 	return ExportError{Details: o.ErrorDetails}
 }
 
-func (c *Client) getExportOperation(operationId string) (*operationsResponse, error) {
+// GetExportStatus returns the progress of an export operation, and the id and expiry of its results
+// once it has completed.
+func (c *Client) GetExportStatus(ctx context.Context, operationId string) (ExportStatus, error) {
+	resp, err := c.getExportOperation(ctx, operationId)
+	if err != nil {
+		return ExportStatus{}, err
+	}
+	status := ExportStatus{Progress: resp.EstimatedPctComplete}
+	if resp.State != operationComplete {
+		return status, nil
+	}
+	status.ExportId = resp.Results.SearchExportId
+	if resp.Results.Expires != "" {
+		if status.Expires, err = time.Parse(time.RFC3339, resp.Results.Expires); err != nil {
+			return ExportStatus{}, err
+		}
+	}
+	return status, nil
+}
+
+func (c *Client) getExportOperation(ctx context.Context, operationId string) (*operationsResponse, error) {
 	resp := &operationsResponse{}
 	url := fmt.Sprintf("%s/operations/v1/%s", c.Config.ApiURL, operationId)
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
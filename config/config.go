@@ -6,6 +6,8 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"regexp"
+	"text/template"
 	"time"
 
 	"github.com/BurntSushi/toml"
@@ -19,14 +21,61 @@ const (
 	DefaultExportDuration = 1 * time.Hour
 	MinExportDuration     = 15 * time.Minute
 	MaxExportDuration     = 24 * time.Hour
+
+	DefaultLocalExportTable = "fsexport"
+	DefaultLocalSyncTable   = "fssync"
 )
 
+// columnNamePattern matches column names and prefixes that don't need to be quoted in any of the databases.
+var columnNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 type Provider string
 
 const (
-	LocalProvider Provider = "local"
-	AWSProvider   Provider = "aws"
-	GCProvider    Provider = "gcp"
+	LocalProvider      Provider = "local"
+	AWSProvider        Provider = "aws"
+	GCProvider         Provider = "gcp"
+	SnowflakeProvider  Provider = "snowflake"
+	PostgresProvider   Provider = "postgres"
+	MySQLProvider      Provider = "mysql"
+	ClickHouseProvider Provider = "clickhouse"
+	KafkaProvider      Provider = "kafka"
+	// ElasticsearchProvider also works with OpenSearch, which has the same bulk and search APIs
+	ElasticsearchProvider Provider = "elasticsearch"
+	AzureProvider         Provider = "azure"
+	SFTPProvider          Provider = "sftp"
+)
+
+// FileFormat is the format of the files that exports are converted to before they are
+// saved to storage and loaded into the database.
+type FileFormat string
+
+const (
+	CSVFormat     FileFormat = "csv"
+	ParquetFormat FileFormat = "parquet"
+	AvroFormat    FileFormat = "avro"
+	// JSONFormat is used for newline-delimited JSON files when SaveAsJson is set.
+	JSONFormat FileFormat = "json"
+)
+
+// ColumnNaming is the naming convention for the columns of the export table.
+type ColumnNaming string
+
+const (
+	// DefaultNaming uses the export field names, e.g. "EventTargetSelector".
+	DefaultNaming   ColumnNaming = ""
+	SnakeCaseNaming ColumnNaming = "snake_case"
+	LowercaseNaming ColumnNaming = "lowercase"
+)
+
+// SchemaMigrations controls the migrations of existing columns whose types are narrower than the schema.
+type SchemaMigrations string
+
+const (
+	// DryRunMigrations logs the planned migrations without running them.
+	DryRunMigrations SchemaMigrations = "dry-run"
+	// ApplyMigrations runs the planned migrations.
+	ApplyMigrations SchemaMigrations = "apply"
 )
 
 type Config struct {
@@ -39,6 +88,9 @@ type Config struct {
 	AdditionalHttpHeader []Header
 	Backoff              Duration
 	BackoffStepsMax      int
+	// BackoffMaxElapsed limits the time spent retrying a failing export, in addition to BackoffStepsMax.
+	// It's unlimited by default.
+	BackoffMaxElapsed Duration
 	// Deprecated
 	CheckInterval Duration
 	TmpDir        string
@@ -47,14 +99,41 @@ type Config struct {
 	// Deprecated: use ExportDuration
 	GroupFilesByDay bool
 	SaveAsJson      bool
-	StorageOnly     bool
-	StartTime       time.Time
+	// FileFormat defaults to "csv". Parquet files can be kept in storage, or loaded into Redshift or BigQuery.
+	// Avro files can be kept in storage or loaded into BigQuery.
+	FileFormat FileFormat
+	// ExplodeCustomVars adds a typed column for each custom var, based on the suffix of its name.
+	// Custom vars without a known suffix are kept in the CustomVars column.
+	ExplodeCustomVars bool
+	// AddNewExportFields adds a column for export fields that aren't known to hauser, instead of storing them
+	// in CustomVars. Their types are inferred from the values in the export.
+	AddNewExportFields bool
+	StorageOnly        bool
+	StartTime          time.Time
 
 	// The segment to export. Defaults to the "everyone" segment, which will export all data.
 	SegmentId string
 
 	IncludeMobileAppsFields bool
 
+	// SchemaFile is a TOML file that lists the export fields, their columns and their types. It replaces
+	// the built-in schema, so IncludeMobileAppsFields has no effect when it is set.
+	SchemaFile string
+
+	// ColumnNaming converts the export field names to "snake_case" or "lowercase" column names, and
+	// ColumnPrefix is prepended to them. Columns of an existing export table keep their names.
+	ColumnNaming ColumnNaming
+	ColumnPrefix string
+
+	// SchemaMigrations widens the types of existing columns on startup, e.g. INTEGER to BIGINT, if set to
+	// "apply". "dry-run" only logs the planned migrations.
+	SchemaMigrations SchemaMigrations
+
+	// ExtraColumns are added to every row, keyed by column name. Their values are templates, which can use
+	// {{now}} for the time the rows are written and {{env "NAME"}} for an environment variable.
+	// A column whose value is exactly "{{now}}" is a timestamp, the others are strings.
+	ExtraColumns map[string]string
+
 	ApiURL string
 
 	FilePrefix string
@@ -69,6 +148,30 @@ type Config struct {
 
 	// local filesystem: Local
 	Local LocalConfig
+
+	// snowflake: Local + Snowflake (files are uploaded to an internal stage)
+	Snowflake SnowflakeConfig
+
+	// postgres: Local + Postgres (files are streamed with COPY FROM STDIN)
+	Postgres PostgresConfig
+
+	// mysql: Local + MySQL/MariaDB (files are loaded with LOAD DATA LOCAL INFILE)
+	MySQL MySQLConfig
+
+	// clickhouse: Local + ClickHouse (files are inserted over the HTTP interface)
+	ClickHouse ClickHouseConfig
+
+	// kafka: Local + Kafka (each record is published as a JSON message)
+	Kafka KafkaConfig
+
+	// elasticsearch: Local + Elasticsearch or OpenSearch (records are indexed with the bulk API)
+	Elasticsearch ElasticsearchConfig
+
+	// azure: Azure Blob Storage (storage only)
+	Azure AzureConfig
+
+	// sftp: SFTP server (storage only)
+	SFTP SFTPConfig
 }
 
 type Header struct {
@@ -85,6 +188,20 @@ type S3Config struct {
 	Bucket  string
 	Region  string
 	Timeout Duration
+	// Endpoint is the URL of an S3-compatible service such as MinIO, Ceph or R2. If empty, AWS is used.
+	Endpoint string
+	// ForcePathStyle addresses buckets as "<Endpoint>/<Bucket>" instead of "<Bucket>.<Endpoint>",
+	// which most S3-compatible services require.
+	ForcePathStyle bool
+	// AccessKeyID and SecretAccessKey are static credentials. If empty, credentials come from the
+	// environment or from the shared credentials file, using Profile if it is set.
+	AccessKeyID     string
+	SecretAccessKey string
+	Profile         string
+	// CACertFile is a PEM bundle of additional certificate authorities to trust, for endpoints
+	// with a private CA. InsecureSkipVerify disables certificate verification entirely.
+	CACertFile         string
+	InsecureSkipVerify bool
 	// Deprecated: Use `StorageOnly` option instead
 	S3Only bool
 }
@@ -100,7 +217,13 @@ type RedshiftConfig struct {
 	DatabaseSchema string
 	Credentials    string
 	VarCharMax     int
-	S3Region       string `toml:"-"`
+	// NativeCustomVars uses the SUPER type for the CustomVars column when it is created.
+	NativeCustomVars bool
+	// MigrateCustomVars converts an existing VARCHAR CustomVars column to SUPER on startup.
+	// It implies NativeCustomVars.
+	MigrateCustomVars bool
+	S3Region          string     `toml:"-"`
+	FileFormat        FileFormat `toml:"-"`
 }
 
 type GCSConfig struct {
@@ -110,12 +233,126 @@ type GCSConfig struct {
 	GCSOnly bool
 }
 
+type AzureConfig struct {
+	StorageConfig
+	// ConnectionString is the storage account connection string, which includes the credentials.
+	// If empty, AccountName or ServiceURL is used together with SASToken.
+	ConnectionString string
+	AccountName      string
+	// ServiceURL overrides the blob service endpoint, e.g. "http://127.0.0.1:10000/devstoreaccount1" for Azurite.
+	ServiceURL string
+	SASToken   string
+	Container  string
+	Timeout    Duration
+}
+
+type SFTPConfig struct {
+	StorageConfig
+	Host string
+	// Port defaults to 22
+	Port     string
+	User     string
+	Password string
+	// PrivateKeyFile is the path of a PEM or OpenSSH private key used instead of, or in addition to, Password.
+	// A leading "~/" is expanded to the home directory, here and in KnownHostsFile.
+	PrivateKeyFile       string
+	PrivateKeyPassphrase string
+	// KnownHostsFile is used to verify the server's host key, e.g. "~/.ssh/known_hosts".
+	// InsecureIgnoreHostKey skips verification and should only be used for testing.
+	KnownHostsFile        string
+	InsecureIgnoreHostKey bool
+	// Directory on the server that files are uploaded to. Relative paths start in the user's home directory.
+	Directory string
+	Timeout   Duration
+}
+
 type BigQueryConfig struct {
 	Project             string
 	Dataset             string
 	ExportTable         string
 	SyncTable           string
 	PartitionExpiration Duration
+	// NativeCustomVars uses the JSON type for the CustomVars column when it is created.
+	NativeCustomVars bool
+	// MigrateCustomVars converts an existing STRING CustomVars column to JSON on startup.
+	// It implies NativeCustomVars.
+	MigrateCustomVars bool
+	FileFormat        FileFormat `toml:"-"`
+}
+
+type SnowflakeConfig struct {
+	Account        string
+	User           string
+	Password       string
+	Role           string
+	Warehouse      string
+	DB             string
+	DatabaseSchema string
+	ExportTable    string
+	SyncTable      string
+	// Stage is the name of the internal stage that export files are uploaded to before
+	// being copied into the export table. If empty, the export table's stage is used.
+	Stage string
+}
+
+type PostgresConfig struct {
+	Host     string
+	Port     string
+	DB       string
+	User     string
+	Password string
+	// SSLMode is passed through to the connection string. If empty, the driver default ("require") is used.
+	SSLMode        string
+	ExportTable    string
+	SyncTable      string
+	DatabaseSchema string
+}
+
+type MySQLConfig struct {
+	Host        string
+	Port        string
+	DB          string
+	User        string
+	Password    string
+	ExportTable string
+	SyncTable   string
+}
+
+type ClickHouseConfig struct {
+	// URL of the HTTP interface, e.g. "http://localhost:8123"
+	URL         string
+	DB          string
+	User        string
+	Password    string
+	ExportTable string
+	SyncTable   string
+	Timeout     Duration
+}
+
+type KafkaConfig struct {
+	Brokers []string
+	Topic   string
+	// KeyField is the export field whose value is used as the message key, e.g. "UserId" or "SessionId".
+	// If empty, messages are published without a key.
+	KeyField string
+	// BatchSize is the number of messages sent in a single request. Defaults to 1000.
+	BatchSize int
+	Timeout   Duration
+}
+
+type ElasticsearchConfig struct {
+	// URL of the cluster, e.g. "https://localhost:9200"
+	URL      string
+	User     string
+	Password string
+	// APIKey is the base64 encoded API key. If set, it is used instead of User and Password.
+	APIKey string
+	// IndexPrefix is the prefix of the daily export indices, which are named "<IndexPrefix>-YYYY.MM.DD".
+	IndexPrefix string
+	SyncIndex   string
+	// BatchSize is the number of documents sent in a single bulk request. Defaults to 1000.
+	BatchSize int
+	Timeout   Duration
 }
 
 type Duration struct {
@@ -130,6 +367,11 @@ type LocalConfig struct {
 	// This forces the exports to start at the provided StartTime instead of using the
 	// any sync file that might exist.
 	UseStartTime bool
+	// DatabaseFile is the path of a SQLite database that the exports are loaded into.
+	// If empty, the local provider only saves the export files.
+	DatabaseFile string
+	ExportTable  string
+	SyncTable    string
 }
 
 func (d *Duration) UnmarshalText(text []byte) error {
@@ -192,6 +434,10 @@ func Validate(conf *Config, getNow func() time.Time) error {
 		return errors.New(`"ExportDelay" configuration value is too small. Minimum value is 1 hour`)
 	}
 
+	if conf.BackoffMaxElapsed.Duration < 0 {
+		return errors.New("BackoffMaxElapsed must not be negative")
+	}
+
 	// Ensure a sane start time and make sure it's in UTC
 	if conf.StartTime.IsZero() {
 		log.Println(`INFO: "StartTime" not set in config. Defaulting to 30 days in the past`)
@@ -222,30 +468,183 @@ func Validate(conf *Config, getNow func() time.Time) error {
 		conf.Warehouse = ""
 	}
 
+	if conf.SchemaFile != "" && conf.IncludeMobileAppsFields {
+		log.Println(`WARNING: "IncludeMobileAppsFields" is ignored when "SchemaFile" is set. Add the mobile fields to the schema file instead.`)
+	}
+
+	switch conf.ColumnNaming {
+	case DefaultNaming, SnakeCaseNaming, LowercaseNaming:
+	default:
+		return fmt.Errorf("ColumnNaming '%s' unrecognized", conf.ColumnNaming)
+	}
+	if conf.ColumnPrefix != "" && !columnNamePattern.MatchString(conf.ColumnPrefix) {
+		return fmt.Errorf("ColumnPrefix '%s' must start with a letter or underscore and only contain letters, digits and underscores", conf.ColumnPrefix)
+	}
+
+	for name, value := range conf.ExtraColumns {
+		if !columnNamePattern.MatchString(name) {
+			return fmt.Errorf("ExtraColumns name '%s' must start with a letter or underscore and only contain letters, digits and underscores", name)
+		}
+		if _, err := ParseExtraColumn(name, value); err != nil {
+			return fmt.Errorf("ExtraColumns value of '%s' is invalid: %s", name, err)
+		}
+	}
+
+	switch conf.SchemaMigrations {
+	case "", DryRunMigrations, ApplyMigrations:
+	default:
+		return fmt.Errorf("SchemaMigrations '%s' unrecognized", conf.SchemaMigrations)
+	}
+
+	switch conf.FileFormat {
+	case "":
+		conf.FileFormat = CSVFormat
+	case CSVFormat, ParquetFormat, AvroFormat:
+	default:
+		return fmt.Errorf("FileFormat '%s' unrecognized", conf.FileFormat)
+	}
+
 	switch conf.Provider {
 	case LocalProvider:
-		// The local provider only supports storage
-		log.Println(`WARNING: The "local" provider only supports "StorageOnly = true".
+		if conf.Local.DatabaseFile == "" {
+			// Without a database file, the local provider only supports storage
+			log.Println(`WARNING: The "local" provider only supports "StorageOnly = true" unless "DatabaseFile" is set.
           This value will be ignored in your configuration file.`)
-		conf.StorageOnly = true
+			conf.StorageOnly = true
+		} else {
+			if conf.Local.ExportTable == "" {
+				conf.Local.ExportTable = DefaultLocalExportTable
+			}
+			if conf.Local.SyncTable == "" {
+				conf.Local.SyncTable = DefaultLocalSyncTable
+			}
+		}
 		conf.Local.FilePrefix = conf.FilePrefix
 	case AWSProvider:
 		conf.StorageOnly = conf.StorageOnly || conf.S3.S3Only
 
+		if (conf.S3.AccessKeyID == "") != (conf.S3.SecretAccessKey == "") {
+			return errors.New("S3 AccessKeyID and SecretAccessKey must be set together")
+		}
+		if conf.S3.Endpoint != "" {
+			if !conf.StorageOnly {
+				// Redshift copies from S3 itself, so it can't reach a custom endpoint
+				return errors.New("a custom S3 Endpoint is only supported with StorageOnly = true")
+			}
+			if conf.S3.Region == "" {
+				// Requests still need to be signed for a region, which most S3-compatible services ignore
+				conf.S3.Region = "us-east-1"
+			}
+		}
 		if !conf.StorageOnly {
 			// Redshift needs to know which region the storage is in. Make sure they match
 			conf.Redshift.S3Region = conf.S3.Region
+			conf.Redshift.FileFormat = conf.LoadFormat()
 		}
 		conf.S3.S3Only = false
 		conf.S3.FilePrefix = conf.FilePrefix
 	case GCProvider:
 		conf.StorageOnly = conf.StorageOnly || conf.GCS.GCSOnly
+		if !conf.StorageOnly {
+			conf.BigQuery.FileFormat = conf.LoadFormat()
+		}
 		conf.GCS.GCSOnly = false
 		conf.GCS.FilePrefix = conf.FilePrefix
+	case AzureProvider:
+		// There is no database on Azure yet, so only storage is supported
+		if !conf.StorageOnly {
+			log.Println(`WARNING: The "azure" provider only supports "StorageOnly = true".
+          This value will be ignored in your configuration file.`)
+			conf.StorageOnly = true
+		}
+		if conf.Azure.Container == "" {
+			return errors.New("the azure provider requires Container to be set")
+		}
+		if conf.Azure.ConnectionString == "" && conf.Azure.SASToken == "" {
+			return errors.New("the azure provider requires either ConnectionString or SASToken to be set")
+		}
+		conf.Azure.FilePrefix = conf.FilePrefix
+	case SFTPProvider:
+		// Files are only dropped on the server, there is no database to load them into
+		if !conf.StorageOnly {
+			log.Println(`WARNING: The "sftp" provider only supports "StorageOnly = true".
+          This value will be ignored in your configuration file.`)
+			conf.StorageOnly = true
+		}
+		if conf.SFTP.Host == "" || conf.SFTP.User == "" {
+			return errors.New("the sftp provider requires Host and User to be set")
+		}
+		if conf.SFTP.Password == "" && conf.SFTP.PrivateKeyFile == "" {
+			return errors.New("the sftp provider requires either Password or PrivateKeyFile to be set")
+		}
+		if conf.SFTP.KnownHostsFile == "" && !conf.SFTP.InsecureIgnoreHostKey {
+			return errors.New("the sftp provider requires KnownHostsFile to verify the server")
+		}
+		conf.SFTP.FilePrefix = conf.FilePrefix
+	case SnowflakeProvider, PostgresProvider, MySQLProvider, ClickHouseProvider, KafkaProvider, ElasticsearchProvider:
+		// Export files are staged on local disk before they are loaded into the database
+		conf.Local.FilePrefix = conf.FilePrefix
+		if conf.Provider == PostgresProvider && conf.Postgres.DatabaseSchema == "" {
+			conf.Postgres.DatabaseSchema = "public"
+		}
+		if conf.Provider == ClickHouseProvider && conf.ClickHouse.DB == "" {
+			conf.ClickHouse.DB = "default"
+		}
+		if conf.Provider == KafkaProvider && !conf.StorageOnly && (len(conf.Kafka.Brokers) == 0 || conf.Kafka.Topic == "") {
+			return errors.New("the kafka provider requires both Brokers and Topic to be set")
+		}
+		if conf.Provider == ElasticsearchProvider && !conf.StorageOnly {
+			if conf.Elasticsearch.URL == "" {
+				return errors.New("the elasticsearch provider requires URL to be set")
+			}
+			if conf.Elasticsearch.IndexPrefix == "" {
+				conf.Elasticsearch.IndexPrefix = DefaultLocalExportTable
+			}
+			if conf.Elasticsearch.SyncIndex == "" {
+				conf.Elasticsearch.SyncIndex = DefaultLocalSyncTable
+			}
+		}
 	}
 
-	if conf.SaveAsJson && !(conf.Provider == "local" || conf.StorageOnly) {
-		return fmt.Errorf("hauser doesn't currently support loading JSON into a database. Ensure SaveAsJson = false in .toml file")
+	if conf.FileFormat != CSVFormat {
+		if conf.SaveAsJson {
+			return errors.New(`"SaveAsJson" cannot be combined with a "FileFormat"`)
+		}
+		if !conf.StorageOnly && conf.Provider != AWSProvider && conf.Provider != GCProvider {
+			return fmt.Errorf("FileFormat '%s' can only be loaded into Redshift or BigQuery", conf.FileFormat)
+		}
+		if conf.FileFormat == AvroFormat && !conf.StorageOnly && conf.Provider != GCProvider {
+			return fmt.Errorf("FileFormat '%s' can only be loaded into BigQuery", conf.FileFormat)
+		}
+	}
+
+	if conf.SaveAsJson && !conf.StorageOnly && conf.Provider != AWSProvider && conf.Provider != GCProvider {
+		return fmt.Errorf("hauser only supports loading JSON into Redshift or BigQuery. Ensure SaveAsJson = false in .toml file")
 	}
 	return nil
 }
+
+// LoadFormat returns the format of the files that are loaded into the database.
+func (conf *Config) LoadFormat() FileFormat {
+	if conf.SaveAsJson {
+		return JSONFormat
+	}
+	if conf.Provider == KafkaProvider && !conf.StorageOnly {
+		// Each line is published as a message
+		return JSONFormat
+	}
+	return conf.FileFormat
+}
+
+// extraColumnFuncs are the functions that can be used in the templates of ExtraColumns.
+var extraColumnFuncs = template.FuncMap{
+	"now": func() string {
+		return time.Now().UTC().Format(time.RFC3339Nano)
+	},
+	"env": os.Getenv,
+}
+
+// ParseExtraColumn parses the template of an extra column's value.
+func ParseExtraColumn(name, value string) (*template.Template, error) {
+	return template.New(name).Funcs(extraColumnFuncs).Parse(value)
+}
@@ -38,6 +38,7 @@ func TestValidate(t *testing.T) {
 				SegmentId:      DefaultSegmentId,
 				ExportDuration: Duration{time.Hour},
 				ExportDelay:    Duration{24 * time.Hour},
+				FileFormat:     CSVFormat,
 				StartTime:      now.Add(-1 * 24 * 30 * time.Hour),
 				Local: LocalConfig{
 					SaveDir: "tmp",
@@ -60,6 +61,7 @@ func TestValidate(t *testing.T) {
 				SegmentId:      "abcd1234",
 				ExportDuration: Duration{time.Hour},
 				ExportDelay:    Duration{24 * time.Hour},
+				FileFormat:     CSVFormat,
 				StartTime:      now.Add(-1 * 24 * 30 * time.Hour),
 				Local: LocalConfig{
 					SaveDir: "tmp",
@@ -83,6 +85,7 @@ func TestValidate(t *testing.T) {
 				SegmentId:      DefaultSegmentId,
 				ExportDuration: Duration{time.Hour},
 				ExportDelay:    Duration{24 * time.Hour},
+				FileFormat:     CSVFormat,
 				StartTime:      now.Add(-1 * 24 * 30 * time.Hour),
 				Local: LocalConfig{
 					SaveDir: "tmp",
@@ -105,6 +108,7 @@ func TestValidate(t *testing.T) {
 				SegmentId:      DefaultSegmentId,
 				ExportDuration: Duration{time.Hour},
 				ExportDelay:    Duration{24 * time.Hour},
+				FileFormat:     CSVFormat,
 				StartTime:      now.Add(-1 * 24 * 30 * time.Hour),
 				S3: S3Config{
 					Bucket:  "bucket",
@@ -112,7 +116,8 @@ func TestValidate(t *testing.T) {
 					Timeout: Duration{5 * time.Minute},
 				},
 				Redshift: RedshiftConfig{
-					S3Region: "us-east-2",
+					S3Region:   "us-east-2",
+					FileFormat: CSVFormat,
 				},
 			},
 		},
@@ -134,6 +139,7 @@ func TestValidate(t *testing.T) {
 				SegmentId:      DefaultSegmentId,
 				ExportDuration: Duration{time.Hour},
 				ExportDelay:    Duration{24 * time.Hour},
+				FileFormat:     CSVFormat,
 				StartTime:      now.Add(-1 * 24 * 30 * time.Hour),
 				S3: S3Config{
 					Bucket:  "bucket",
@@ -157,11 +163,40 @@ func TestValidate(t *testing.T) {
 				SegmentId:      DefaultSegmentId,
 				ExportDuration: Duration{time.Hour},
 				ExportDelay:    Duration{24 * time.Hour},
+				FileFormat:     CSVFormat,
 				StartTime:      now.Add(-1 * 24 * 30 * time.Hour),
 				GCS: GCSConfig{
 					Bucket: "bucket",
 				},
-				BigQuery: BigQueryConfig{},
+				BigQuery: BigQueryConfig{
+					FileFormat: CSVFormat,
+				},
+			},
+		},
+		{
+			name: "gcp json",
+			conf: &Config{
+				Provider:   "gcp",
+				SaveAsJson: true,
+				GCS: GCSConfig{
+					Bucket: "bucket",
+				},
+			},
+			expected: &Config{
+				Provider:       "gcp",
+				SaveAsJson:     true,
+				ApiURL:         DefaultApiURL,
+				SegmentId:      DefaultSegmentId,
+				ExportDuration: Duration{time.Hour},
+				ExportDelay:    Duration{24 * time.Hour},
+				FileFormat:     CSVFormat,
+				StartTime:      now.Add(-1 * 24 * 30 * time.Hour),
+				GCS: GCSConfig{
+					Bucket: "bucket",
+				},
+				BigQuery: BigQueryConfig{
+					FileFormat: JSONFormat,
+				},
 			},
 		},
 		{
@@ -182,12 +217,267 @@ func TestValidate(t *testing.T) {
 				SegmentId:      DefaultSegmentId,
 				ExportDuration: Duration{time.Hour},
 				ExportDelay:    Duration{24 * time.Hour},
+				FileFormat:     CSVFormat,
 				StartTime:      now.Add(-1 * 24 * 30 * time.Hour),
 				GCS: GCSConfig{
 					Bucket: "bucket",
 				},
 			},
 		},
+		{
+			name: "snowflake",
+			conf: &Config{
+				Provider:   "snowflake",
+				FilePrefix: "hauser/",
+				Local: LocalConfig{
+					SaveDir: "tmp",
+				},
+				Snowflake: SnowflakeConfig{
+					DB:             "db",
+					DatabaseSchema: "PUBLIC",
+				},
+			},
+			expected: &Config{
+				Provider:       "snowflake",
+				FilePrefix:     "hauser/",
+				ApiURL:         DefaultApiURL,
+				SegmentId:      DefaultSegmentId,
+				ExportDuration: Duration{time.Hour},
+				ExportDelay:    Duration{24 * time.Hour},
+				FileFormat:     CSVFormat,
+				StartTime:      now.Add(-1 * 24 * 30 * time.Hour),
+				Local: LocalConfig{
+					StorageConfig: StorageConfig{FilePrefix: "hauser/"},
+					SaveDir:       "tmp",
+				},
+				Snowflake: SnowflakeConfig{
+					DB:             "db",
+					DatabaseSchema: "PUBLIC",
+				},
+			},
+		},
+		{
+			name: "postgres default schema",
+			conf: &Config{
+				Provider: "postgres",
+				Local: LocalConfig{
+					SaveDir: "tmp",
+				},
+			},
+			expected: &Config{
+				Provider:       "postgres",
+				ApiURL:         DefaultApiURL,
+				SegmentId:      DefaultSegmentId,
+				ExportDuration: Duration{time.Hour},
+				ExportDelay:    Duration{24 * time.Hour},
+				FileFormat:     CSVFormat,
+				StartTime:      now.Add(-1 * 24 * 30 * time.Hour),
+				Local: LocalConfig{
+					SaveDir: "tmp",
+				},
+				Postgres: PostgresConfig{
+					DatabaseSchema: "public",
+				},
+			},
+		},
+		{
+			name: "mysql",
+			conf: &Config{
+				Provider:   "mysql",
+				FilePrefix: "hauser/",
+				Local: LocalConfig{
+					SaveDir: "tmp",
+				},
+				MySQL: MySQLConfig{
+					Host: "localhost",
+					Port: "3306",
+					DB:   "hauser",
+				},
+			},
+			expected: &Config{
+				Provider:       "mysql",
+				FilePrefix:     "hauser/",
+				ApiURL:         DefaultApiURL,
+				SegmentId:      DefaultSegmentId,
+				ExportDuration: Duration{time.Hour},
+				ExportDelay:    Duration{24 * time.Hour},
+				FileFormat:     CSVFormat,
+				StartTime:      now.Add(-1 * 24 * 30 * time.Hour),
+				Local: LocalConfig{
+					StorageConfig: StorageConfig{FilePrefix: "hauser/"},
+					SaveDir:       "tmp",
+				},
+				MySQL: MySQLConfig{
+					Host: "localhost",
+					Port: "3306",
+					DB:   "hauser",
+				},
+			},
+		},
+		{
+			name: "local with database",
+			conf: &Config{
+				Provider: "local",
+				Local: LocalConfig{
+					SaveDir:      "tmp",
+					DatabaseFile: "hauser.db",
+				},
+			},
+			expected: &Config{
+				Provider:       "local",
+				ApiURL:         DefaultApiURL,
+				SegmentId:      DefaultSegmentId,
+				ExportDuration: Duration{time.Hour},
+				ExportDelay:    Duration{24 * time.Hour},
+				FileFormat:     CSVFormat,
+				StartTime:      now.Add(-1 * 24 * 30 * time.Hour),
+				Local: LocalConfig{
+					SaveDir:      "tmp",
+					DatabaseFile: "hauser.db",
+					ExportTable:  DefaultLocalExportTable,
+					SyncTable:    DefaultLocalSyncTable,
+				},
+			},
+		},
+		{
+			name: "local with database, json",
+			conf: &Config{
+				Provider:   "local",
+				SaveAsJson: true,
+				Local: LocalConfig{
+					SaveDir:      "tmp",
+					DatabaseFile: "hauser.db",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "kafka without topic",
+			conf: &Config{
+				Provider: "kafka",
+				Kafka: KafkaConfig{
+					Brokers: []string{"localhost:9092"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "parquet into postgres",
+			conf: &Config{
+				Provider:   "postgres",
+				FileFormat: ParquetFormat,
+			},
+			wantErr: true,
+		},
+		{
+			name: "avro into redshift",
+			conf: &Config{
+				Provider:   "aws",
+				FileFormat: AvroFormat,
+				S3: S3Config{
+					Bucket: "bucket",
+					Region: "us-east-2",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown file format",
+			conf: &Config{
+				Provider:   "local",
+				FileFormat: "xml",
+			},
+			wantErr: true,
+		},
+		{
+			name: "s3 endpoint with redshift",
+			conf: &Config{
+				Provider: "aws",
+				S3: S3Config{
+					Bucket:   "bucket",
+					Endpoint: "http://localhost:9000",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "sftp without known hosts",
+			conf: &Config{
+				Provider: "sftp",
+				SFTP: SFTPConfig{
+					Host:     "localhost",
+					User:     "partner",
+					Password: "secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "azure without credentials",
+			conf: &Config{
+				Provider: "azure",
+				Azure: AzureConfig{
+					Container: "hauser",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "elasticsearch without url",
+			conf: &Config{
+				Provider: "elasticsearch",
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown column naming",
+			conf: &Config{
+				Provider:     "local",
+				ColumnNaming: "camelCase",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid column prefix",
+			conf: &Config{
+				Provider:     "local",
+				ColumnNaming: SnakeCaseNaming,
+				ColumnPrefix: "fs-",
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown schema migrations",
+			conf: &Config{
+				Provider:         "local",
+				SchemaMigrations: "yes",
+			},
+			wantErr: true,
+		},
+		{
+			name: "bad extra column name",
+			conf: &Config{
+				Provider:     "local",
+				ExtraColumns: map[string]string{"Org Id": "abc"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "bad extra column template",
+			conf: &Config{
+				Provider:     "local",
+				ExtraColumns: map[string]string{"LoadedAt": "{{today}}"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative backoff max elapsed",
+			conf: &Config{
+				Provider:          "local",
+				BackoffMaxElapsed: Duration{Duration: -time.Hour},
+			},
+			wantErr: true,
+		},
 		{
 			name: "bad delay Duration",
 			conf: &Config{
@@ -227,3 +517,24 @@ func TestValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadFormat(t *testing.T) {
+	testCases := []struct {
+		conf     Config
+		expected FileFormat
+	}{
+		{conf: Config{Provider: LocalProvider, FileFormat: CSVFormat}, expected: CSVFormat},
+		{conf: Config{Provider: AWSProvider, FileFormat: CSVFormat, SaveAsJson: true}, expected: JSONFormat},
+		{conf: Config{Provider: KafkaProvider, FileFormat: CSVFormat}, expected: JSONFormat},
+		{conf: Config{Provider: KafkaProvider, FileFormat: CSVFormat, StorageOnly: true}, expected: CSVFormat},
+	}
+	for i, tc := range testCases {
+		testutils.Equals(t, tc.expected, tc.conf.LoadFormat(), "wrong format for test case %d", i)
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	conf, err := Load("../example-config.toml")
+	testutils.Assert(t, err == nil, "failed to load example config: %s", err)
+	testutils.Equals(t, LocalProvider, conf.Provider, "wrong provider")
+}
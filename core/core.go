@@ -2,6 +2,7 @@ package core
 
 import (
 	"context"
+	"fmt"
 	"log"
 
 	"cloud.google.com/go/storage"
@@ -11,41 +12,86 @@ import (
 	"github.com/fullstorydev/hauser/warehouse"
 )
 
-func NewHauser(config *config.Config, fsClient client.DataExportClient, storage warehouse.Storage, db warehouse.Database) *internal.HauserService {
+func NewHauser(config *config.Config, fsClient client.DataExportClient, storage warehouse.Storage, db warehouse.DatabaseV2) *internal.HauserService {
 	return internal.NewHauserService(config, fsClient, storage, db)
 }
 
 func MakeStorage(ctx context.Context, conf *config.Config) warehouse.Storage {
+	s, err := newStorage(ctx, conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return s
+}
+
+func MakeDatabase(_ context.Context, conf *config.Config) warehouse.DatabaseV2 {
+	db, err := newDatabase(conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return db
+}
+
+func newStorage(ctx context.Context, conf *config.Config) (warehouse.Storage, error) {
 	switch conf.Provider {
-	case config.LocalProvider:
-		return warehouse.NewLocalDisk(&conf.Local)
+	case config.LocalProvider, config.SnowflakeProvider, config.PostgresProvider, config.MySQLProvider, config.ClickHouseProvider, config.KafkaProvider, config.ElasticsearchProvider:
+		localDisk, err := warehouse.NewLocalDisk(&conf.Local)
+		if err != nil {
+			return nil, err
+		}
+		return localDisk, nil
 	case config.AWSProvider:
-		return warehouse.NewS3Storage(&conf.S3)
+		return warehouse.NewS3Storage(&conf.S3), nil
 	case config.GCProvider:
 		gcsClient, err := storage.NewClient(ctx)
 		if err != nil {
-			log.Fatalf("Failed to create GCS client")
+			return nil, fmt.Errorf("failed to create GCS client: %s", err)
+		}
+		return warehouse.NewGCSStorage(&conf.GCS, gcsClient), nil
+	case config.AzureProvider:
+		azureStorage, err := warehouse.NewAzureBlobStorage(&conf.Azure)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Azure storage: %s", err)
 		}
-		return warehouse.NewGCSStorage(&conf.GCS, gcsClient)
+		return azureStorage, nil
+	case config.SFTPProvider:
+		return warehouse.NewSFTPStorage(&conf.SFTP), nil
 	default:
-		log.Fatalf("unknown provider type: %s", conf.Provider)
+		return nil, fmt.Errorf("unknown provider type: %s", conf.Provider)
 	}
-	return nil
 }
 
-func MakeDatabase(_ context.Context, conf *config.Config) warehouse.Database {
+// newDatabase returns nil without an error if StorageOnly is set.
+func newDatabase(conf *config.Config) (warehouse.DatabaseV2, error) {
 	if conf.StorageOnly {
-		return nil
+		return nil, nil
 	}
 	switch conf.Provider {
 	case config.LocalProvider:
-		log.Fatalf("cannot initialize database for local provider")
+		return warehouse.NewSQLite(&conf.Local), nil
 	case config.AWSProvider:
-		return warehouse.NewRedshift(&conf.Redshift)
+		// The jsonpaths file for JSON loads is uploaded to the same bucket as the exports
+		return warehouse.NewRedshift(&conf.Redshift, warehouse.NewS3Storage(&conf.S3)), nil
 	case config.GCProvider:
-		return warehouse.NewBigQuery(&conf.BigQuery)
+		return warehouse.NewBigQuery(&conf.BigQuery), nil
+	case config.SnowflakeProvider:
+		return warehouse.NewSnowflake(&conf.Snowflake), nil
+	case config.PostgresProvider:
+		return warehouse.NewPostgres(&conf.Postgres), nil
+	case config.MySQLProvider:
+		return warehouse.NewMySQL(&conf.MySQL), nil
+	case config.ClickHouseProvider:
+		return warehouse.NewClickHouse(&conf.ClickHouse), nil
+	case config.KafkaProvider:
+		// Kafka has nowhere to keep sync points, so they are saved next to the staged files
+		localDisk, err := warehouse.NewLocalDisk(&conf.Local)
+		if err != nil {
+			return nil, err
+		}
+		return warehouse.NewKafka(&conf.Kafka, localDisk), nil
+	case config.ElasticsearchProvider:
+		return warehouse.NewElasticsearch(&conf.Elasticsearch), nil
 	default:
-		log.Fatalf("unknown provider type: %s", conf.Provider)
+		return nil, fmt.Errorf("unknown provider type: %s", conf.Provider)
 	}
-	return nil
 }
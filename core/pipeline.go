@@ -0,0 +1,125 @@
+package core
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/fullstorydev/hauser/client"
+	"github.com/fullstorydev/hauser/config"
+	"github.com/fullstorydev/hauser/internal"
+	"github.com/fullstorydev/hauser/warehouse"
+)
+
+// Hooks are called as a Pipeline processes the exports. Any of them can be nil.
+type Hooks = internal.Hooks
+
+// Pipeline exports the data of a FullStory org to the storage and database of a config, so that hauser
+// can be embedded in another program instead of running the binary.
+type Pipeline struct {
+	service *internal.HauserService
+}
+
+type pipelineOptions struct {
+	client   client.DataExportClient
+	storage  warehouse.Storage
+	database warehouse.DatabaseV2
+	logger   *log.Logger
+	now      func() time.Time
+	hooks    Hooks
+}
+
+// Option changes a part of a Pipeline.
+type Option func(*pipelineOptions)
+
+// WithClient sets the client of the FullStory Data Export API.
+func WithClient(c client.DataExportClient) Option {
+	return func(o *pipelineOptions) {
+		o.client = c
+	}
+}
+
+// WithStorage sets the storage that the export files are saved to.
+func WithStorage(s warehouse.Storage) Option {
+	return func(o *pipelineOptions) {
+		o.storage = s
+	}
+}
+
+// WithDatabase sets the database that the export files are loaded into. A Database can be
+// used with warehouse.NewDatabaseAdapter.
+func WithDatabase(db warehouse.DatabaseV2) Option {
+	return func(o *pipelineOptions) {
+		o.database = db
+	}
+}
+
+// WithLogger sets the logger of the pipeline. The storage and databases log with the standard logger.
+func WithLogger(l *log.Logger) Option {
+	return func(o *pipelineOptions) {
+		o.logger = l
+	}
+}
+
+// WithClock sets the function that returns the current time, which decides when the next export is
+// ready and is used for the "now" function of ExtraColumns.
+func WithClock(now func() time.Time) Option {
+	return func(o *pipelineOptions) {
+		o.now = now
+	}
+}
+
+// WithHooks sets the functions that are called as the exports are processed.
+func WithHooks(hooks Hooks) Option {
+	return func(o *pipelineOptions) {
+		o.hooks = hooks
+	}
+}
+
+// NewPipeline returns a Pipeline for a config that has been loaded with config.Load or checked with
+// config.Validate. The client, storage and database are made from the config unless they are set
+// with options.
+func NewPipeline(ctx context.Context, conf *config.Config, opts ...Option) (*Pipeline, error) {
+	var o pipelineOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.client == nil {
+		o.client = client.NewClient(conf)
+	}
+	if o.storage == nil {
+		s, err := newStorage(ctx, conf)
+		if err != nil {
+			return nil, err
+		}
+		o.storage = s
+	}
+	if o.database == nil {
+		db, err := newDatabase(conf)
+		if err != nil {
+			return nil, err
+		}
+		o.database = db
+	}
+
+	service := internal.NewHauserService(conf, o.client, o.storage, o.database)
+	if o.logger != nil {
+		service.SetLogger(o.logger)
+	}
+	if o.now != nil {
+		service.SetClock(o.now)
+	}
+	service.SetHooks(o.hooks)
+	return &Pipeline{service: service}, nil
+}
+
+// Run processes the exports until the context is cancelled, retrying failed exports with the backoff
+// of the config. It returns nil once it has stopped because of the context.
+func (p *Pipeline) Run(ctx context.Context) error {
+	return p.service.Run(ctx)
+}
+
+// RunOnce processes the exports that are ready and returns the error of the first export that fails.
+func (p *Pipeline) RunOnce(ctx context.Context) error {
+	return p.service.RunOnce(ctx)
+}
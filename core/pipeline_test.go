@@ -0,0 +1,32 @@
+package core
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fullstorydev/hauser/config"
+	"github.com/fullstorydev/hauser/testing/testutils"
+)
+
+func TestNewPipelineMissingSaveDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hauser-core")
+	testutils.Assert(t, err == nil, "failed to create temp dir: %s", err)
+	defer os.RemoveAll(dir)
+
+	for _, provider := range []config.Provider{config.LocalProvider, config.KafkaProvider} {
+		conf := &config.Config{
+			Provider: provider,
+			Local:    config.LocalConfig{SaveDir: filepath.Join(dir, "missing")},
+		}
+		_, err := NewPipeline(context.Background(), conf)
+		testutils.Assert(t, err != nil, "expected an error for a missing SaveDir with %s", provider)
+
+		conf.Local.SaveDir = dir
+		conf.StorageOnly = true
+		p, err := NewPipeline(context.Background(), conf)
+		testutils.Assert(t, err == nil && p != nil, "failed to create pipeline with %s: %v", provider, err)
+	}
+}
@@ -4,11 +4,21 @@ require (
 	cloud.google.com/go v0.45.1
 	cloud.google.com/go/bigquery v1.0.1
 	cloud.google.com/go/pubsub v1.0.1 // indirect
+	github.com/Azure/azure-storage-blob-go v0.13.0
 	github.com/BurntSushi/toml v0.3.1
 	github.com/aws/aws-sdk-go v1.34.0
+	github.com/go-sql-driver/mysql v1.6.0
 	github.com/lib/pq v1.2.0
+	github.com/linkedin/goavro/v2 v2.10.1
 	github.com/pkg/errors v0.9.1
+	github.com/pkg/sftp v1.13.5
+	github.com/segmentio/kafka-go v0.4.32
+	github.com/snowflakedb/gosnowflake v1.4.3
+	github.com/xitongsys/parquet-go v1.5.2
+	github.com/xitongsys/parquet-go-source v0.0.0-20190524061010-2b72cbee77d5
+	golang.org/x/crypto v0.0.0-20211215153901-e495a2d5b3d3
 	honnef.co/go/tools v0.0.1-2020.1.5 // indirect
+	modernc.org/sqlite v1.17.3
 )
 
 go 1.16
@@ -0,0 +1,241 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/fullstorydev/hauser/warehouse"
+)
+
+// addedFieldsFile keeps the fields added by AddNewExportFields, so that they are still requested
+// after a restart. It is saved next to the sync file in storage.
+const addedFieldsFile = ".fields.hauser"
+
+type addedField struct {
+	Name   string
+	DBName string
+	Type   string
+}
+
+func (h *HauserService) columnNamer() warehouse.ColumnNamer {
+	return warehouse.ColumnNamer{
+		Naming: h.config.ColumnNaming,
+		Prefix: h.config.ColumnPrefix,
+	}
+}
+
+// logUnknownField logs a standard export field that isn't part of the schema, once per field.
+func (h *HauserService) logUnknownField(name string) {
+	if h.unknownFields == nil {
+		h.unknownFields = make(map[string]bool)
+	}
+	if h.unknownFields[strings.ToLower(name)] {
+		return
+	}
+	h.unknownFields[strings.ToLower(name)] = true
+	h.logger.Printf("Found new export field %s, storing it in CustomVars. Set AddNewExportFields to add a column for it.", name)
+}
+
+// addNewColumns saves the export to a temporary file and adds a column for each custom var (if ExplodeCustomVars is set)
+// and each new export field (if AddNewExportFields is set) that doesn't have one yet, so that the columns are known
+// before any record is written. The returned file is positioned at the start of the export.
+func (h *HauserService) addNewColumns(ctx context.Context, stream io.Reader, name string) (*os.File, error) {
+	filename := filepath.Join(h.config.TmpDir, name)
+	if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.scanForNewColumns(ctx, f, stream); err != nil {
+		f.Close()
+		os.Remove(filename)
+		return nil, err
+	}
+	return f, nil
+}
+
+func (h *HauserService) scanForNewColumns(ctx context.Context, f *os.File, stream io.Reader) error {
+	if _, err := io.Copy(f, stream); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	customVars, exportFields, err := h.findNewFields(f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	newFields := append(customVars, exportFields...)
+	if len(newFields) == 0 {
+		return nil
+	}
+	newSchema := append(h.schema[:len(h.schema):len(h.schema)], newFields...)
+	h.logger.Printf("Adding %d columns for new fields", len(newFields))
+	if !h.config.StorageOnly {
+		if err := h.database.ApplyExportSchema(ctx, newSchema); err != nil {
+			return err
+		}
+	}
+	h.schema = newSchema
+	h.schemaMap = nil
+	if len(exportFields) > 0 {
+		h.addedFields = append(h.addedFields, exportFields...)
+		return h.saveAddedFields(ctx)
+	}
+	return nil
+}
+
+// findNewFields returns the fields in the export that don't have a column yet. Custom vars without a known type suffix
+// are skipped, they are kept in CustomVars. The types of new export fields are inferred from their values, and
+// fields whose values have conflicting types are added as strings.
+func (h *HauserService) findNewFields(stream io.Reader) (customVars, exportFields []warehouse.WarehouseField, err error) {
+	existing := make(map[string]bool, len(h.schema))
+	for _, field := range h.schema {
+		existing[strings.ToLower(field.DBName)] = true
+		existing[strings.ToLower(field.FullStoryFieldName)] = true
+	}
+
+	decoder := json.NewDecoder(stream)
+	decoder.UseNumber()
+	if _, err := decoder.Token(); err != nil {
+		return nil, nil, err
+	}
+	namer := h.columnNamer()
+	found := make(map[string]int)
+	for decoder.More() {
+		var r Record
+		if err := decoder.Decode(&r); err != nil {
+			return nil, nil, err
+		}
+		for key, val := range r {
+			lowerKey := strings.ToLower(key)
+			if existing[lowerKey] {
+				continue
+			}
+			if warehouse.IsCustomVar(key) {
+				if !h.config.ExplodeCustomVars {
+					continue
+				}
+				field, ok := warehouse.CustomVarField(key)
+				field.DBName = namer.ColumnName(field.DBName)
+				if ok && !existing[strings.ToLower(field.DBName)] {
+					existing[lowerKey] = true
+					existing[strings.ToLower(field.DBName)] = true
+					customVars = append(customVars, field)
+				}
+				continue
+			}
+
+			if !h.config.AddNewExportFields {
+				continue
+			}
+			field, ok := warehouse.InferField(key, val)
+			if !ok {
+				continue
+			}
+			field.DBName = namer.ColumnName(field.DBName)
+			if i, ok := found[lowerKey]; ok {
+				exportFields[i].FieldType = mergeInferredTypes(exportFields[i].FieldType, field.FieldType)
+				continue
+			}
+			if existing[strings.ToLower(field.DBName)] {
+				continue
+			}
+			h.logger.Printf("Found new export field %s with type %s", key, field.FieldType)
+			found[lowerKey] = len(exportFields)
+			exportFields = append(exportFields, field)
+		}
+	}
+	// Records are maps, so sort the fields to get the same columns regardless of the iteration order
+	for _, fields := range [][]warehouse.WarehouseField{customVars, exportFields} {
+		sort.Slice(fields, func(i, j int) bool {
+			return fields[i].DBName < fields[j].DBName
+		})
+	}
+	return customVars, exportFields, nil
+}
+
+// mergeInferredTypes returns a type that can hold the values of both types. Integers are widened
+// if other values of the field have a fraction, and any other conflict falls back to a string.
+func mergeInferredTypes(a, b reflect.Type) reflect.Type {
+	intType, floatType := reflect.TypeOf(int64(0)), reflect.TypeOf(float64(0))
+	switch {
+	case a == b:
+		return a
+	case (a == intType && b == floatType) || (a == floatType && b == intType):
+		return floatType
+	default:
+		return reflect.TypeOf("")
+	}
+}
+
+func (h *HauserService) saveAddedFields(ctx context.Context) error {
+	fields := make([]addedField, len(h.addedFields))
+	for i, field := range h.addedFields {
+		fields[i] = addedField{
+			Name:   field.FullStoryFieldName,
+			DBName: field.DBName,
+			Type:   warehouse.FieldTypeName(field.FieldType),
+		}
+	}
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	fn := filepath.Join(h.storage.GetFilePrefix(), addedFieldsFile)
+	if _, err := h.storage.SaveFile(ctx, fn, bytes.NewReader(b)); err != nil {
+		return fmt.Errorf("failed to save added fields: %s", err)
+	}
+	return nil
+}
+
+// loadAddedFields appends the fields that were added by a previous run to the schema.
+func (h *HauserService) loadAddedFields(ctx context.Context) error {
+	fn := filepath.Join(h.storage.GetFilePrefix(), addedFieldsFile)
+	r, err := h.storage.ReadFile(ctx, fn)
+	if err == warehouse.ErrFileNotFound {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read added fields: %s", err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read added fields: %s", err)
+	}
+	var fields []addedField
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return fmt.Errorf("failed to parse added fields: %s", err)
+	}
+	for _, f := range fields {
+		fieldType, err := warehouse.ParseFieldType(f.Type)
+		if err != nil {
+			return fmt.Errorf("failed to parse added field %s: %s", f.Name, err)
+		}
+		field := warehouse.WarehouseField{
+			DBName:             f.DBName,
+			FullStoryFieldName: f.Name,
+			FieldType:          fieldType,
+		}
+		h.addedFields = append(h.addedFields, field)
+		if warehouse.IndexField(field, h.schema) == -1 {
+			h.schema = append(h.schema, field)
+		}
+	}
+	h.schemaMap = nil
+	return nil
+}
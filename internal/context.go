@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"context"
+	"time"
+)
+
+// sleep waits for the duration, or returns the error of the context if it's cancelled first.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// detachedContext keeps the values of its parent, but is never cancelled. It's used for the work
+// that has to finish once it has started, such as loading a bundle and saving its sync point.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/fullstorydev/hauser/config"
+	"github.com/fullstorydev/hauser/warehouse"
+)
+
+// loadExtraColumns parses the templates of the ExtraColumns and appends their fields to the schema,
+// in the order of their names. The fields have no FullStoryFieldName since they aren't part of the export.
+func (h *HauserService) loadExtraColumns() error {
+	names := make([]string, 0, len(h.config.ExtraColumns))
+	for name := range h.config.ExtraColumns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h.extraColumns = make(map[string]*template.Template, len(names))
+	for _, name := range names {
+		value := h.config.ExtraColumns[name]
+		if field := h.schema.GetFieldForName(name); field.FullStoryFieldName != "" {
+			return fmt.Errorf("extra column %s conflicts with the column of export field %s", name, field.FullStoryFieldName)
+		}
+		tmpl, err := config.ParseExtraColumn(name, value)
+		if err != nil {
+			return err
+		}
+		// The values use the clock of the service, so that they match the time of the exports
+		tmpl.Funcs(template.FuncMap{"now": func() string {
+			return h.now().UTC().Format(time.RFC3339Nano)
+		}})
+		fieldType := reflect.TypeOf("")
+		if strings.TrimSpace(value) == "{{now}}" {
+			fieldType = reflect.TypeOf(time.Time{})
+		}
+		h.schema = append(h.schema, warehouse.WarehouseField{
+			DBName:    name,
+			FieldType: fieldType,
+		})
+		h.extraColumns[strings.ToLower(name)] = tmpl
+	}
+	return nil
+}
+
+// extraColumnValues executes the templates of the ExtraColumns, so that all rows of an export get the same values.
+func (h *HauserService) extraColumnValues() (map[string]string, error) {
+	values := make(map[string]string, len(h.extraColumns))
+	for name, tmpl := range h.extraColumns {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, nil); err != nil {
+			return nil, fmt.Errorf("failed to execute the template of extra column %s: %s", name, err)
+		}
+		values[name] = buf.String()
+	}
+	return values, nil
+}
@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fullstorydev/hauser/client"
+)
+
+// operationFile keeps the export operation that is in progress, so that it can be resumed instead of
+// creating a duplicate export after a restart. It is saved in TmpDir.
+const operationFile = ".operation.hauser"
+
+// exportOperation is an export that has been created but not loaded yet.
+type exportOperation struct {
+	OperationId string
+	Start       time.Time
+	End         time.Time
+	Fields      []string
+	// ExportId is set once the export has completed
+	ExportId string
+	// Expires is when the results of the completed export expire, if the client reports it
+	Expires time.Time
+}
+
+func (op *exportOperation) matches(start, end time.Time, fields []string) bool {
+	if !op.Start.Equal(start) || !op.End.Equal(end) || len(op.Fields) != len(fields) {
+		return false
+	}
+	for i := range fields {
+		if op.Fields[i] != fields[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *HauserService) operationFilename() string {
+	return filepath.Join(h.config.TmpDir, h.config.FilePrefix+operationFile)
+}
+
+// openExport returns the contents of the export for the time range. An operation that was saved by a previous
+// run is resumed if it's for the same time range and fields, and its results haven't expired. Otherwise a new
+// export is created.
+func (h *HauserService) openExport(ctx context.Context, start, end time.Time, fields []string) (io.ReadCloser, error) {
+	op, err := h.loadOperation()
+	if err != nil {
+		return nil, err
+	}
+	if op != nil {
+		if !op.matches(start, end, fields) {
+			op = nil
+		} else if !op.Expires.IsZero() && !h.now().Before(op.Expires) {
+			h.logger.Printf("Export operation %s expired at %s", op.OperationId, op.Expires)
+			op = nil
+		}
+	}
+	if op != nil {
+		h.logger.Printf("Resuming export operation %s for %s to %s", op.OperationId, start, end)
+		body, err := h.downloadExport(ctx, op)
+		if err == nil || ctx.Err() != nil {
+			return body, err
+		}
+		h.logger.Printf("Failed to resume export operation %s, creating a new export: %s", op.OperationId, err)
+	}
+
+	h.logger.Printf("Creating export for %s to %s", start, end)
+	id, err := h.fsClient.CreateExport(ctx, start, end, fields)
+	if err != nil {
+		return nil, err
+	}
+	op = &exportOperation{
+		OperationId: id,
+		Start:       start,
+		End:         end,
+		Fields:      fields,
+	}
+	if err := h.saveOperation(op); err != nil {
+		return nil, err
+	}
+	return h.downloadExport(ctx, op)
+}
+
+// downloadExport waits until the export operation has completed and returns the contents of the export.
+func (h *HauserService) downloadExport(ctx context.Context, op *exportOperation) (io.ReadCloser, error) {
+	for op.ExportId == "" {
+		status, err := h.getExportStatus(ctx, op.OperationId)
+		if err != nil {
+			return nil, err
+		}
+		h.logger.Printf("Export progress: %d%%", status.Progress)
+		if status.ExportId != "" {
+			op.ExportId, op.Expires = status.ExportId, status.Expires
+			if err := h.saveOperation(op); err != nil {
+				return nil, err
+			}
+			break
+		}
+		if err := sleep(ctx, progressPollDuration); err != nil {
+			return nil, err
+		}
+	}
+
+	h.logger.Printf("Fetching export for operation id %s", op.OperationId)
+	return h.fsClient.GetExport(ctx, op.ExportId)
+}
+
+// getExportStatus uses the status of the client if it reports the expiry of the export, which takes the
+// same request as the progress.
+func (h *HauserService) getExportStatus(ctx context.Context, operationId string) (client.ExportStatus, error) {
+	if c, ok := h.fsClient.(client.ExportStatusClient); ok {
+		return c.GetExportStatus(ctx, operationId)
+	}
+	prog, exportId, err := h.fsClient.GetExportProgress(ctx, operationId)
+	return client.ExportStatus{Progress: prog, ExportId: exportId}, err
+}
+
+// loadOperation returns the export operation saved by a previous run, or nil if there is none.
+func (h *HauserService) loadOperation() (*exportOperation, error) {
+	b, err := ioutil.ReadFile(h.operationFilename())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read export operation: %s", err)
+	}
+	var op exportOperation
+	if err := json.Unmarshal(b, &op); err != nil {
+		// The operation is only an optimization, so a corrupted file is ignored
+		h.logger.Printf("Ignoring invalid export operation file %s: %s", h.operationFilename(), err)
+		return nil, nil
+	}
+	return &op, nil
+}
+
+func (h *HauserService) saveOperation(op *exportOperation) error {
+	b, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	fn := h.operationFilename()
+	if err := os.MkdirAll(filepath.Dir(fn), 0777); err != nil {
+		return err
+	}
+	// Written to a temporary file first, so that a restart never leaves a partial file behind
+	tmp := fn + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0666); err != nil {
+		return fmt.Errorf("failed to save export operation: %s", err)
+	}
+	return os.Rename(tmp, fn)
+}
+
+// removeOperation is called once the export has been stored, so that it isn't resumed.
+func (h *HauserService) removeOperation() {
+	if err := os.Remove(h.operationFilename()); err != nil && !os.IsNotExist(err) {
+		h.logger.Printf("Failed to remove export operation file: %s", err)
+	}
+}
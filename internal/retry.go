@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/fullstorydev/hauser/client"
+	"github.com/fullstorydev/hauser/config"
+)
+
+const (
+	// Default duration Hauser will wait before retrying a 429 or 5xx response. If Retry-After is specified, uses that instead. Default arbitrarily set to 10s.
+	defaultRetryAfterDuration time.Duration = time.Duration(10) * time.Second
+)
+
+var (
+	// Provided as global variable for mocking
+	jitter = func(d time.Duration) time.Duration {
+		// Waits between half and all of the backoff, so that several processes don't retry in lockstep
+		return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+)
+
+// errorClass decides how a failed export is retried.
+type errorClass int
+
+const (
+	// retryableError is retried with an exponential backoff.
+	retryableError errorClass = iota
+	// rateLimitedError is retried after the delay requested by the API.
+	rateLimitedError
+	// fatalError is not retried, e.g. a request that was rejected because the API token was revoked.
+	fatalError
+)
+
+func (c errorClass) String() string {
+	switch c {
+	case rateLimitedError:
+		return "rate limited"
+	case fatalError:
+		return "fatal"
+	default:
+		return "retryable"
+	}
+}
+
+func getRetryInfo(err error) (bool, time.Duration) {
+	var statusError client.StatusError
+	if errors.As(err, &statusError) {
+		// Client errors won't succeed when they're retried, except for timeouts and rate limiting
+		if statusError.StatusCode >= 400 && statusError.StatusCode < 500 &&
+			statusError.StatusCode != http.StatusRequestTimeout && statusError.StatusCode != http.StatusTooManyRequests {
+			return false, defaultRetryAfterDuration
+		}
+
+		if statusError.RetryAfter > 0 {
+			return true, statusError.RetryAfter
+		}
+	}
+
+	return true, defaultRetryAfterDuration
+}
+
+// classifyError returns the class of an error, and the delay requested by the API for rate limited errors.
+// Errors of the storage and database are retryable, since they are usually caused by the network.
+func classifyError(err error) (errorClass, time.Duration) {
+	doRetry, retryAfter := getRetryInfo(err)
+	if !doRetry {
+		return fatalError, 0
+	}
+	var statusError client.StatusError
+	if errors.As(err, &statusError) && statusError.RetryAfter > 0 {
+		return rateLimitedError, retryAfter
+	}
+	return retryableError, 0
+}
+
+// retryPolicy decides whether and when a failed export is retried. It counts the consecutive failures,
+// which are limited by BackoffStepsMax and BackoffMaxElapsed, until an export succeeds.
+type retryPolicy struct {
+	backoff    time.Duration
+	maxRetries int
+	maxElapsed time.Duration
+
+	retries      int
+	firstFailure time.Time
+}
+
+func newRetryPolicy(conf *config.Config) *retryPolicy {
+	return &retryPolicy{
+		backoff:    conf.Backoff.Duration,
+		maxRetries: conf.BackoffStepsMax,
+		maxElapsed: conf.BackoffMaxElapsed.Duration,
+	}
+}
+
+// next returns the delay before the failed export is retried, or an error if it shouldn't be retried.
+func (p *retryPolicy) next(err error, now time.Time) (time.Duration, error) {
+	class, delay := classifyError(err)
+	if class == fatalError {
+		return 0, fmt.Errorf("not retrying %s error: %s", class, err)
+	}
+	if p.retries == 0 {
+		p.firstFailure = now
+	}
+	if p.retries == p.maxRetries {
+		return 0, fmt.Errorf("reached max retries: %s", err)
+	}
+	if class == retryableError {
+		delay = jitter(p.backoff * (1 << p.retries))
+	}
+	if p.maxElapsed > 0 && now.Add(delay).Sub(p.firstFailure) > p.maxElapsed {
+		return 0, fmt.Errorf("retries would exceed BackoffMaxElapsed of %s: %s", p.maxElapsed, err)
+	}
+	p.retries++
+	return delay, nil
+}
+
+// reset is called once an export succeeds.
+func (p *retryPolicy) reset() {
+	p.retries = 0
+}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/hauser/client"
+	"github.com/fullstorydev/hauser/config"
+	"github.com/fullstorydev/hauser/testing/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestClassifyError(t *testing.T) {
+	testCases := []struct {
+		err      error
+		expClass errorClass
+		expDelay time.Duration
+	}{
+		{
+			err:      errors.New("connection reset"),
+			expClass: retryableError,
+		},
+		{
+			err:      client.StatusError{StatusCode: http.StatusServiceUnavailable},
+			expClass: retryableError,
+		},
+		{
+			err:      client.StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: 3 * time.Second},
+			expClass: rateLimitedError,
+			expDelay: 3 * time.Second,
+		},
+		{
+			err:      fmt.Errorf("failed to create export: %w", client.StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Minute}),
+			expClass: rateLimitedError,
+			expDelay: time.Minute,
+		},
+		{
+			err:      client.StatusError{StatusCode: http.StatusUnauthorized},
+			expClass: fatalError,
+		},
+		{
+			err:      client.StatusError{StatusCode: http.StatusForbidden, RetryAfter: 3 * time.Second},
+			expClass: fatalError,
+		},
+		{
+			err:      client.StatusError{StatusCode: http.StatusNotFound},
+			expClass: fatalError,
+		},
+		{
+			err:      client.StatusError{StatusCode: http.StatusBadRequest},
+			expClass: fatalError,
+		},
+		{
+			err:      client.StatusError{StatusCode: http.StatusUnprocessableEntity},
+			expClass: fatalError,
+		},
+		{
+			err:      client.StatusError{StatusCode: http.StatusRequestTimeout},
+			expClass: retryableError,
+		},
+	}
+	for i, tc := range testCases {
+		class, delay := classifyError(tc.err)
+		testutils.Equals(t, tc.expClass, class, "wrong class for test case %d", i)
+		testutils.Equals(t, tc.expDelay, delay, "wrong delay for test case %d", i)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	jitter = func(d time.Duration) time.Duration { return d }
+	now := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	failure := errors.New("connection reset")
+
+	p := newRetryPolicy(&config.Config{
+		Backoff:         config.Duration{Duration: time.Second},
+		BackoffStepsMax: 3,
+	})
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		delay, err := p.next(failure, now)
+		Ok(t, err, "unexpected error before max retries")
+		testutils.Equals(t, expected, delay, "wrong backoff")
+	}
+	_, err := p.next(failure, now)
+	testutils.Assert(t, err != nil, "expected an error after max retries")
+
+	// The backoff starts over once an export succeeds, and the API decides the delay when rate limited
+	p.reset()
+	delay, err := p.next(client.StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Minute}, now)
+	Ok(t, err, "unexpected error when rate limited")
+	testutils.Equals(t, time.Minute, delay, "wrong delay when rate limited")
+	delay, err = p.next(failure, now)
+	Ok(t, err, "unexpected error after rate limit")
+	testutils.Equals(t, 2*time.Second, delay, "wrong backoff after rate limit")
+
+	p.reset()
+	_, err = p.next(client.StatusError{StatusCode: http.StatusUnauthorized}, now)
+	testutils.Assert(t, err != nil, "expected an error for a fatal error")
+
+	p = newRetryPolicy(&config.Config{
+		Backoff:           config.Duration{Duration: time.Minute},
+		BackoffStepsMax:   10,
+		BackoffMaxElapsed: config.Duration{Duration: 5 * time.Minute},
+	})
+	_, err = p.next(failure, now)
+	Ok(t, err, "unexpected error for the first retry")
+	_, err = p.next(failure, now.Add(time.Minute))
+	Ok(t, err, "unexpected error for the second retry")
+	// Waiting another 4 minutes would exceed the maximum elapsed time
+	_, err = p.next(failure, now.Add(3*time.Minute))
+	testutils.Assert(t, err != nil, "expected an error after max elapsed time")
+}
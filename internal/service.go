@@ -8,11 +8,11 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/fullstorydev/hauser/client"
@@ -20,15 +20,6 @@ import (
 	"github.com/fullstorydev/hauser/warehouse"
 )
 
-var (
-	currentBackoffStep = uint(0)
-)
-
-const (
-	// Default duration Hauser will wait before retrying a 429 or 5xx response. If Retry-After is specified, uses that instead. Default arbitrarily set to 10s.
-	defaultRetryAfterDuration time.Duration = time.Duration(10) * time.Second
-)
-
 var (
 	// Provided as global variable for mocking
 	getNow = func() time.Time {
@@ -40,17 +31,41 @@ var (
 // Record represents a single export row in the export file
 type Record map[string]interface{}
 
+// Hooks are called as the exports are processed, so that an embedding program can track the progress.
+// Any of them can be nil.
+type Hooks struct {
+	// ExportStarted is called before the export of the time range is created, or resumed after a restart.
+	ExportStarted func(start, end time.Time)
+	// ExportLoaded is called once the export of the time range is stored and its sync point is saved.
+	ExportLoaded func(start, end time.Time)
+	// ExportFailed is called when an export fails. Errors caused by cancelling the context are not reported.
+	ExportFailed func(err error)
+}
+
 type HauserService struct {
 	config   *config.Config
 	fsClient client.DataExportClient
 	storage  warehouse.Storage
-	database warehouse.Database
+	database warehouse.DatabaseV2
 	schema   warehouse.Schema
 	// cached map of the schema for translating json records
 	schemaMap map[string]bool
+	// export fields that aren't part of the schema, which have already been logged
+	unknownFields map[string]bool
+	// export fields that were added to the schema because AddNewExportFields is set
+	addedFields []warehouse.WarehouseField
+	// templates of the ExtraColumns, keyed by lowercased column name
+	extraColumns map[string]*template.Template
+	// values of the ExtraColumns for the export that is being written
+	extraValues map[string]string
+	logger      *log.Logger
+	now         func() time.Time
+	hooks       Hooks
+	retry       *retryPolicy
+	initialized bool
 }
 
-func NewHauserService(config *config.Config, fsClient client.DataExportClient, storage warehouse.Storage, db warehouse.Database) *HauserService {
+func NewHauserService(config *config.Config, fsClient client.DataExportClient, storage warehouse.Storage, db warehouse.DatabaseV2) *HauserService {
 	fields := []interface{}{
 		warehouse.BaseExportFields{},
 	}
@@ -63,9 +78,27 @@ func NewHauserService(config *config.Config, fsClient client.DataExportClient, s
 		storage:  storage,
 		database: db,
 		schema:   warehouse.MakeSchema(fields...),
+		logger:   log.Default(),
+		now:      getNow,
+		retry:    newRetryPolicy(config),
 	}
 }
 
+// SetLogger sets the logger of the service, which uses the standard logger by default.
+func (h *HauserService) SetLogger(logger *log.Logger) {
+	h.logger = logger
+}
+
+// SetClock sets the function that returns the current time, which decides when the next export is ready.
+func (h *HauserService) SetClock(now func() time.Time) {
+	h.now = now
+}
+
+// SetHooks sets the functions that are called as the exports are processed.
+func (h *HauserService) SetHooks(hooks Hooks) {
+	h.hooks = hooks
+}
+
 // TransformExportJSONRecord transforms the record map (extracted from the API response json) to a
 // slice of strings. The slice of strings contains values in the same order as the existing export table.
 // For existing export table fields that do not exist in the json record, an empty string is populated.
@@ -89,6 +122,9 @@ func (h *HauserService) transformExportJSONRecord(convert warehouse.ValueToStrin
 	for key, val := range rec {
 		lowerKey := strings.ToLower(key)
 		if _, ok := h.schemaMap[lowerKey]; !ok {
+			if !warehouse.IsCustomVar(key) {
+				h.logUnknownField(key)
+			}
 			customVarsMap[key] = val
 		} else {
 			lowerRec[lowerKey] = val
@@ -97,11 +133,16 @@ func (h *HauserService) transformExportJSONRecord(convert warehouse.ValueToStrin
 
 	for _, field := range h.schema {
 		if field.FullStoryFieldName == "" {
-			// This is a column in the export table that doesn't come from the export
-			line = append(line, "")
+			// This is a column in the export table that doesn't come from the export, which is
+			// only filled if it's one of the ExtraColumns
+			if val, ok := h.extraValues[strings.ToLower(field.DBName)]; ok {
+				line = append(line, convert(val, field.IsTime()))
+			} else {
+				line = append(line, "")
+			}
 			continue
 		}
-		if field.DBName == "CustomVars" {
+		if field.FullStoryFieldName == "CustomVars" {
 			customVars, err := json.Marshal(customVarsMap)
 			if err != nil {
 				return nil, err
@@ -109,6 +150,15 @@ func (h *HauserService) transformExportJSONRecord(convert warehouse.ValueToStrin
 			line = append(line, string(customVars))
 		} else {
 			if val, valExists := lowerRec[strings.ToLower(field.FullStoryFieldName)]; valExists {
+				switch val.(type) {
+				case []interface{}, map[string]interface{}:
+					// Lists and objects are stored as JSON
+					b, err := json.Marshal(val)
+					if err != nil {
+						return nil, err
+					}
+					val = string(b)
+				}
 				line = append(line, convert(val, field.IsTime()))
 			} else {
 				line = append(line, "")
@@ -137,10 +187,13 @@ func (h *HauserService) LoadBundles(ctx context.Context, filename string, startT
 		return h.storage.SaveSyncPoint(ctx, endTime)
 	}
 
-	defer h.storage.DeleteFile(ctx, objName)
+	if h.config.Provider != config.LocalProvider {
+		// The local provider keeps its files in SaveDir, like it does without a database
+		defer h.storage.DeleteFile(ctx, objName)
+	}
 
-	if err := h.database.LoadToWarehouse(objRef, startTime); err != nil {
-		log.Printf("Failed to load file '%s' to warehouse: %s", filename, err)
+	if err := h.database.LoadToWarehouse(ctx, objRef, startTime); err != nil {
+		h.logger.Printf("Failed to load file '%s' to warehouse: %s", filename, err)
 		return err
 	}
 
@@ -149,27 +202,12 @@ func (h *HauserService) LoadBundles(ctx context.Context, filename string, startT
 	// records beyond the sync point and remove them - ie, we will reprocess the
 	// current export file
 	if err := h.database.SaveSyncPoint(ctx, endTime); err != nil {
-		log.Printf("Failed to save sync point for %s: %s", endTime, err)
+		h.logger.Printf("Failed to save sync point for %s: %s", endTime, err)
 		return err
 	}
 	return nil
 }
 
-func getRetryInfo(err error) (bool, time.Duration) {
-	if statusError, ok := err.(client.StatusError); ok {
-		// If the status code is NOT 429 and the code is below 500 we will not attempt to retry
-		if statusError.StatusCode != http.StatusTooManyRequests && statusError.StatusCode < 500 {
-			return false, defaultRetryAfterDuration
-		}
-
-		if statusError.RetryAfter > 0 {
-			return true, statusError.RetryAfter
-		}
-	}
-
-	return true, defaultRetryAfterDuration
-}
-
 // WriteBundleToCSV writes the bundle corresponding to the given bundleID to the csv Writer
 func (h *HauserService) WriteBundleToCSV(stream io.Reader, csvOut *csv.Writer) (numRecords int, err error) {
 	headers := make([]string, len(h.schema))
@@ -180,45 +218,117 @@ func (h *HauserService) WriteBundleToCSV(stream io.Reader, csvOut *csv.Writer) (
 		return 0, err
 	}
 
+	recordCount, err := h.writeBundle(stream, csvOut.Write)
+	if err != nil {
+		return recordCount, err
+	}
+	csvOut.Flush()
+	return recordCount, csvOut.Error()
+}
+
+// WriteBundleToParquet writes the bundle to out as a Parquet file, with a typed column for each schema field.
+func (h *HauserService) WriteBundleToParquet(stream io.Reader, out io.Writer) (numRecords int, err error) {
+	pw, err := warehouse.NewParquetWriter(out, h.schema)
+	if err != nil {
+		return 0, err
+	}
+	recordCount, err := h.writeBundle(stream, pw.Write)
+	if err != nil {
+		return recordCount, err
+	}
+	return recordCount, pw.Close()
+}
+
+// WriteBundleToAvro writes the bundle to out as an Avro container file, using a record schema
+// generated from the export schema.
+func (h *HauserService) WriteBundleToAvro(stream io.Reader, out io.Writer) (numRecords int, err error) {
+	aw, err := warehouse.NewAvroWriter(out, h.schema)
+	if err != nil {
+		return 0, err
+	}
+	recordCount, err := h.writeBundle(stream, aw.Write)
+	if err != nil {
+		return recordCount, err
+	}
+	return recordCount, aw.Close()
+}
+
+// WriteBundleToJSON writes the bundle to out as newline-delimited JSON, with one object per record.
+func (h *HauserService) WriteBundleToJSON(stream io.Reader, out io.Writer) (numRecords int, err error) {
+	nw := warehouse.NewNDJSONWriter(out, h.schema)
+	if db, ok := h.database.(warehouse.JSONCustomVarsDatabase); ok {
+		nw.CustomVarsAsObject = db.CustomVarsAsJSON()
+	}
+	return h.writeBundle(stream, nw.Write)
+}
+
+// writeBundle decodes the records of the export stream and passes each of them to write,
+// with values in the same order as the schema.
+func (h *HauserService) writeBundle(stream io.Reader, write func([]string) error) (int, error) {
 	decoder := json.NewDecoder(stream)
 	decoder.UseNumber()
 
 	// skip array open delimiter
 	if _, err := decoder.Token(); err != nil {
-		log.Printf("Failed json decode of array open token: %s", err)
+		h.logger.Printf("Failed json decode of array open token: %s", err)
+		return 0, err
+	}
+
+	var err error
+	if h.extraValues, err = h.extraColumnValues(); err != nil {
 		return 0, err
 	}
 
 	var recordCount int
+	convert := h.getValueConverter()
 	for decoder.More() {
 		var r Record
 		if err := decoder.Decode(&r); err != nil {
-			log.Printf("failed json decode of record: %s", err)
+			h.logger.Printf("failed json decode of record: %s", err)
 			return recordCount, err
 		}
-		line, err := h.transformExportJSONRecord(h.getValueConverter(), r)
+		line, err := h.transformExportJSONRecord(convert, r)
 		if err != nil {
-			log.Printf("Failed object transform, skipping record. %s", err)
+			h.logger.Printf("Failed object transform, skipping record. %s", err)
 			continue
 		}
-		csvOut.Write(line)
+		if err := write(line); err != nil {
+			return recordCount, err
+		}
 		recordCount++
 	}
 
 	if _, err := decoder.Token(); err != nil {
-		log.Printf("Failed json decode of array close token: %s", err)
+		h.logger.Printf("Failed json decode of array close token: %s", err)
 		return recordCount, err
 	}
-
-	csvOut.Flush()
 	return recordCount, nil
 }
 
 func (h *HauserService) getValueConverter() warehouse.ValueToStringFn {
-	if h.config.StorageOnly {
-		return warehouse.ValueToString
+	convert := warehouse.ValueToString
+	if !h.config.StorageOnly {
+		convert = h.database.ValueToString
+	}
+	switch h.config.LoadFormat() {
+	case config.CSVFormat:
+		return convert
+	case config.JSONFormat:
+		// JSON can hold any string, so only the times are converted
+		return func(val interface{}, isTime bool) string {
+			if isTime {
+				return warehouse.ValueToString(val, isTime)
+			}
+			return fmt.Sprintf("%v", val)
+		}
+	}
+	// Typed formats parse the times themselves, so they need a consistent format regardless of the database
+	return func(val interface{}, isTime bool) string {
+		if isTime {
+			return warehouse.ValueToString(val, isTime)
+		}
+		return convert(val, isTime)
 	}
-	return h.database.ValueToString
 }
 
 func (h *HauserService) lastSyncPoint(ctx context.Context) (time.Time, error) {
@@ -228,36 +338,76 @@ func (h *HauserService) lastSyncPoint(ctx context.Context) (time.Time, error) {
 	return h.database.LastSyncPoint(ctx)
 }
 
-func (h *HauserService) BackoffOnError(err error) bool {
+// backoffOnError waits before the failed export is retried, following the retry policy. The wait ends
+// early if the context is cancelled. It returns an error if the export shouldn't be retried.
+func (h *HauserService) backoffOnError(ctx context.Context, err error) error {
+	h.logger.Printf("failed to process exports: %s", err)
+	delay, err := h.retry.next(err, h.now())
 	if err != nil {
-		log.Printf("failed to process exports: %s", err)
-		if currentBackoffStep == uint(h.config.BackoffStepsMax) {
-			log.Fatalf("Reached max retries; exiting")
-		}
-		dur := h.config.Backoff.Duration * (1 << currentBackoffStep)
-		log.Printf("Pausing; will retry operation in %s", dur)
-		time.Sleep(dur)
-		currentBackoffStep++
-		return true
-	}
-	currentBackoffStep = 0
-	return false
+		return err
+	}
+	h.logger.Printf("Pausing; will retry operation in %s", delay)
+	sleep(ctx, delay)
+	return nil
 }
 
+// Init prepares the schema and the export table. Once it has succeeded, further calls do nothing.
 func (h *HauserService) Init(ctx context.Context) error {
+	if h.initialized {
+		return nil
+	}
+	schema, addedFields := h.schema, h.addedFields
+	if err := h.init(ctx); err != nil {
+		// Init can be retried, so the columns that were added before the failure are dropped
+		h.schema, h.addedFields = schema, addedFields
+		return err
+	}
+	h.initialized = true
+	return nil
+}
+
+func (h *HauserService) init(ctx context.Context) error {
+	if h.config.SchemaFile != "" {
+		schema, err := warehouse.LoadSchemaFile(h.config.SchemaFile)
+		if err != nil {
+			return err
+		}
+		h.schema = schema
+	}
+	h.schema = h.schema.WithColumnNames(h.columnNamer())
+	if err := h.loadExtraColumns(); err != nil {
+		return err
+	}
+	if h.config.AddNewExportFields {
+		if err := h.loadAddedFields(ctx); err != nil {
+			return err
+		}
+	}
 	if !h.config.StorageOnly {
 		return h.InitDatabase(ctx)
 	}
 	return nil
 }
 
-func (h *HauserService) InitDatabase(_ context.Context) error {
-	if created, err := h.database.InitExportTable(h.schema); err != nil {
+func (h *HauserService) InitDatabase(ctx context.Context) error {
+	if created, err := h.database.InitExportTable(ctx, h.schema); err != nil {
 		return err
 	} else if !created {
-		existingCols := h.database.GetExportTableColumns()
-		newSchema := h.schema.ReconcileWithExisting(existingCols)
-		if err := h.database.ApplyExportSchema(newSchema); err != nil {
+		newSchema, err := h.reconcileSchema(ctx)
+		if err != nil {
+			return err
+		}
+		if h.config.SchemaMigrations != "" {
+			if migrated, err := h.migrateSchema(ctx, newSchema); err != nil {
+				return err
+			} else if migrated {
+				// Rewritten columns are moved to the end of the table
+				if newSchema, err = h.reconcileSchema(ctx); err != nil {
+					return err
+				}
+			}
+		}
+		if err := h.database.ApplyExportSchema(ctx, newSchema); err != nil {
 			return err
 		}
 		h.schema = newSchema
@@ -265,8 +415,61 @@ func (h *HauserService) InitDatabase(_ context.Context) error {
 	return nil
 }
 
+// reconcileSchema returns the schema with the columns in the order of the existing export table.
+func (h *HauserService) reconcileSchema(ctx context.Context) (warehouse.Schema, error) {
+	columns, err := h.database.GetExportTableColumns(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get export table columns: %s", err)
+	}
+	newSchema := h.schema.ReconcileWithExisting(columns)
+	if h.config.ExplodeCustomVars {
+		newSchema = newSchema.WithCustomVarColumns(h.columnNamer())
+	}
+	return newSchema, nil
+}
+
+// migrateSchema plans the migrations of the existing columns, and applies them unless SchemaMigrations
+// is a dry run. It returns true if any migration was applied.
+func (h *HauserService) migrateSchema(ctx context.Context, s warehouse.Schema) (bool, error) {
+	db, ok := h.database.(warehouse.MigratingDatabase)
+	if !ok {
+		h.logger.Printf("SchemaMigrations is not supported by the %s provider", h.config.Provider)
+		return false, nil
+	}
+	plan, err := db.PlanMigrations(ctx, s)
+	if err != nil {
+		return false, fmt.Errorf("failed to plan schema migrations: %s", err)
+	}
+	if len(plan) == 0 {
+		h.logger.Printf("No schema migrations needed")
+		return false, nil
+	}
+	for _, m := range plan {
+		h.logger.Printf("Planned migration %s", m)
+		for _, stmt := range m.Statements {
+			h.logger.Printf("    %s", stmt)
+		}
+	}
+	if h.config.SchemaMigrations == config.DryRunMigrations {
+		h.logger.Printf("Dry run: %d migrations were not applied", len(plan))
+		return false, nil
+	}
+	if err := db.ApplyMigrations(ctx, plan); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // ProcessNext will process the next export, or return a duration until the next export is ready.
 func (h *HauserService) ProcessNext(ctx context.Context) (time.Duration, error) {
+	timeToWait, err := h.processNext(ctx)
+	if err != nil && ctx.Err() == nil && h.hooks.ExportFailed != nil {
+		h.hooks.ExportFailed(err)
+	}
+	return timeToWait, err
+}
+
+func (h *HauserService) processNext(ctx context.Context) (time.Duration, error) {
 	lastSyncedRecord, err := h.lastSyncPoint(ctx)
 	if err != nil {
 		return 0, err
@@ -284,37 +487,19 @@ func (h *HauserService) ProcessNext(ctx context.Context) (time.Duration, error)
 		UTC()
 
 	for {
-		lastAvailableEndTime := getNow().UTC().Add(-1 * h.config.ExportDelay.Duration)
+		lastAvailableEndTime := h.now().UTC().Add(-1 * h.config.ExportDelay.Duration)
 		if nextEndTime.After(lastAvailableEndTime) {
 			waitUntil := nextEndTime.Add(h.config.ExportDelay.Duration)
-			return waitUntil.Sub(getNow()), nil
+			return waitUntil.Sub(h.now()), nil
 		} else {
 			break
 		}
 	}
 
-	log.Printf("Creating export for %s to %s", lastSyncedRecord, nextEndTime)
-	id, err := h.fsClient.CreateExport(lastSyncedRecord, nextEndTime, h.schema.GetFullStoryFields())
-	if err != nil {
-		return 0, err
+	if h.hooks.ExportStarted != nil {
+		h.hooks.ExportStarted(lastSyncedRecord, nextEndTime)
 	}
-
-	var exportId string
-	var prog int
-	for {
-		prog, exportId, err = h.fsClient.GetExportProgress(id)
-		if err != nil {
-			return 0, err
-		}
-		log.Printf("Export progress: %d%%", prog)
-		if exportId != "" {
-			break
-		}
-		time.Sleep(progressPollDuration)
-	}
-
-	log.Printf("Fetching export for operation id %s", id)
-	body, err := h.fsClient.GetExport(exportId)
+	body, err := h.openExport(ctx, lastSyncedRecord, nextEndTime, h.schema.GetFullStoryFields())
 	if err != nil {
 		return 0, err
 	}
@@ -325,54 +510,124 @@ func (h *HauserService) ProcessNext(ctx context.Context) (time.Duration, error)
 		return 0, err
 	}
 
-	if h.config.SaveAsJson {
-		// Short circuit since we don't support loading json into the database
+	if h.config.SaveAsJson && h.config.StorageOnly {
+		// Short circuit since the export is already JSON
 		fname := fmt.Sprintf("%s%d.json", h.config.FilePrefix, lastSyncedRecord.Unix())
 		if _, err := h.storage.SaveFile(ctx, fname, unzipped); err != nil {
 			return 0, err
 		}
-		return 0, h.storage.SaveSyncPoint(ctx, nextEndTime)
+		if err := h.storage.SaveSyncPoint(ctx, nextEndTime); err != nil {
+			return 0, err
+		}
+		h.exportDone(lastSyncedRecord, nextEndTime)
+		return 0, nil
 	}
 
-	filename := filepath.Join(h.config.TmpDir, fmt.Sprintf("%s%d.csv", h.config.FilePrefix, lastSyncedRecord.Unix()))
+	var records io.Reader = unzipped
+	if h.config.ExplodeCustomVars || h.config.AddNewExportFields {
+		f, err := h.addNewColumns(ctx, unzipped, fmt.Sprintf("%s%d.export.json", h.config.FilePrefix, lastSyncedRecord.Unix()))
+		if err != nil {
+			return 0, err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		records = f
+	}
+
+	format := h.config.LoadFormat()
+	filename := filepath.Join(h.config.TmpDir, fmt.Sprintf("%s%d.%s", h.config.FilePrefix, lastSyncedRecord.Unix(), format))
 	if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
-		log.Printf("failed to create subdirectories")
+		h.logger.Printf("failed to create subdirectories")
 		return 0, err
 	}
 	outfile, err := os.Create(filename)
 	if err != nil {
-		log.Printf("Failed to create tmp csv file: %s", err)
+		h.logger.Printf("Failed to create tmp %s file: %s", format, err)
 		return 0, err
 	}
 	defer os.Remove(filename)
 	defer outfile.Close()
 
-	csvOut := csv.NewWriter(outfile)
-	_, err = h.WriteBundleToCSV(unzipped, csvOut)
+	switch format {
+	case config.JSONFormat:
+		_, err = h.WriteBundleToJSON(records, outfile)
+	case config.ParquetFormat:
+		_, err = h.WriteBundleToParquet(records, outfile)
+	case config.AvroFormat:
+		_, err = h.WriteBundleToAvro(records, outfile)
+	default:
+		_, err = h.WriteBundleToCSV(records, csv.NewWriter(outfile))
+	}
 	if err != nil {
 		return 0, err
 	}
-	err = h.LoadBundles(ctx, filename, lastSyncedRecord, nextEndTime)
+	if err := ctx.Err(); err != nil {
+		// Shutting down before the load started, the temporary files are removed
+		return 0, err
+	}
+	// Once it has started, the load isn't interrupted by a shutdown, so that the export table and
+	// the sync point are left consistent
+	err = h.LoadBundles(detachedContext{ctx}, filename, lastSyncedRecord, nextEndTime)
 	if err != nil {
 		return 0, err
 	}
+	h.exportDone(lastSyncedRecord, nextEndTime)
 	return 0, nil
 }
 
-func (h *HauserService) Run(ctx context.Context) {
-	if err := h.Init(ctx); err != nil {
-		log.Fatal(err)
+func (h *HauserService) exportDone(start, end time.Time) {
+	h.removeOperation()
+	if h.hooks.ExportLoaded != nil {
+		h.hooks.ExportLoaded(start, end)
 	}
-	for {
-		timeToWait, err := h.ProcessNext(ctx)
-		if h.BackoffOnError(err) {
+}
+
+// Run processes the exports until the context is cancelled. An export that is being loaded when the context
+// is cancelled is finished first, while an export that is still being downloaded is discarded. Run returns
+// nil once it has stopped because of the context, or an error if the exports can't be processed.
+// A failed Init, e.g. because the database isn't reachable yet, is retried like a failed export.
+func (h *HauserService) Run(ctx context.Context) error {
+	for ctx.Err() == nil {
+		var timeToWait time.Duration
+		err := h.Init(ctx)
+		if err == nil {
+			timeToWait, err = h.ProcessNext(ctx)
+		}
+		if ctx.Err() != nil {
+			// Errors caused by the shutdown aren't retried
+			break
+		}
+		if err != nil {
+			if err := h.backoffOnError(ctx, err); err != nil {
+				return err
+			}
 			continue
 		}
+		h.retry.reset()
 
 		if timeToWait == 0 {
 			continue
 		}
-		log.Printf("Waiting until %s to start next export\n", time.Now().Add(timeToWait))
-		time.Sleep(timeToWait)
+		h.logger.Printf("Waiting until %s to start next export\n", h.now().Add(timeToWait))
+		sleep(ctx, timeToWait)
+	}
+	h.logger.Printf("Stopped processing exports: %s", ctx.Err())
+	return nil
+}
+
+// RunOnce processes the exports that are ready and returns once the next export isn't. Unlike Run, it
+// doesn't retry a failed export, but returns its error, so that it can be called by a scheduler.
+func (h *HauserService) RunOnce(ctx context.Context) error {
+	if err := h.Init(ctx); err != nil {
+		return err
+	}
+	for {
+		timeToWait, err := h.ProcessNext(ctx)
+		if err != nil {
+			return err
+		}
+		if timeToWait > 0 {
+			return nil
+		}
 	}
 }
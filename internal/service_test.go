@@ -7,9 +7,11 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"os"
 	"path"
+	"reflect"
 	"testing"
 	"time"
 
@@ -116,6 +118,75 @@ func TestHauser(t *testing.T) {
 				StartTime:      time.Date(2020, 8, 26, 0, 0, 0, 0, time.UTC),
 			},
 		},
+		{
+			name:            "local with database",
+			testdata:        "../testing/testdata/raw.json",
+			outputDir:       "../testing/testdata/localdb",
+			expectedBundles: 5,
+			config: &config.Config{
+				Provider:       config.LocalProvider,
+				ExportDuration: config.Duration{Duration: 24 * time.Hour},
+				StartTime:      time.Date(2020, 8, 26, 0, 0, 0, 0, time.UTC),
+				Local: config.LocalConfig{
+					DatabaseFile: "hauser.db",
+				},
+			},
+		},
+		{
+			name:            "snake case columns",
+			testdata:        "../testing/testdata/raw.json",
+			outputDir:       "../testing/testdata/snakecase",
+			expectedBundles: 5,
+			config: &config.Config{
+				Provider:       config.GCProvider,
+				ColumnNaming:   config.SnakeCaseNaming,
+				ExportDuration: config.Duration{Duration: 24 * time.Hour},
+				StartTime:      time.Date(2020, 8, 26, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:            "json into database",
+			testdata:        "../testing/testdata/raw.json",
+			outputDir:       "../testing/testdata/ndjson",
+			expectedBundles: 5,
+			config: &config.Config{
+				Provider:       config.GCProvider,
+				SaveAsJson:     true,
+				ExportDuration: config.Duration{Duration: 24 * time.Hour},
+				StartTime:      time.Date(2020, 8, 26, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:            "parquet storage only",
+			testdata:        "../testing/testdata/raw.json",
+			outputDir:       "../testing/testdata/parquet",
+			expectedBundles: 5,
+			config: &config.Config{
+				Provider:       config.LocalProvider,
+				FileFormat:     config.ParquetFormat,
+				StorageOnly:    true,
+				ExportDuration: config.Duration{Duration: 24 * time.Hour},
+				StartTime:      time.Date(2020, 8, 26, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:      "explode custom vars",
+			testdata:  "../testing/testdata/raw.json",
+			outputDir: "../testing/testdata/explode",
+			initialColumns: []string{
+				"EventStart",
+				"EventType",
+				"evt_code_str",
+				"CustomVars",
+			},
+			expectedBundles: 5,
+			config: &config.Config{
+				Provider:          config.GCProvider,
+				ExplodeCustomVars: true,
+				ExportDuration:    config.Duration{Duration: 24 * time.Hour},
+				StartTime:         time.Date(2020, 8, 26, 0, 0, 0, 0, time.UTC),
+			},
+		},
 		{
 			name:      "with weird columns",
 			testdata:  "../testing/testdata/raw.json",
@@ -167,7 +238,11 @@ func TestHauser(t *testing.T) {
 			}
 			testutils.Equals(t, tc.expectedBundles, numBundles, "wrong number of bundles processed")
 			testutils.Equals(t, tc.expectedBundles, len(storage.UploadedFiles), "unexpected number of upload files")
-			if db != nil {
+			if db != nil && tc.config.Provider == config.LocalProvider {
+				// The local provider keeps the files that were loaded into the database
+				testutils.Equals(t, 0, len(storage.DeletedFiles), "unexpected number of deleted files")
+				testutils.Equals(t, tc.expectedBundles, len(db.LoadedFiles), "unexpected number of loaded files")
+			} else if db != nil {
 				// Files should only be deleted from storage if they were successfully loaded into the database
 				testutils.Equals(t, tc.expectedBundles, len(storage.DeletedFiles), "unexpected number of deleted files")
 				testutils.Equals(t, tc.expectedBundles, len(db.LoadedFiles), "unexpected number of loaded files")
@@ -193,6 +268,260 @@ func TestHauser(t *testing.T) {
 	}
 }
 
+func TestProcessNextCancelled(t *testing.T) {
+	getNow = func() time.Time {
+		return time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	}
+	progressPollDuration = time.Hour
+	defer func() { progressPollDuration = time.Millisecond }()
+
+	conf := &config.Config{
+		Provider:       config.GCProvider,
+		ExportDuration: config.Duration{Duration: 24 * time.Hour},
+		StartTime:      time.Date(2020, 8, 26, 0, 0, 0, 0, time.UTC),
+		TmpDir:         t.TempDir(),
+	}
+	Ok(t, config.Validate(conf, getNow), "invalid config")
+	storage := hausertest.NewMockStorage()
+	db := hausertest.NewMockDatabase(nil)
+	hauser := NewHauserService(conf, hausertest.NewMockDataExportClient("../testing/testdata/raw.json"), storage, db)
+	Ok(t, hauser.Init(context.Background()), "failed to init")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err := hauser.ProcessNext(ctx)
+	testutils.Equals(t, context.Canceled, err, "expected the progress poll to be interrupted")
+	testutils.Equals(t, 0, len(storage.UploadedFiles), "unexpected upload of a cancelled export")
+	testutils.Equals(t, 0, len(db.LoadedFiles), "unexpected load of a cancelled export")
+	op, err := hauser.loadOperation()
+	Ok(t, err, "failed to load export operation")
+	testutils.Assert(t, op != nil, "expected the export operation to be saved for a restart")
+
+	// Run returns once the context is cancelled
+	hauser.Run(ctx)
+}
+
+func TestRunOnce(t *testing.T) {
+	progressPollDuration = time.Millisecond
+	now := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	conf := &config.Config{
+		Provider:       config.GCProvider,
+		ExportDuration: config.Duration{Duration: 24 * time.Hour},
+		StartTime:      time.Date(2020, 8, 26, 0, 0, 0, 0, time.UTC),
+	}
+	Ok(t, config.Validate(conf, getNow), "invalid config")
+	db := hausertest.NewMockDatabase(nil)
+	hauser := NewHauserService(conf, hausertest.NewMockDataExportClient("../testing/testdata/raw.json"), hausertest.NewMockStorage(), db)
+	hauser.SetClock(func() time.Time { return now })
+	var started, loaded []time.Time
+	hauser.SetHooks(Hooks{
+		ExportStarted: func(start, _ time.Time) { started = append(started, start) },
+		ExportLoaded:  func(_, end time.Time) { loaded = append(loaded, end) },
+	})
+
+	// The exports up to the end of the export delay are loaded
+	Ok(t, hauser.RunOnce(context.Background()), "failed to run once")
+	testutils.Equals(t, 5, len(started), "wrong number of started exports")
+	testutils.Equals(t, 5, len(loaded), "wrong number of loaded exports")
+	testutils.Equals(t, 5, len(db.LoadedFiles), "wrong number of loaded files")
+	testutils.Equals(t, time.Date(2020, 8, 31, 0, 0, 0, 0, time.UTC), loaded[4], "wrong end of the last export")
+
+	// Once the clock has advanced, the next export is ready
+	now = now.Add(24 * time.Hour)
+	Ok(t, hauser.RunOnce(context.Background()), "failed to run again")
+	testutils.Equals(t, 6, len(loaded), "wrong number of loaded exports")
+
+	// A failed export isn't retried, but reported to the hook and returned
+	var failed error
+	hauser.SetHooks(Hooks{ExportFailed: func(err error) { failed = err }})
+	hauser.fsClient = failingClient{hauser.fsClient}
+	now = now.Add(24 * time.Hour)
+	err := hauser.RunOnce(context.Background())
+	testutils.Assert(t, err != nil, "expected an error")
+	testutils.Equals(t, err, failed, "wrong error reported to the hook")
+}
+
+// unreachableDatabase fails to init the export table until it has been tried a number of times.
+type unreachableDatabase struct {
+	*hausertest.MockDatabase
+	failures int
+	attempts int
+}
+
+func (d *unreachableDatabase) InitExportTable(ctx context.Context, s warehouse.Schema) (bool, error) {
+	d.attempts++
+	if d.attempts <= d.failures {
+		return false, errors.New("connection refused")
+	}
+	return d.MockDatabase.InitExportTable(ctx, s)
+}
+
+func TestRunRetriesInit(t *testing.T) {
+	jitter = func(d time.Duration) time.Duration { return d }
+	progressPollDuration = time.Millisecond
+	conf := &config.Config{
+		Provider:        config.GCProvider,
+		ExportDuration:  config.Duration{Duration: 24 * time.Hour},
+		StartTime:       time.Date(2020, 8, 26, 0, 0, 0, 0, time.UTC),
+		Backoff:         config.Duration{Duration: time.Millisecond},
+		BackoffStepsMax: 3,
+		ExtraColumns:    map[string]string{"Source": "hauser"},
+	}
+	Ok(t, config.Validate(conf, getNow), "invalid config")
+
+	db := &unreachableDatabase{MockDatabase: hausertest.NewMockDatabase(nil), failures: 2}
+	hauser := NewHauserService(conf, hausertest.NewMockDataExportClient("../testing/testdata/raw.json"), hausertest.NewMockStorage(), db)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hauser.SetHooks(Hooks{ExportLoaded: func(_, _ time.Time) { cancel() }})
+	Ok(t, hauser.Run(ctx), "failed to run")
+	testutils.Equals(t, 3, db.attempts, "wrong number of init attempts")
+	testutils.Equals(t, 1, len(db.LoadedFiles), "wrong number of loaded files")
+	var extraColumns int
+	for _, f := range hauser.schema {
+		if f.DBName == "Source" {
+			extraColumns++
+		}
+	}
+	testutils.Equals(t, 1, extraColumns, "extra column was added more than once")
+
+	// Run gives up once the database can't be reached after the max retries
+	db = &unreachableDatabase{MockDatabase: hausertest.NewMockDatabase(nil), failures: 10}
+	hauser = NewHauserService(conf, hausertest.NewMockDataExportClient("../testing/testdata/raw.json"), hausertest.NewMockStorage(), db)
+	err := hauser.Run(context.Background())
+	testutils.Assert(t, err != nil, "expected an error after max retries")
+	testutils.Equals(t, 4, db.attempts, "wrong number of init attempts")
+}
+
+func TestResumeExport(t *testing.T) {
+	getNow = func() time.Time {
+		return time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	}
+	progressPollDuration = time.Millisecond
+	ctx := context.Background()
+	conf := &config.Config{
+		Provider:       config.GCProvider,
+		ExportDuration: config.Duration{Duration: 24 * time.Hour},
+		StartTime:      time.Date(2020, 8, 26, 0, 0, 0, 0, time.UTC),
+		TmpDir:         t.TempDir(),
+	}
+	Ok(t, config.Validate(conf, getNow), "invalid config")
+	fsClient := &countingClient{DataExportClient: hausertest.NewMockDataExportClient("../testing/testdata/raw.json")}
+	db := hausertest.NewMockDatabase(nil)
+	hauser := NewHauserService(conf, fsClient, hausertest.NewMockStorage(), db)
+	Ok(t, hauser.Init(ctx), "failed to init")
+
+	// An operation created by a previous run is resumed
+	start := conf.StartTime
+	end := start.Add(24 * time.Hour)
+	fields := hauser.schema.GetFullStoryFields()
+	id, err := fsClient.DataExportClient.CreateExport(ctx, start, end, fields)
+	Ok(t, err, "failed to create export")
+	Ok(t, hauser.saveOperation(&exportOperation{OperationId: id, Start: start, End: end, Fields: fields}), "failed to save operation")
+	_, err = hauser.ProcessNext(ctx)
+	Ok(t, err, "failed to process resumed export")
+	testutils.Equals(t, 0, fsClient.creates, "expected the export to be resumed")
+	testutils.Equals(t, 1, len(db.LoadedFiles), "wrong number of loaded files")
+	op, err := hauser.loadOperation()
+	Ok(t, err, "failed to load export operation")
+	testutils.Assert(t, op == nil, "expected the export operation to be removed once loaded")
+
+	// An expired operation is replaced by a new export
+	start, end = end, end.Add(24*time.Hour)
+	Ok(t, hauser.saveOperation(&exportOperation{
+		OperationId: "expired",
+		Start:       start,
+		End:         end,
+		Fields:      fields,
+		ExportId:    "expired",
+		Expires:     getNow().Add(-time.Hour),
+	}), "failed to save operation")
+	_, err = hauser.ProcessNext(ctx)
+	Ok(t, err, "failed to process export")
+	testutils.Equals(t, 1, fsClient.creates, "expected a new export")
+	testutils.Equals(t, 2, len(db.LoadedFiles), "wrong number of loaded files")
+
+	// An operation that can't be resumed is replaced by a new export
+	start, end = end, end.Add(24*time.Hour)
+	Ok(t, hauser.saveOperation(&exportOperation{OperationId: "unknown", Start: start, End: end, Fields: fields}), "failed to save operation")
+	_, err = hauser.ProcessNext(ctx)
+	Ok(t, err, "failed to process export")
+	testutils.Equals(t, 2, fsClient.creates, "expected a new export")
+	testutils.Equals(t, 3, len(db.LoadedFiles), "wrong number of loaded files")
+}
+
+// statusClient reports the expiry of the exports along with their progress.
+type statusClient struct {
+	client.DataExportClient
+	expires        time.Time
+	progressCalls  int
+	statusRequests int
+}
+
+func (c *statusClient) GetExportProgress(ctx context.Context, operationId string) (int, string, error) {
+	c.progressCalls++
+	return c.DataExportClient.GetExportProgress(ctx, operationId)
+}
+
+func (c *statusClient) GetExportStatus(ctx context.Context, operationId string) (client.ExportStatus, error) {
+	c.statusRequests++
+	prog, exportId, err := c.DataExportClient.GetExportProgress(ctx, operationId)
+	status := client.ExportStatus{Progress: prog, ExportId: exportId}
+	if exportId != "" {
+		status.Expires = c.expires
+	}
+	return status, err
+}
+
+func TestDownloadExportExpiry(t *testing.T) {
+	progressPollDuration = time.Millisecond
+	ctx := context.Background()
+	conf := &config.Config{
+		Provider:       config.GCProvider,
+		ExportDuration: config.Duration{Duration: 24 * time.Hour},
+		StartTime:      time.Date(2020, 8, 26, 0, 0, 0, 0, time.UTC),
+		TmpDir:         t.TempDir(),
+	}
+	Ok(t, config.Validate(conf, getNow), "invalid config")
+	expires := time.Date(2020, 9, 2, 0, 0, 0, 0, time.UTC)
+	fsClient := &statusClient{DataExportClient: hausertest.NewMockDataExportClient("../testing/testdata/raw.json"), expires: expires}
+	hauser := NewHauserService(conf, fsClient, hausertest.NewMockStorage(), hausertest.NewMockDatabase(nil))
+
+	start := conf.StartTime
+	id, err := fsClient.CreateExport(ctx, start, start.Add(24*time.Hour), nil)
+	Ok(t, err, "failed to create export")
+	stream, err := hauser.downloadExport(ctx, &exportOperation{OperationId: id, Start: start, End: start.Add(24 * time.Hour)})
+	Ok(t, err, "failed to download export")
+	stream.Close()
+
+	// The expiry comes with the progress, so the operation is only requested once per poll
+	testutils.Equals(t, 0, fsClient.progressCalls, "unexpected progress requests")
+	testutils.Assert(t, fsClient.statusRequests > 0, "expected status requests")
+	op, err := hauser.loadOperation()
+	Ok(t, err, "failed to load export operation")
+	testutils.Equals(t, expires, op.Expires, "wrong expiry of the saved operation")
+}
+
+// countingClient counts the created exports.
+type countingClient struct {
+	client.DataExportClient
+	creates int
+}
+
+func (c *countingClient) CreateExport(ctx context.Context, start, end time.Time, fields []string) (string, error) {
+	c.creates++
+	return c.DataExportClient.CreateExport(ctx, start, end, fields)
+}
+
+// failingClient fails to create exports.
+type failingClient struct {
+	client.DataExportClient
+}
+
+func (failingClient) CreateExport(_ context.Context, _, _ time.Time, _ []string) (string, error) {
+	return "", errors.New("failed to create export")
+}
+
 func TestGetRetryInfo(t *testing.T) {
 	testCases := []struct {
 		err           error
@@ -293,7 +622,7 @@ func TestTransformExportJSONRecord(t *testing.T) {
 	for i, tc := range testCases {
 		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
 
-			h := &HauserService{schema: tc.schema}
+			h := &HauserService{schema: tc.schema, logger: log.Default()}
 			result, err := h.transformExportJSONRecord(warehouse.ValueToString, tc.rec)
 			if err != nil {
 				t.Errorf("Unexpected err %s on test case %d", err, i)
@@ -310,6 +639,52 @@ func TestTransformExportJSONRecord(t *testing.T) {
 	}
 }
 
+func TestExtraColumns(t *testing.T) {
+	os.Setenv("HAUSER_TEST_ENVIRONMENT", "staging")
+	defer os.Unsetenv("HAUSER_TEST_ENVIRONMENT")
+
+	loadTime := time.Date(2020, 9, 1, 12, 30, 0, 0, time.UTC)
+	h := &HauserService{
+		now: func() time.Time { return loadTime },
+		config: &config.Config{
+			ExtraColumns: map[string]string{
+				"OrgId":       "abc",
+				"LoadedAt":    "{{now}}",
+				"Environment": `{{env "HAUSER_TEST_ENVIRONMENT"}}-1`,
+			},
+		},
+		schema: warehouse.MakeSchema(struct {
+			EventTargetText string
+		}{}),
+	}
+	Ok(t, h.loadExtraColumns(), "failed to load extra columns")
+
+	testutils.Equals(t, 4, len(h.schema), "wrong number of fields")
+	for i, name := range []string{"EventTargetText", "Environment", "LoadedAt", "OrgId"} {
+		testutils.Equals(t, name, h.schema[i].DBName, "wrong column at index %d", i)
+	}
+	testutils.Equals(t, "", h.schema[1].FullStoryFieldName, "extra column shouldn't be part of the export")
+	testutils.Assert(t, h.schema[2].IsTime(), "expected LoadedAt to be a time")
+	testutils.Assert(t, !h.schema[3].IsTime(), "expected OrgId to be a string")
+
+	var err error
+	h.extraValues, err = h.extraColumnValues()
+	Ok(t, err, "failed to execute extra columns")
+	result, err := h.transformExportJSONRecord(warehouse.ValueToString, map[string]interface{}{
+		"EventTargetText": "Heyo!",
+	})
+	Ok(t, err, "failed to transform record")
+	testutils.Equals(t, "Heyo!", result[0], "wrong export value")
+	testutils.Equals(t, "staging-1", result[1], "wrong env value")
+	loadedAt, err := time.Parse(warehouse.RFC3339Micro, result[2])
+	Ok(t, err, "failed to parse LoadedAt")
+	testutils.Assert(t, loadedAt.Equal(loadTime), "LoadedAt should use the clock of the service: %s", result[2])
+	testutils.Equals(t, "abc", result[3], "wrong constant value")
+
+	h.config.ExtraColumns = map[string]string{"EventTargetText": "abc"}
+	testutils.Assert(t, h.loadExtraColumns() != nil, "expected an error for a column of an export field")
+}
+
 func compareTransformedStrings(t *testing.T, str1, str2 string) bool {
 	if str1 == str2 {
 		return true
@@ -341,3 +716,60 @@ func compareJSONStrings(t *testing.T, str1, str2 string) bool {
 	}
 	return true
 }
+
+func TestFindNewFields(t *testing.T) {
+	h := &HauserService{
+		logger: log.Default(),
+		config: &config.Config{AddNewExportFields: true},
+		schema: warehouse.MakeSchema(struct {
+			EventStart time.Time
+			CustomVars string
+		}{}),
+	}
+	export := `[
+		{"EventStart": "2020-08-26T12:30:00Z", "NewScore": 1, "NewLabel": null, "NewDue": "2020-08-27T00:00:00Z", "NewFlag": true, "user_plan_str": "pro"},
+		{"EventStart": "2020-08-26T12:31:00Z", "NewScore": 1.5, "NewLabel": "a", "NewDue": "tomorrow", "NewFlag": 1}
+	]`
+	customVars, exportFields, err := h.findNewFields(bytes.NewBufferString(export))
+	Ok(t, err, "findNewFields")
+	testutils.Equals(t, 0, len(customVars), "custom vars should not be exploded")
+	testutils.Equals(t, 4, len(exportFields), "wrong number of new fields")
+	testutils.Equals(t, "NewDue", exportFields[0].DBName, "wrong field")
+	testutils.Equals(t, reflect.TypeOf(""), exportFields[0].FieldType, "timestamp field with text was not changed to a string")
+	testutils.Equals(t, "NewFlag", exportFields[1].DBName, "wrong field")
+	testutils.Equals(t, reflect.TypeOf(""), exportFields[1].FieldType, "boolean field with a number was not changed to a string")
+	testutils.Equals(t, "NewLabel", exportFields[2].DBName, "wrong field")
+	testutils.Equals(t, reflect.TypeOf(""), exportFields[2].FieldType, "wrong type for NewLabel")
+	testutils.Equals(t, "NewScore", exportFields[3].DBName, "wrong field")
+	testutils.Equals(t, reflect.TypeOf(float64(0)), exportFields[3].FieldType, "integer field was not widened")
+}
+
+func TestAddedFieldsWithLocalDisk(t *testing.T) {
+	conf := &config.Config{
+		Provider:           config.LocalProvider,
+		StorageOnly:        true,
+		AddNewExportFields: true,
+		FilePrefix:         "hauser/",
+		StartTime:          time.Date(2020, 8, 26, 0, 0, 0, 0, time.UTC),
+		Local:              config.LocalConfig{SaveDir: t.TempDir()},
+	}
+	Ok(t, config.Validate(conf, getNow), "invalid config")
+	storage, err := warehouse.NewLocalDisk(&conf.Local)
+	Ok(t, err, "failed to create storage")
+	ctx := context.Background()
+
+	h := NewHauserService(conf, nil, storage, nil)
+	Ok(t, h.Init(ctx), "failed to init")
+	h.addedFields = []warehouse.WarehouseField{{DBName: "NewScore", FullStoryFieldName: "NewScore", FieldType: reflect.TypeOf(float64(0))}}
+	Ok(t, h.saveAddedFields(ctx), "failed to save added fields")
+	Ok(t, storage.SaveSyncPoint(ctx, time.Date(2020, 8, 27, 0, 0, 0, 0, time.UTC)), "failed to save sync point")
+
+	// The added fields are loaded back after a restart, and don't get mixed up with the sync point
+	h = NewHauserService(conf, nil, storage, nil)
+	Ok(t, h.Init(ctx), "failed to init after restart")
+	field := h.schema.GetFieldForName("NewScore")
+	testutils.Equals(t, reflect.TypeOf(float64(0)), field.FieldType, "added field wasn't loaded")
+	last, err := storage.LastSyncPoint(ctx)
+	Ok(t, err, "failed to read sync point")
+	testutils.Equals(t, time.Date(2020, 8, 27, 0, 0, 0, 0, time.UTC), last, "wrong sync point")
+}
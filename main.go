@@ -6,9 +6,10 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 
-	"github.com/fullstorydev/hauser/client"
 	"github.com/fullstorydev/hauser/config"
 	"github.com/fullstorydev/hauser/core"
 )
@@ -30,10 +31,19 @@ func main() {
 		log.Fatal(err)
 	}
 
-	ctx := context.Background()
-	store := core.MakeStorage(ctx, conf)
-	database := core.MakeDatabase(ctx, conf)
-	cl := client.NewClient(conf)
-	h := core.NewHauser(conf, cl, store, database)
-	h.Run(ctx)
+	// The first SIGINT or SIGTERM stops hauser once the current export is done, a second one exits immediately
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		log.Printf("Shutting down after the current export")
+		stop()
+	}()
+
+	pipeline, err := core.NewPipeline(ctx, conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := pipeline.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -3,6 +3,7 @@ package testing
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -100,14 +101,14 @@ func (m *MockDataExportClient) collectJsonData(start, end time.Time, fields []st
 	return raw
 }
 
-func (m *MockDataExportClient) CreateExport(start, end time.Time, fields []string) (string, error) {
+func (m *MockDataExportClient) CreateExport(_ context.Context, start, end time.Time, fields []string) (string, error) {
 	operationId := fmt.Sprintf("%d", rand.Int())
 	exportId := fmt.Sprintf("%d", rand.Int())
 	m.creates[operationId] = createdExport{start, end, fields, 0, exportId}
 	return operationId, nil
 }
 
-func (m *MockDataExportClient) GetExportProgress(operationId string) (int, string, error) {
+func (m *MockDataExportClient) GetExportProgress(_ context.Context, operationId string) (int, string, error) {
 	if created, ok := m.creates[operationId]; !ok {
 		return 0, "", client.StatusError{
 			Status:     "Not Found",
@@ -135,7 +136,7 @@ func (m *MockDataExportClient) GetExportProgress(operationId string) (int, strin
 	}
 }
 
-func (m *MockDataExportClient) GetExport(exportId string) (io.ReadCloser, error) {
+func (m *MockDataExportClient) GetExport(_ context.Context, exportId string) (io.ReadCloser, error) {
 	for _, created := range m.creates {
 		if created.exportId == exportId {
 			raw := m.collectJsonData(created.start, created.end, created.fields)
@@ -16,7 +16,7 @@ type MockDatabase struct {
 	LoadedFiles    []string
 }
 
-func (m *MockDatabase) InitExportTable(schema warehouse.Schema) (bool, error) {
+func (m *MockDatabase) InitExportTable(_ context.Context, schema warehouse.Schema) (bool, error) {
 	if len(m.schema) == 0 {
 		// We're creating the table
 		m.schema = schema
@@ -26,7 +26,7 @@ func (m *MockDatabase) InitExportTable(schema warehouse.Schema) (bool, error) {
 	return false, nil
 }
 
-func (m *MockDatabase) ApplyExportSchema(newSchema warehouse.Schema) error {
+func (m *MockDatabase) ApplyExportSchema(_ context.Context, newSchema warehouse.Schema) error {
 	if m.schema.IsCompatibleWith(newSchema) {
 		m.schema = newSchema
 		m.Initialized = true
@@ -35,7 +35,7 @@ func (m *MockDatabase) ApplyExportSchema(newSchema warehouse.Schema) error {
 	return fmt.Errorf("incompatible schema: have %v, got %v", m.schema, newSchema)
 }
 
-var _ warehouse.Database = (*MockDatabase)(nil)
+var _ warehouse.DatabaseV2 = (*MockDatabase)(nil)
 
 func NewMockDatabase(existingColumns []string) *MockDatabase {
 	defaultSchema := warehouse.MakeSchema(warehouse.BaseExportFields{})
@@ -67,7 +67,7 @@ func (m *MockDatabase) SaveSyncPoint(_ context.Context, endTime time.Time) error
 	return nil
 }
 
-func (m *MockDatabase) LoadToWarehouse(filename string, _ time.Time) error {
+func (m *MockDatabase) LoadToWarehouse(_ context.Context, filename string, _ time.Time) error {
 	m.LoadedFiles = append(m.LoadedFiles, filename)
 	return nil
 }
@@ -81,10 +81,10 @@ func (m *MockDatabase) ValueToString(val interface{}, isTime bool) string {
 	return s
 }
 
-func (m *MockDatabase) GetExportTableColumns() []string {
+func (m *MockDatabase) GetExportTableColumns(_ context.Context) ([]string, error) {
 	cols := make([]string, 0, len(m.schema))
 	for _, f := range m.schema {
 		cols = append(cols, f.DBName)
 	}
-	return cols
+	return cols, nil
 }
@@ -0,0 +1,81 @@
+package warehouse
+
+import (
+	"context"
+	"time"
+)
+
+// databaseAdapter implements DatabaseV2 for a Database. The methods of a Database don't take a context, so
+// they are only started if the context isn't cancelled yet.
+type databaseAdapter struct {
+	db Database
+}
+
+var _ DatabaseV2 = databaseAdapter{}
+var _ JSONCustomVarsDatabase = databaseAdapter{}
+
+// migratingDatabaseAdapter also forwards the MigratingDatabase methods. It is a separate type so
+// that the adapter of a Database that can't migrate its columns doesn't claim that it can.
+type migratingDatabaseAdapter struct {
+	databaseAdapter
+	MigratingDatabase
+}
+
+var _ MigratingDatabase = migratingDatabaseAdapter{}
+
+// NewDatabaseAdapter returns a DatabaseV2 that calls the methods of a Database. The optional
+// JSONCustomVarsDatabase and MigratingDatabase interfaces are forwarded too.
+func NewDatabaseAdapter(db Database) DatabaseV2 {
+	if m, ok := db.(MigratingDatabase); ok {
+		return migratingDatabaseAdapter{databaseAdapter: databaseAdapter{db: db}, MigratingDatabase: m}
+	}
+	return databaseAdapter{db: db}
+}
+
+func (a databaseAdapter) LastSyncPoint(ctx context.Context) (time.Time, error) {
+	return a.db.LastSyncPoint(ctx)
+}
+
+func (a databaseAdapter) SaveSyncPoint(ctx context.Context, endTime time.Time) error {
+	return a.db.SaveSyncPoint(ctx, endTime)
+}
+
+func (a databaseAdapter) LoadToWarehouse(ctx context.Context, storageRef string, start time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.db.LoadToWarehouse(storageRef, start)
+}
+
+func (a databaseAdapter) ValueToString(val interface{}, isTime bool) string {
+	return a.db.ValueToString(val, isTime)
+}
+
+func (a databaseAdapter) GetExportTableColumns(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.db.GetExportTableColumns(), nil
+}
+
+func (a databaseAdapter) InitExportTable(ctx context.Context, s Schema) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return a.db.InitExportTable(s)
+}
+
+func (a databaseAdapter) ApplyExportSchema(ctx context.Context, s Schema) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.db.ApplyExportSchema(s)
+}
+
+// CustomVarsAsJSON returns the setting of the Database if it stores the custom vars as JSON.
+func (a databaseAdapter) CustomVarsAsJSON() bool {
+	if db, ok := a.db.(JSONCustomVarsDatabase); ok {
+		return db.CustomVarsAsJSON()
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package warehouse
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/hauser/testing/testutils"
+)
+
+// v1Database is a Database that records the calls made to it.
+type v1Database struct {
+	calls []string
+}
+
+func (d *v1Database) LastSyncPoint(_ context.Context) (time.Time, error) {
+	d.calls = append(d.calls, "LastSyncPoint")
+	return time.Time{}, nil
+}
+
+func (d *v1Database) SaveSyncPoint(_ context.Context, _ time.Time) error {
+	d.calls = append(d.calls, "SaveSyncPoint")
+	return nil
+}
+
+func (d *v1Database) LoadToWarehouse(storageRef string, _ time.Time) error {
+	d.calls = append(d.calls, "LoadToWarehouse "+storageRef)
+	return nil
+}
+
+func (d *v1Database) ValueToString(val interface{}, _ bool) string {
+	return val.(string)
+}
+
+func (d *v1Database) GetExportTableColumns() []string {
+	d.calls = append(d.calls, "GetExportTableColumns")
+	return []string{"EventStart"}
+}
+
+func (d *v1Database) InitExportTable(_ Schema) (bool, error) {
+	d.calls = append(d.calls, "InitExportTable")
+	return true, nil
+}
+
+func (d *v1Database) ApplyExportSchema(_ Schema) error {
+	d.calls = append(d.calls, "ApplyExportSchema")
+	return nil
+}
+
+type v1JSONDatabase struct {
+	v1Database
+}
+
+func (d *v1JSONDatabase) CustomVarsAsJSON() bool {
+	return true
+}
+
+type v1MigratingDatabase struct {
+	v1Database
+}
+
+func (d *v1MigratingDatabase) PlanMigrations(_ context.Context, _ Schema) ([]Migration, error) {
+	d.calls = append(d.calls, "PlanMigrations")
+	return nil, nil
+}
+
+func (d *v1MigratingDatabase) ApplyMigrations(_ context.Context, _ []Migration) error {
+	d.calls = append(d.calls, "ApplyMigrations")
+	return nil
+}
+
+func TestDatabaseAdapter(t *testing.T) {
+	ctx := context.Background()
+	db := &v1Database{}
+	adapter := NewDatabaseAdapter(db)
+
+	created, err := adapter.InitExportTable(ctx, nil)
+	testutils.Assert(t, created && err == nil, "failed to init export table: %v", err)
+	testutils.Assert(t, adapter.ApplyExportSchema(ctx, nil) == nil, "failed to apply schema")
+	columns, err := adapter.GetExportTableColumns(ctx)
+	testutils.Assert(t, err == nil, "failed to get columns: %s", err)
+	testutils.StrSliceEquals(t, []string{"EventStart"}, columns, "wrong columns")
+	testutils.Assert(t, adapter.LoadToWarehouse(ctx, "bundle.csv", time.Time{}) == nil, "failed to load")
+	testutils.Assert(t, adapter.SaveSyncPoint(ctx, time.Time{}) == nil, "failed to save sync point")
+	testutils.Equals(t, "value", adapter.ValueToString("value", false), "wrong value")
+	testutils.StrSliceEquals(t, []string{
+		"InitExportTable",
+		"ApplyExportSchema",
+		"GetExportTableColumns",
+		"LoadToWarehouse bundle.csv",
+		"SaveSyncPoint",
+	}, db.calls, "wrong calls")
+
+	// Calls aren't started once the context is cancelled
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	db.calls = nil
+	_, err = adapter.InitExportTable(cancelled, nil)
+	testutils.Equals(t, context.Canceled, err, "wrong init error")
+	_, err = adapter.GetExportTableColumns(cancelled)
+	testutils.Equals(t, context.Canceled, err, "wrong columns error")
+	testutils.Equals(t, context.Canceled, adapter.LoadToWarehouse(cancelled, "bundle.csv", time.Time{}), "wrong load error")
+	testutils.Equals(t, 0, len(db.calls), "unexpected calls: %v", db.calls)
+}
+
+func TestDatabaseAdapterCustomVarsAsJSON(t *testing.T) {
+	adapter := NewDatabaseAdapter(&v1Database{})
+	testutils.Assert(t, !adapter.(JSONCustomVarsDatabase).CustomVarsAsJSON(), "expected custom vars as columns")
+
+	adapter = NewDatabaseAdapter(&v1JSONDatabase{})
+	testutils.Assert(t, adapter.(JSONCustomVarsDatabase).CustomVarsAsJSON(), "expected custom vars as JSON")
+}
+
+func TestDatabaseAdapterMigrations(t *testing.T) {
+	_, ok := NewDatabaseAdapter(&v1Database{}).(MigratingDatabase)
+	testutils.Assert(t, !ok, "expected no migrations for a database without them")
+
+	db := &v1MigratingDatabase{}
+	adapter, ok := NewDatabaseAdapter(db).(MigratingDatabase)
+	testutils.Assert(t, ok, "expected migrations to be forwarded")
+	_, err := adapter.PlanMigrations(context.Background(), nil)
+	testutils.Assert(t, err == nil, "failed to plan migrations: %s", err)
+	testutils.Assert(t, adapter.ApplyMigrations(context.Background(), nil) == nil, "failed to apply migrations")
+	testutils.StrSliceEquals(t, []string{"PlanMigrations", "ApplyMigrations"}, db.calls, "wrong calls")
+
+	_, ok = adapter.(DatabaseV2)
+	testutils.Assert(t, ok, "expected a DatabaseV2")
+}
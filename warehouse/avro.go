@@ -0,0 +1,122 @@
+package warehouse
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+const (
+	avroRecordName = "fullstory_export"
+	avroBlockSize  = 1000
+)
+
+// avroTypeMap maps the schema types to Avro types. Every field is written as a union with null,
+// so the map also holds the name that goavro uses for the non-null branch of the union.
+var avroTypeMap = map[reflect.Type]struct {
+	schema    interface{}
+	unionName string
+}{
+	reflect.TypeOf(int64(0)):    {"long", "long"},
+	reflect.TypeOf(""):          {"string", "string"},
+	reflect.TypeOf(time.Time{}): {map[string]string{"type": "long", "logicalType": "timestamp-micros"}, "long.timestamp-micros"},
+	reflect.TypeOf(float64(0)):  {"double", "double"},
+	reflect.TypeOf(int32(0)):    {"int", "int"},
+	reflect.TypeOf(false):       {"boolean", "boolean"},
+}
+
+// AvroWriter writes export records to an Avro object container file. The record schema is generated
+// from the export schema and is embedded in the file, so that the files are self-describing.
+type AvroWriter struct {
+	fields     []WarehouseField
+	unionNames []string
+	ocf        *goavro.OCFWriter
+	pending    []interface{}
+}
+
+func NewAvroWriter(w io.Writer, s Schema) (*AvroWriter, error) {
+	schema, unionNames, err := avroSchema(s)
+	if err != nil {
+		return nil, err
+	}
+	ocf, err := goavro.NewOCFWriter(goavro.OCFConfig{
+		W:               w,
+		Schema:          schema,
+		CompressionName: goavro.CompressionDeflateLabel,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &AvroWriter{
+		fields:     s,
+		unionNames: unionNames,
+		ocf:        ocf,
+	}, nil
+}
+
+// avroSchema returns the JSON record schema for the export schema, along with the union branch
+// name of each field.
+func avroSchema(s Schema) (string, []string, error) {
+	fields := make([]map[string]interface{}, len(s))
+	unionNames := make([]string, len(s))
+	for i, field := range s {
+		// Columns that aren't part of the export have no type, but they are always empty
+		avroType, ok := avroTypeMap[reflect.TypeOf("")]
+		if field.FieldType != nil {
+			if avroType, ok = avroTypeMap[field.FieldType]; !ok {
+				return "", nil, fmt.Errorf("avro type not found for schema type %s", field.FieldType)
+			}
+		}
+		fields[i] = map[string]interface{}{
+			"name":    field.DBName,
+			"type":    []interface{}{"null", avroType.schema},
+			"default": nil,
+		}
+		unionNames[i] = avroType.unionName
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"type":   "record",
+		"name":   avroRecordName,
+		"fields": fields,
+	})
+	return string(b), unionNames, err
+}
+
+// Write adds a single record. Empty values, and values that don't match the type of their field,
+// are written as nulls.
+func (a *AvroWriter) Write(record []string) error {
+	if len(record) != len(a.fields) {
+		return fmt.Errorf("record has %d values, expected %d", len(record), len(a.fields))
+	}
+	datum := make(map[string]interface{}, len(record))
+	for i, val := range record {
+		if v := parseValueOrNull(a.fields[i], val); v != nil {
+			datum[a.fields[i].DBName] = goavro.Union(a.unionNames[i], v)
+		} else {
+			datum[a.fields[i].DBName] = nil
+		}
+	}
+	a.pending = append(a.pending, datum)
+	if len(a.pending) >= avroBlockSize {
+		return a.flush()
+	}
+	return nil
+}
+
+func (a *AvroWriter) flush() error {
+	if len(a.pending) == 0 {
+		return nil
+	}
+	err := a.ocf.Append(a.pending)
+	a.pending = a.pending[:0]
+	return err
+}
+
+// Close writes the remaining records. It doesn't close the underlying writer.
+func (a *AvroWriter) Close() error {
+	return a.flush()
+}
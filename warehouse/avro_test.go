@@ -0,0 +1,70 @@
+package warehouse
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/hauser/testing/testutils"
+	"github.com/linkedin/goavro/v2"
+)
+
+func TestAvroWriter(t *testing.T) {
+	schema := Schema{
+		{DBName: "UserId", FullStoryFieldName: "UserId", FieldType: reflect.TypeOf(int64(0))},
+		{DBName: "EventStart", FullStoryFieldName: "EventStart", FieldType: reflect.TypeOf(time.Time{})},
+		{DBName: "PageNumEvents", FullStoryFieldName: "PageNumEvents", FieldType: reflect.TypeOf(int32(0))},
+		{DBName: "EventCumulativeLayoutShift", FullStoryFieldName: "EventCumulativeLayoutShift", FieldType: reflect.TypeOf(float64(0))},
+		{DBName: "CustomVars", FullStoryFieldName: "CustomVars", FieldType: reflect.TypeOf("")},
+		{DBName: "CustomColumn"},
+	}
+
+	var buf bytes.Buffer
+	aw, err := NewAvroWriter(&buf, schema)
+	testutils.Assert(t, err == nil, "failed to create writer: %s", err)
+	testutils.Assert(t, aw.Write([]string{"123", "2020-08-26T01:00:00.123456Z", "7", "0.5", `{"a":"b"}`, ""}) == nil, "failed to write")
+	testutils.Assert(t, aw.Write([]string{"456", "2020-08-26T02:00:00Z", "", "", "{}", ""}) == nil, "failed to write")
+	testutils.Assert(t, aw.Write([]string{"abc", "yesterday", "", "", "{}", ""}) == nil, "failed to write invalid values")
+	testutils.Assert(t, aw.Write([]string{"1"}) != nil, "expected error for short record")
+	testutils.Assert(t, aw.Close() == nil, "failed to close writer")
+
+	ocf, err := goavro.NewOCFReader(&buf)
+	testutils.Assert(t, err == nil, "failed to create reader: %s", err)
+	var records []interface{}
+	for ocf.Scan() {
+		record, err := ocf.Read()
+		testutils.Assert(t, err == nil, "failed to read record: %s", err)
+		records = append(records, record)
+	}
+	testutils.Assert(t, ocf.Err() == nil, "failed to read file: %s", ocf.Err())
+
+	expected := []interface{}{
+		map[string]interface{}{
+			"UserId":                     map[string]interface{}{"long": int64(123)},
+			"EventStart":                 map[string]interface{}{"long.timestamp-micros": time.Date(2020, 8, 26, 1, 0, 0, 123456000, time.UTC)},
+			"PageNumEvents":              map[string]interface{}{"int": int32(7)},
+			"EventCumulativeLayoutShift": map[string]interface{}{"double": 0.5},
+			"CustomVars":                 map[string]interface{}{"string": `{"a":"b"}`},
+			"CustomColumn":               nil,
+		},
+		map[string]interface{}{
+			"UserId":                     map[string]interface{}{"long": int64(456)},
+			"EventStart":                 map[string]interface{}{"long.timestamp-micros": time.Date(2020, 8, 26, 2, 0, 0, 0, time.UTC)},
+			"PageNumEvents":              nil,
+			"EventCumulativeLayoutShift": nil,
+			"CustomVars":                 map[string]interface{}{"string": "{}"},
+			"CustomColumn":               nil,
+		},
+		// The invalid values are written as nulls
+		map[string]interface{}{
+			"UserId":                     nil,
+			"EventStart":                 nil,
+			"PageNumEvents":              nil,
+			"EventCumulativeLayoutShift": nil,
+			"CustomVars":                 map[string]interface{}{"string": "{}"},
+			"CustomColumn":               nil,
+		},
+	}
+	testutils.Assert(t, reflect.DeepEqual(expected, records), "want %v, got %v", expected, records)
+}
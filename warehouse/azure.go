@@ -0,0 +1,177 @@
+package warehouse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/fullstorydev/hauser/config"
+)
+
+type AzureBlobStorage struct {
+	conf         *config.AzureConfig
+	containerURL azblob.ContainerURL
+}
+
+var _ Storage = (*AzureBlobStorage)(nil)
+
+// NewAzureBlobStorage creates the storage for the configured container. Credentials are taken from
+// the connection string if there is one, otherwise the SAS token is appended to the service URL.
+func NewAzureBlobStorage(conf *config.AzureConfig) (*AzureBlobStorage, error) {
+	serviceURL, credential, err := azureServiceURL(conf)
+	if err != nil {
+		return nil, err
+	}
+	p := azblob.NewPipeline(credential, azblob.PipelineOptions{})
+	return &AzureBlobStorage{
+		conf:         conf,
+		containerURL: azblob.NewServiceURL(*serviceURL, p).NewContainerURL(conf.Container),
+	}, nil
+}
+
+// azureServiceURL resolves the blob service endpoint and the credential to use with it.
+func azureServiceURL(conf *config.AzureConfig) (*url.URL, azblob.Credential, error) {
+	accountName, accountKey, endpoint, sas := conf.AccountName, "", conf.ServiceURL, conf.SASToken
+	if conf.ConnectionString != "" {
+		settings, err := parseAzureConnectionString(conf.ConnectionString)
+		if err != nil {
+			return nil, nil, err
+		}
+		accountName = settings["AccountName"]
+		accountKey = settings["AccountKey"]
+		if settings["SharedAccessSignature"] != "" {
+			sas = settings["SharedAccessSignature"]
+		}
+		if settings["BlobEndpoint"] != "" {
+			endpoint = settings["BlobEndpoint"]
+		} else if accountName != "" {
+			protocol, suffix := settings["DefaultEndpointsProtocol"], settings["EndpointSuffix"]
+			if protocol == "" {
+				protocol = "https"
+			}
+			if suffix == "" {
+				suffix = "core.windows.net"
+			}
+			endpoint = fmt.Sprintf("%s://%s.blob.%s", protocol, accountName, suffix)
+		}
+	}
+	if endpoint == "" {
+		if accountName == "" {
+			return nil, nil, errors.New("azure storage requires a connection string, an account name or a service URL")
+		}
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", accountName)
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid azure blob endpoint %q: %s", endpoint, err)
+	}
+	if accountKey != "" {
+		credential, err := azblob.NewSharedKeyCredential(accountName, accountKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid azure account key: %s", err)
+		}
+		return u, credential, nil
+	}
+	if sas == "" {
+		return nil, nil, errors.New("azure storage requires either an account key or a SAS token")
+	}
+	u.RawQuery = strings.TrimPrefix(sas, "?")
+	return u, azblob.NewAnonymousCredential(), nil
+}
+
+// parseAzureConnectionString splits a connection string of the form "Key1=Value1;Key2=Value2" into its settings.
+func parseAzureConnectionString(connStr string) (map[string]string, error) {
+	settings := make(map[string]string)
+	for _, part := range strings.Split(connStr, ";") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		// Values such as the account key and SAS token can contain '=', so only split on the first one
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid azure connection string setting %q", kv[0])
+		}
+		settings[kv[0]] = kv[1]
+	}
+	return settings, nil
+}
+
+func (a *AzureBlobStorage) LastSyncPoint(ctx context.Context) (time.Time, error) {
+	return SyncViaStorageMixin{a}.LastSyncPoint(ctx)
+}
+
+func (a *AzureBlobStorage) SaveSyncPoint(ctx context.Context, endTime time.Time) error {
+	return SyncViaStorageMixin{a}.SaveSyncPoint(ctx, endTime)
+}
+
+func (a *AzureBlobStorage) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if a.conf.Timeout.Duration > 0 {
+		return context.WithTimeout(ctx, a.conf.Timeout.Duration)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (a *AzureBlobStorage) SaveFile(ctx context.Context, name string, reader io.Reader) (string, error) {
+	ctx, cancelFn := a.withTimeout(ctx)
+	defer cancelFn()
+
+	blobURL := a.containerURL.NewBlockBlobURL(name)
+	if _, err := azblob.UploadStreamToBlockBlob(ctx, reader, blobURL, azblob.UploadStreamToBlockBlobOptions{}); err != nil {
+		return "", fmt.Errorf("failed to save file to Azure: %s", err)
+	}
+	return a.GetFileReference(name), nil
+}
+
+// ReadFile downloads the whole blob before returning, so that the timeout applies to the download.
+func (a *AzureBlobStorage) ReadFile(ctx context.Context, name string) (io.Reader, error) {
+	ctx, cancelFn := a.withTimeout(ctx)
+	defer cancelFn()
+
+	blobURL := a.containerURL.NewBlockBlobURL(name)
+	resp, err := blobURL.Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		if stgErr, ok := err.(azblob.StorageError); ok && stgErr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+			return nil, ErrFileNotFound
+		}
+		return nil, fmt.Errorf("failed to read azure blob %s: %s", name, err)
+	}
+	body := resp.Body(azblob.RetryReaderOptions{})
+	defer body.Close()
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, body); err != nil {
+		return nil, fmt.Errorf("failed to read azure blob %s: %s", name, err)
+	}
+	return &buf, nil
+}
+
+func (a *AzureBlobStorage) DeleteFile(ctx context.Context, name string) error {
+	ctx, cancelFn := a.withTimeout(ctx)
+	defer cancelFn()
+
+	blobURL := a.containerURL.NewBlockBlobURL(name)
+	if _, err := blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{}); err != nil {
+		// Match the S3 storage, which doesn't fail the export when cleanup fails
+		log.Printf("failed to delete azure blob %s: %s", name, err)
+	}
+	return nil
+}
+
+func (a *AzureBlobStorage) GetFileReference(name string) string {
+	u := a.containerURL.NewBlobURL(name).URL()
+	// Don't leak the SAS token into logs
+	u.RawQuery = ""
+	return u.String()
+}
+
+func (a *AzureBlobStorage) GetFilePrefix() string {
+	return a.conf.FilePrefix
+}
@@ -0,0 +1,172 @@
+package warehouse
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/hauser/config"
+	"github.com/fullstorydev/hauser/testing/testutils"
+)
+
+// fakeBlobService implements the small part of the blob service REST API used by block blob uploads,
+// downloads and deletes. Blobs are keyed by their path, which includes the account and container.
+type fakeBlobService struct {
+	mu     sync.Mutex
+	blocks map[string][]byte
+	blobs  map[string][]byte
+	sas    string
+}
+
+func (f *fakeBlobService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Query().Get("sig") != f.sas {
+		f.writeError(w, http.StatusForbidden, "AuthenticationFailed")
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	switch {
+	case r.Method == http.MethodPut && r.URL.Query().Get("comp") == "block":
+		f.blocks[r.URL.Path+"/"+r.URL.Query().Get("blockid")] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && r.URL.Query().Get("comp") == "blocklist":
+		var list struct {
+			Latest []string `xml:"Latest"`
+		}
+		if err := xml.Unmarshal(body, &list); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var blob []byte
+		for _, id := range list.Latest {
+			blob = append(blob, f.blocks[r.URL.Path+"/"+id]...)
+		}
+		f.blobs[r.URL.Path] = blob
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut:
+		f.blobs[r.URL.Path] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet:
+		blob, ok := f.blobs[r.URL.Path]
+		if !ok {
+			f.writeError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		w.Write(blob)
+	case r.Method == http.MethodDelete:
+		if _, ok := f.blobs[r.URL.Path]; !ok {
+			f.writeError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		delete(f.blobs, r.URL.Path)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func (f *fakeBlobService) writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("x-ms-error-code", code)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><Error><Code>` + code + `</Code><Message>fake</Message></Error>`))
+}
+
+func TestAzureBlobStorage(t *testing.T) {
+	fake := &fakeBlobService{blocks: map[string][]byte{}, blobs: map[string][]byte{}, sas: "secret"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	storage, err := NewAzureBlobStorage(&config.AzureConfig{
+		ServiceURL: server.URL + "/devstoreaccount1",
+		SASToken:   "?sv=2019-12-12&sig=secret",
+		Container:  "hauser",
+		Timeout:    config.Duration{Duration: time.Minute},
+	})
+	testutils.Assert(t, err == nil, "failed to create storage: %s", err)
+	ctx := context.Background()
+
+	ref, err := storage.SaveFile(ctx, "exports/bundle.csv", strings.NewReader("a,b\n1,2\n"))
+	testutils.Assert(t, err == nil, "failed to save file: %s", err)
+	testutils.Equals(t, server.URL+"/devstoreaccount1/hauser/exports/bundle.csv", ref, "wrong file reference")
+	testutils.Equals(t, "a,b\n1,2\n", string(fake.blobs["/devstoreaccount1/hauser/exports/bundle.csv"]), "wrong blob contents")
+
+	r, err := storage.ReadFile(ctx, "exports/bundle.csv")
+	testutils.Assert(t, err == nil, "failed to read file: %s", err)
+	data, _ := ioutil.ReadAll(r)
+	testutils.Equals(t, "a,b\n1,2\n", string(data), "wrong file contents")
+
+	_, err = storage.ReadFile(ctx, "missing.csv")
+	testutils.Equals(t, ErrFileNotFound, err, "expected file not found")
+
+	testutils.Assert(t, storage.DeleteFile(ctx, "exports/bundle.csv") == nil, "failed to delete file")
+	_, ok := fake.blobs["/devstoreaccount1/hauser/exports/bundle.csv"]
+	testutils.Assert(t, !ok, "expected blob to be deleted")
+
+	last, err := storage.LastSyncPoint(ctx)
+	testutils.Assert(t, err == nil && last.IsZero(), "expected no sync point, got %s (%v)", last, err)
+	end := time.Date(2020, 8, 26, 2, 0, 0, 0, time.UTC)
+	testutils.Assert(t, storage.SaveSyncPoint(ctx, end) == nil, "failed to save sync point")
+	last, err = storage.LastSyncPoint(ctx)
+	testutils.Assert(t, err == nil && last.Equal(end), "wrong sync point %s (%v)", last, err)
+}
+
+func TestAzureServiceURL(t *testing.T) {
+	testCases := []struct {
+		name    string
+		conf    config.AzureConfig
+		wantURL string
+		wantErr bool
+	}{
+		{
+			name: "azurite connection string",
+			conf: config.AzureConfig{
+				ConnectionString: "DefaultEndpointsProtocol=http;AccountName=devstoreaccount1;" +
+					"AccountKey=Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==;" +
+					"BlobEndpoint=http://127.0.0.1:10000/devstoreaccount1;",
+			},
+			wantURL: "http://127.0.0.1:10000/devstoreaccount1",
+		},
+		{
+			name: "account connection string",
+			conf: config.AzureConfig{
+				ConnectionString: "DefaultEndpointsProtocol=https;AccountName=acct;AccountKey=a2V5;EndpointSuffix=core.usgovcloudapi.net",
+			},
+			wantURL: "https://acct.blob.core.usgovcloudapi.net",
+		},
+		{
+			name:    "account with sas",
+			conf:    config.AzureConfig{AccountName: "acct", SASToken: "sv=2019-12-12&sig=abc"},
+			wantURL: "https://acct.blob.core.windows.net?sv=2019-12-12&sig=abc",
+		},
+		{
+			name:    "no credentials",
+			conf:    config.AzureConfig{AccountName: "acct"},
+			wantErr: true,
+		},
+		{
+			name:    "bad connection string",
+			conf:    config.AzureConfig{ConnectionString: "AccountName"},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, _, err := azureServiceURL(&tc.conf)
+			if tc.wantErr {
+				testutils.Assert(t, err != nil, "expected error")
+				return
+			}
+			testutils.Assert(t, err == nil, "unexpected error: %s", err)
+			testutils.Equals(t, tc.wantURL, u.String(), "wrong service URL")
+		})
+	}
+}
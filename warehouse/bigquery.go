@@ -19,16 +19,38 @@ var (
 		reflect.TypeOf(time.Time{}): bigquery.TimestampFieldType,
 		reflect.TypeOf(float64(0)):  bigquery.FloatFieldType,
 		reflect.TypeOf(int32(0)):    bigquery.IntegerFieldType,
+		reflect.TypeOf(false):       bigquery.BooleanFieldType,
+	}
+
+	// bigQueryWidenings are the type changes that "ALTER COLUMN SET DATA TYPE" supports
+	bigQueryWidenings = typeWidenings{
+		string(bigquery.IntegerFieldType): {string(bigquery.FloatFieldType), string(bigquery.NumericFieldType)},
+		string(bigquery.NumericFieldType): {string(bigquery.FloatFieldType)},
+	}
+
+	// bigQueryDDLTypes are the names of the field types in DDL statements
+	bigQueryDDLTypes = map[string]string{
+		string(bigquery.FloatFieldType):   "FLOAT64",
+		string(bigquery.NumericFieldType): "NUMERIC",
 	}
 )
 
+// bigQueryJSONFieldType is the type of the CustomVars column. It isn't defined by the client library.
+const bigQueryJSONFieldType bigquery.FieldType = "JSON"
+
 type BigQuery struct {
 	conf     *config.BigQueryConfig
 	ctx      context.Context
 	bqClient *bigquery.Client
+	// jsonCustomVars is set if the CustomVars column of the export table has the JSON type
+	jsonCustomVars bool
+	// schema is the last export schema that was applied, used to find the column names of export fields
+	schema Schema
 }
 
-var _ Database = (*BigQuery)(nil)
+var _ DatabaseV2 = (*BigQuery)(nil)
+var _ JSONCustomVarsDatabase = (*BigQuery)(nil)
+var _ MigratingDatabase = (*BigQuery)(nil)
 
 func NewBigQuery(c *config.BigQueryConfig) *BigQuery {
 	return &BigQuery{
@@ -37,29 +59,29 @@ func NewBigQuery(c *config.BigQueryConfig) *BigQuery {
 }
 
 // GetExportTableColumns returns a slice of the columns in the existing export table
-func (bq *BigQuery) GetExportTableColumns() []string {
-	if err := bq.connectToBQ(); err != nil {
-		log.Fatal(err)
+func (bq *BigQuery) GetExportTableColumns(ctx context.Context) ([]string, error) {
+	if err := bq.connectToBQ(ctx); err != nil {
+		return nil, err
 	}
 	defer bq.bqClient.Close()
 
 	table := bq.bqClient.Dataset(bq.conf.Dataset).Table(bq.conf.ExportTable)
-	md, err := table.Metadata(context.Background())
+	md, err := table.Metadata(bq.ctx)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	var columns []string
 	for _, f := range md.Schema {
 		columns = append(columns, strings.ToLower(f.Name))
 	}
-	return columns
+	return columns, nil
 }
 
-func (bq *BigQuery) LastSyncPoint(_ context.Context) (time.Time, error) {
+func (bq *BigQuery) LastSyncPoint(ctx context.Context) (time.Time, error) {
 	t := time.Time{}
 
-	if err := bq.connectToBQ(); err != nil {
+	if err := bq.connectToBQ(ctx); err != nil {
 		return t, err
 	}
 	defer bq.bqClient.Close()
@@ -104,8 +126,8 @@ func (bq *BigQuery) LastSyncPoint(_ context.Context) (time.Time, error) {
 	return t, nil
 }
 
-func (bq *BigQuery) SaveSyncPoint(_ context.Context, endTime time.Time) error {
-	if err := bq.connectToBQ(); err != nil {
+func (bq *BigQuery) SaveSyncPoint(ctx context.Context, endTime time.Time) error {
+	if err := bq.connectToBQ(ctx); err != nil {
 		return err
 	}
 	defer bq.bqClient.Close()
@@ -127,23 +149,37 @@ func (bq *BigQuery) SaveSyncPoint(_ context.Context, endTime time.Time) error {
 	return bq.waitForJob(job)
 }
 
-func (bq *BigQuery) LoadToWarehouse(storageRef string, startTime time.Time) error {
-	if err := bq.connectToBQ(); err != nil {
+func (bq *BigQuery) LoadToWarehouse(ctx context.Context, storageRef string, startTime time.Time) error {
+	if err := bq.connectToBQ(ctx); err != nil {
 		return err
 	}
 	defer bq.bqClient.Close()
 
 	// create loader to load from file into export table
 	gcsRef := bigquery.NewGCSReference(storageRef) // defaults to CSV
-	gcsRef.FileConfig.IgnoreUnknownValues = true
-	gcsRef.AllowJaggedRows = true
-	// Ignore the header
-	gcsRef.SkipLeadingRows = 1
+	switch bq.conf.FileFormat {
+	case config.ParquetFormat:
+		// Parquet columns are matched by name and carry their own types
+		gcsRef.SourceFormat = bigquery.Parquet
+	case config.AvroFormat:
+		gcsRef.SourceFormat = bigquery.Avro
+	case config.JSONFormat:
+		// Objects are matched to columns by name, and missing keys are loaded as nulls
+		gcsRef.SourceFormat = bigquery.JSON
+		gcsRef.FileConfig.IgnoreUnknownValues = true
+	default:
+		gcsRef.FileConfig.IgnoreUnknownValues = true
+		gcsRef.AllowJaggedRows = true
+		// Ignore the header
+		gcsRef.SkipLeadingRows = 1
+	}
 	partitionTable := bq.conf.ExportTable + "$" + startTime.Format("20060102")
 	log.Printf("Loading GCS file: %s into table %s", storageRef, partitionTable)
 
 	loader := bq.bqClient.Dataset(bq.conf.Dataset).Table(partitionTable).LoaderFrom(gcsRef)
 	loader.CreateDisposition = bigquery.CreateNever
+	// Load timestamp-micros fields as TIMESTAMP rather than INTEGER
+	loader.UseAvroLogicalTypes = bq.conf.FileFormat == config.AvroFormat
 	if startTime.Equal(startTime.Truncate(24 * time.Hour)) {
 		// this is the first file of the partition, truncate the partition in case there is leftover data from previous failed loads
 		log.Printf("Detected first bundle of the day (start: %s), using WriteTruncate to replace any existing data in partition", startTime)
@@ -160,19 +196,22 @@ func (bq *BigQuery) LoadToWarehouse(storageRef string, startTime time.Time) erro
 	return bq.waitForJob(job)
 }
 
-func convertSchema(s Schema, existing bigquery.Schema) (bigquery.Schema, error) {
+func (bq *BigQuery) convertSchema(s Schema, existing bigquery.Schema) (bigquery.Schema, error) {
 	bqs := make([]*bigquery.FieldSchema, len(s))
 	for i, field := range s {
 		// Not checking ok here because we may not need it
 		var bqType bigquery.FieldType
-		if field.FullStoryFieldName == "" {
-			// We need to pull the type from the existing schema
+		if field.FieldType == nil {
+			// The column isn't part of the export, so we need to pull the type from the existing schema
 			bqType = existing[i].Type
 		} else {
 			var ok bool
 			if bqType, ok = bigQueryTypeMap[field.FieldType]; !ok {
 				return nil, fmt.Errorf("bigquery field type not found for schema type %s", field.FieldType)
 			}
+			if field.FullStoryFieldName == "CustomVars" && bq.jsonCustomVars {
+				bqType = bigQueryJSONFieldType
+			}
 		}
 
 		if i < len(existing) {
@@ -181,7 +220,16 @@ func convertSchema(s Schema, existing bigquery.Schema) (bigquery.Schema, error)
 				return nil, fmt.Errorf(
 					"columns names don't match at index %d: expected %s, got %s", i, existing[i].Name, field.DBName)
 			} else if bqType != existing[i].Type {
-				return nil, fmt.Errorf("field types don't match at index %d: expected %s, got %s", i, existing[i].Type, bqType)
+				switch {
+				case bigQueryWidenings.canWiden(string(bqType), string(existing[i].Type)):
+					// The existing column can already hold the values
+					bqType = existing[i].Type
+				case bigQueryWidenings.canWiden(string(existing[i].Type), string(bqType)):
+					return nil, fmt.Errorf("field types don't match at index %d: expected %s, got %s. Set SchemaMigrations to migrate the column",
+						i, existing[i].Type, bqType)
+				default:
+					return nil, fmt.Errorf("field types don't match at index %d: expected %s, got %s", i, existing[i].Type, bqType)
+				}
 			}
 		}
 
@@ -193,17 +241,18 @@ func convertSchema(s Schema, existing bigquery.Schema) (bigquery.Schema, error)
 	return bqs, nil
 }
 
-func (bq *BigQuery) InitExportTable(s Schema) (bool, error) {
-	bqSchema, err := convertSchema(s, bigquery.Schema{})
-	if err != nil {
+func (bq *BigQuery) InitExportTable(ctx context.Context, s Schema) (bool, error) {
+	if err := bq.connectToBQ(ctx); err != nil {
 		return false, err
 	}
+	defer bq.bqClient.Close()
 
-	if err := bq.connectToBQ(); err != nil {
-		log.Fatal(err)
-	}
-
+	bq.schema = s
 	if bq.doesTableExist(bq.conf.ExportTable) {
+		if err := bq.initCustomVars(); err != nil {
+			return false, err
+		}
+
 		// Ensure that the expiration is set
 		table := bq.bqClient.Dataset(bq.conf.Dataset).Table(bq.conf.ExportTable)
 		md, err := table.Metadata(bq.ctx)
@@ -219,13 +268,18 @@ func (bq *BigQuery) InitExportTable(s Schema) (bool, error) {
 			}
 			_, err := table.Update(bq.ctx, update, md.ETag)
 			if err != nil {
-				return false, nil
+				return false, err
 			}
 			return true, nil
 		}
 		return false, nil
 	}
 
+	bq.jsonCustomVars = bq.nativeCustomVars()
+	bqSchema, err := bq.convertSchema(s, bigquery.Schema{})
+	if err != nil {
+		return false, err
+	}
 	err = bq.createExportTable(bqSchema)
 	if err != nil {
 		return false, err
@@ -233,19 +287,82 @@ func (bq *BigQuery) InitExportTable(s Schema) (bool, error) {
 	return true, nil
 }
 
-func (bq *BigQuery) ApplyExportSchema(s Schema) error {
-	if err := bq.connectToBQ(); err != nil {
-		log.Fatal(err)
+// nativeCustomVars returns whether a new CustomVars column is created with the JSON type.
+func (bq *BigQuery) nativeCustomVars() bool {
+	return (bq.conf.NativeCustomVars || bq.conf.MigrateCustomVars) && bq.canLoadJSONCustomVars()
+}
+
+// canLoadJSONCustomVars returns whether the files can be loaded into a JSON CustomVars column.
+// Parquet and Avro files would need the column to be annotated as JSON, so they keep using a string.
+func (bq *BigQuery) canLoadJSONCustomVars() bool {
+	return bq.conf.FileFormat == config.CSVFormat || bq.conf.FileFormat == config.JSONFormat
+}
+
+// initCustomVars checks the type of the CustomVars column in the existing export table, and converts
+// it to JSON if MigrateCustomVars is set. The migration moves the column to the end of the table.
+func (bq *BigQuery) initCustomVars() error {
+	table := bq.bqClient.Dataset(bq.conf.Dataset).Table(bq.conf.ExportTable)
+	md, err := table.Metadata(bq.ctx)
+	if err != nil {
+		return err
+	}
+	var current bigquery.FieldType
+	var column string
+	for _, f := range md.Schema {
+		if bq.schema.GetFieldForName(f.Name).FullStoryFieldName == "CustomVars" {
+			current = f.Type
+			column = f.Name
+		}
+	}
+
+	switch {
+	case current == bigQueryJSONFieldType:
+		bq.jsonCustomVars = true
+	case current == "":
+		// A missing column is added by ApplyExportSchema
+		bq.jsonCustomVars = bq.nativeCustomVars()
+	case bq.conf.MigrateCustomVars && bq.canLoadJSONCustomVars():
+		log.Printf("Converting CustomVars column of %s from %s to %s", bq.conf.ExportTable, current, bigQueryJSONFieldType)
+		if err := bq.migrateCustomVars(column); err != nil {
+			return fmt.Errorf("failed to migrate CustomVars column: %s", err)
+		}
+		bq.jsonCustomVars = true
+	default:
+		log.Printf("CustomVars column of %s has type %s. Set MigrateCustomVars to convert it to %s.",
+			bq.conf.ExportTable, current, bigQueryJSONFieldType)
+	}
+	return nil
+}
+
+func (bq *BigQuery) migrateCustomVars(column string) error {
+	// The type of a column can't be changed, so the values are copied into a new column that replaces it
+	q := fmt.Sprintf(`ALTER TABLE %[1]s.%[2]s ADD COLUMN %[3]s_json JSON;
+UPDATE %[1]s.%[2]s SET %[3]s_json = SAFE.PARSE_JSON(%[3]s) WHERE TRUE;
+ALTER TABLE %[1]s.%[2]s DROP COLUMN %[3]s;
+ALTER TABLE %[1]s.%[2]s RENAME COLUMN %[3]s_json TO %[3]s;`, bq.conf.Dataset, bq.conf.ExportTable, column)
+	return bq.runScript(q)
+}
+
+// CustomVarsAsJSON is true if the custom vars are loaded into a JSON column.
+func (bq *BigQuery) CustomVarsAsJSON() bool {
+	return bq.jsonCustomVars
+}
+
+func (bq *BigQuery) ApplyExportSchema(ctx context.Context, s Schema) error {
+	if err := bq.connectToBQ(ctx); err != nil {
+		return err
 	}
+	defer bq.bqClient.Close()
+	bq.schema = s
 
 	// get current table schema in BigQuery
 	table := bq.bqClient.Dataset(bq.conf.Dataset).Table(bq.conf.ExportTable)
-	md, err := table.Metadata(context.Background())
+	md, err := table.Metadata(bq.ctx)
 	if err != nil {
 		return err
 	}
 
-	newSchema, err := convertSchema(s, md.Schema)
+	newSchema, err := bq.convertSchema(s, md.Schema)
 	if err != nil {
 		return fmt.Errorf("failed to convert to bigquery schema: %s", err)
 	}
@@ -256,7 +373,7 @@ func (bq *BigQuery) ApplyExportSchema(s Schema) error {
 			Schema: append(md.Schema, newColumns...),
 		}
 		if _, err := table.Update(bq.ctx, update, md.ETag); err != nil {
-			return nil
+			return err
 		}
 	}
 	return nil
@@ -288,9 +405,10 @@ func (bq *BigQuery) ValueToString(val interface{}, isTime bool) string {
 	return ValueToString(val, isTime)
 }
 
-func (bq *BigQuery) connectToBQ() error {
+// connectToBQ creates the client. The context is kept for the requests of the operation that is connecting.
+func (bq *BigQuery) connectToBQ(ctx context.Context) error {
 	var err error
-	bq.ctx = context.Background()
+	bq.ctx = ctx
 	bq.bqClient, err = bigquery.NewClient(bq.ctx, bq.conf.Project)
 	if err != nil {
 		log.Printf("Could not connect to BigQuery: %s", err)
@@ -333,8 +451,9 @@ func (bq *BigQuery) createExportTable(hauserSchema bigquery.Schema) error {
 	log.Printf("Creating table %s", bq.conf.ExportTable)
 
 	// only EventStart and EventType should be required
+	eventStart, eventType := bq.schema.ColumnFor("EventStart"), bq.schema.ColumnFor("EventType")
 	for i := range hauserSchema {
-		if hauserSchema[i].Name != "EventStart" && hauserSchema[i].Name != "EventType" {
+		if hauserSchema[i].Name != eventStart && hauserSchema[i].Name != eventType {
 			hauserSchema[i].Required = false
 		}
 	}
@@ -385,7 +504,7 @@ func (bq *BigQuery) latestEventStart() (time.Time, error) {
 	}
 
 	// export table exists, get latest EventStart from it
-	q := fmt.Sprintf("SELECT max(EventStart) FROM %s.%s;", bq.conf.Dataset, bq.conf.ExportTable)
+	q := fmt.Sprintf("SELECT max(%s) FROM %s.%s;", bq.schema.ColumnFor("EventStart"), bq.conf.Dataset, bq.conf.ExportTable)
 	return bq.fetchTimeVal(q)
 }
 
@@ -421,3 +540,67 @@ func (bq *BigQuery) waitForJob(job *bigquery.Job) error {
 
 	return nil
 }
+
+// PlanMigrations returns the migrations for the existing columns that have a narrower type than the schema.
+// The CustomVars column is converted to JSON by MigrateCustomVars instead.
+func (bq *BigQuery) PlanMigrations(ctx context.Context, s Schema) ([]Migration, error) {
+	if err := bq.connectToBQ(ctx); err != nil {
+		return nil, err
+	}
+	defer bq.bqClient.Close()
+	table := bq.bqClient.Dataset(bq.conf.Dataset).Table(bq.conf.ExportTable)
+	md, err := table.Metadata(bq.ctx)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]string, len(md.Schema))
+	for _, f := range md.Schema {
+		existing[strings.ToLower(f.Name)] = string(f.Type)
+	}
+
+	var columns columnSchema
+	for _, field := range s {
+		bqType, ok := bigQueryTypeMap[field.FieldType]
+		if !ok || field.FullStoryFieldName == "CustomVars" {
+			continue
+		}
+		columns = append(columns, columnConfig{DBName: field.DBName, DBType: string(bqType), FullStoryFieldName: field.FullStoryFieldName})
+	}
+	return planMigrations(columns, existing, bigQueryWidenings, func(col columnConfig, _ string) []string {
+		return []string{
+			fmt.Sprintf("ALTER TABLE %s.%s ALTER COLUMN %s SET DATA TYPE %s;", bq.conf.Dataset, bq.conf.ExportTable, col.DBName, bigQueryDDLTypes[col.DBType]),
+		}
+	}), nil
+}
+
+// ApplyMigrations runs each migration as a script, together with the insert into the migrations table.
+func (bq *BigQuery) ApplyMigrations(ctx context.Context, migrations []Migration) error {
+	if err := bq.connectToBQ(ctx); err != nil {
+		return err
+	}
+	defer bq.bqClient.Close()
+	table := fmt.Sprintf("%s.%s", bq.conf.Dataset, migrationsTableName(bq.conf.ExportTable))
+	create := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (Applied TIMESTAMP, ColumnName STRING, FromType STRING, ToType STRING);", table)
+	if err := bq.runScript(create); err != nil {
+		return fmt.Errorf("failed to create migrations table: %s", err)
+	}
+	for _, m := range migrations {
+		log.Printf("Migrating column %s", m)
+		script := strings.Join(append(m.Statements,
+			fmt.Sprintf("INSERT INTO %s VALUES (CURRENT_TIMESTAMP(), '%s', '%s', '%s');", table, m.Column, m.From, m.To)), "\n")
+		if err := bq.runScript(script); err != nil {
+			return fmt.Errorf("failed to migrate column %s: %s", m.Column, err)
+		}
+	}
+	return nil
+}
+
+func (bq *BigQuery) runScript(q string) error {
+	query := bq.bqClient.Query(q)
+	query.QueryConfig.UseStandardSQL = true
+	job, err := query.Run(bq.ctx)
+	if err != nil {
+		return err
+	}
+	return bq.waitForJob(job)
+}
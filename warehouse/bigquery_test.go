@@ -1,9 +1,12 @@
 package warehouse
 
 import (
+	"reflect"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/bigquery"
+	"github.com/fullstorydev/hauser/config"
 	"github.com/fullstorydev/hauser/testing/testutils"
 )
 
@@ -85,3 +88,34 @@ func TestBigquerySchema(t *testing.T) {
 		testutils.Assert(t, ok, "field type %v not found in bigQueryTypeMap", field.FieldType)
 	}
 }
+
+func TestBigQueryCustomVarsType(t *testing.T) {
+	schema := Schema{
+		{DBName: "EventStart", FullStoryFieldName: "EventStart", FieldType: reflect.TypeOf(time.Time{})},
+		{DBName: "CustomVars", FullStoryFieldName: "CustomVars", FieldType: reflect.TypeOf("")},
+	}
+
+	wh := &BigQuery{jsonCustomVars: true}
+	bqs, err := wh.convertSchema(schema, bigquery.Schema{})
+	testutils.Assert(t, err == nil, "unexpected error: %s", err)
+	testutils.Equals(t, bigQueryJSONFieldType, bqs[1].Type, "wrong type for new column")
+
+	// An existing string column is kept until it is migrated
+	existing := bigquery.Schema{
+		{Name: "EventStart", Type: bigquery.TimestampFieldType},
+		{Name: "CustomVars", Type: bigquery.StringFieldType},
+	}
+	wh.jsonCustomVars = false
+	bqs, err = wh.convertSchema(schema, existing)
+	testutils.Assert(t, err == nil, "unexpected error: %s", err)
+	testutils.Equals(t, bigquery.StringFieldType, bqs[1].Type, "wrong type for existing column")
+
+	wh.jsonCustomVars = true
+	_, err = wh.convertSchema(schema, existing)
+	testutils.Assert(t, err != nil, "expected type mismatch")
+
+	// New columns only get the JSON type if it is enabled, and the files can be loaded into it
+	testutils.Assert(t, !NewBigQuery(&config.BigQueryConfig{FileFormat: config.CSVFormat}).nativeCustomVars(), "expected a string column by default")
+	testutils.Assert(t, NewBigQuery(&config.BigQueryConfig{FileFormat: config.CSVFormat, NativeCustomVars: true}).nativeCustomVars(), "expected a JSON column")
+	testutils.Assert(t, !NewBigQuery(&config.BigQueryConfig{FileFormat: config.ParquetFormat, NativeCustomVars: true}).nativeCustomVars(), "expected a string column for Parquet")
+}
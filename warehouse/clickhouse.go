@@ -0,0 +1,312 @@
+package warehouse
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fullstorydev/hauser/config"
+)
+
+// clickHouseTimeFormat is the basic format that ClickHouse parses into a DateTime64(6) column.
+const clickHouseTimeFormat = "2006-01-02 15:04:05.999999"
+
+// ClickHouse loads the export into a MergeTree table through the ClickHouse HTTP interface.
+type ClickHouse struct {
+	conf       *config.ClickHouseConfig
+	httpClient *http.Client
+	syncSchema Schema
+	// schema is the last export schema that was applied, used to find the EventStart column
+	schema Schema
+}
+
+var (
+	clickHouseSchemaMap = map[reflect.Type]string{
+		reflect.TypeOf(int64(0)):    "Int64",
+		reflect.TypeOf(""):          "String",
+		reflect.TypeOf(time.Time{}): "DateTime64(6, 'UTC')",
+		reflect.TypeOf(float64(0)):  "Float64",
+		reflect.TypeOf(int32(0)):    "Int32",
+		reflect.TypeOf(false):       "Bool",
+	}
+)
+
+var _ DatabaseV2 = (*ClickHouse)(nil)
+
+func NewClickHouse(c *config.ClickHouseConfig) *ClickHouse {
+	return &ClickHouse{
+		conf:       c,
+		httpClient: &http.Client{Timeout: c.Timeout.Duration},
+		syncSchema: MakeSchema(syncTable{}),
+	}
+}
+
+func (ch *ClickHouse) qualifiedExportTableName() string {
+	return fmt.Sprintf("%s.%s", ch.conf.DB, ch.conf.ExportTable)
+}
+
+func (ch *ClickHouse) qualifiedSyncTableName() string {
+	return fmt.Sprintf("%s.%s", ch.conf.DB, ch.conf.SyncTable)
+}
+
+// toClickHouseColumns converts the schema to column definitions. EventStart is used as the partition
+// and sorting key so it cannot be nullable, but any other field can be missing from a record.
+func toClickHouseColumns(columns []columnConfig) columnSchema {
+	for i := range columns {
+		if columns[i].FullStoryFieldName != "EventStart" {
+			columns[i].DBType = fmt.Sprintf("Nullable(%s)", columns[i].DBType)
+		}
+	}
+	return columns
+}
+
+// query sends the statement to the HTTP interface and returns the response body. Query parameters
+// are referenced in the statement as "{name:Type}", and settings are passed through as URL parameters.
+func (ch *ClickHouse) query(ctx context.Context, stmt string, params map[string]string, body io.Reader) (string, error) {
+	values := url.Values{}
+	values.Set("database", ch.conf.DB)
+	for k, v := range params {
+		values.Set(k, v)
+	}
+
+	var req *http.Request
+	var err error
+	if body == nil {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, ch.conf.URL+"/?"+values.Encode(), strings.NewReader(stmt))
+	} else {
+		// When there is data to insert, the statement has to be passed in the URL
+		values.Set("query", stmt)
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, ch.conf.URL+"/?"+values.Encode(), body)
+	}
+	if err != nil {
+		return "", err
+	}
+	if ch.conf.User != "" {
+		req.Header.Set("X-ClickHouse-User", ch.conf.User)
+		req.Header.Set("X-ClickHouse-Key", ch.conf.Password)
+	}
+
+	resp, err := ch.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("clickhouse request error : (%v)", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("clickhouse query failed with status %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return string(respBody), nil
+}
+
+// GetExportTableColumns returns all the columns of the export table.
+func (ch *ClickHouse) GetExportTableColumns(ctx context.Context) ([]string, error) {
+	return ch.getTableColumns(ctx, ch.conf.ExportTable)
+}
+
+func (ch *ClickHouse) ValueToString(val interface{}, isTime bool) string {
+	s := fmt.Sprintf("%v", val)
+	if isTime {
+		t, _ := time.Parse(time.RFC3339Nano, s)
+		return t.UTC().Format(clickHouseTimeFormat)
+	}
+	return s
+}
+
+// LoadToWarehouse sends the local CSV file to ClickHouse as the body of an INSERT statement.
+func (ch *ClickHouse) LoadToWarehouse(ctx context.Context, localFile string, _ time.Time) error {
+	f, err := os.Open(localFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stmt := fmt.Sprintf("INSERT INTO %s FORMAT CSVWithNames", ch.qualifiedExportTableName())
+	_, err = ch.query(ctx, stmt, map[string]string{
+		// Match the CSV columns by name and treat empty values as NULL
+		"input_format_with_names_use_header": "1",
+		"input_format_csv_empty_as_default":  "1",
+	}, f)
+	return err
+}
+
+func (ch *ClickHouse) InitExportTable(ctx context.Context, schema Schema) (bool, error) {
+	ch.schema = schema
+	exists, err := ch.DoesTableExist(ctx, ch.conf.ExportTable)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+
+	log.Printf("Export table %s does not exist! Creating one!", ch.qualifiedExportTableName())
+	columns := toClickHouseColumns(schemaToColumns(schema, clickHouseSchemaMap, "clickhouse"))
+	eventStart := schema.ColumnFor("EventStart")
+	stmt := fmt.Sprintf("CREATE TABLE %s (%s) ENGINE = MergeTree PARTITION BY toDate(%s) ORDER BY %s",
+		ch.qualifiedExportTableName(), columns, eventStart, eventStart)
+	if _, err := ch.query(ctx, stmt, nil, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (ch *ClickHouse) ApplyExportSchema(ctx context.Context, newSchema Schema) error {
+	ch.schema = newSchema
+	existingColumns, err := ch.getTableColumns(ctx, ch.conf.ExportTable)
+	if err != nil {
+		return err
+	}
+	missingFields, err := getColumnsToAdd(newSchema, existingColumns, clickHouseSchemaMap)
+	if err != nil {
+		return err
+	}
+	if len(missingFields) > 0 {
+		log.Printf("Found %d missing fields. Adding columns for these fields.", len(missingFields))
+		adds := make([]string, len(missingFields))
+		for i, f := range toClickHouseColumns(missingFields) {
+			adds[i] = fmt.Sprintf("ADD COLUMN %s %s", f.DBName, f.DBType)
+		}
+		alterStmt := fmt.Sprintf("ALTER TABLE %s %s", ch.qualifiedExportTableName(), strings.Join(adds, ", "))
+		if _, err := ch.query(ctx, alterStmt, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateSyncTable creates a sync table with the hauser sync table schema
+func (ch *ClickHouse) CreateSyncTable(ctx context.Context) error {
+	log.Printf("Creating table %s", ch.qualifiedSyncTableName())
+
+	stmt := fmt.Sprintf("CREATE TABLE %s (%s) ENGINE = MergeTree ORDER BY BundleEndTime",
+		ch.qualifiedSyncTableName(), schemaToColumns(ch.syncSchema, clickHouseSchemaMap, "clickhouse"))
+	_, err := ch.query(ctx, stmt, nil, nil)
+	return err
+}
+
+func (ch *ClickHouse) SaveSyncPoint(ctx context.Context, endTime time.Time) error {
+	insert := fmt.Sprintf("INSERT INTO %s VALUES (-1, {processed:DateTime64(6, 'UTC')}, {end:DateTime64(6, 'UTC')})", ch.qualifiedSyncTableName())
+	_, err := ch.query(ctx, insert, map[string]string{
+		"param_processed": time.Now().UTC().Format(clickHouseTimeFormat),
+		"param_end":       endTime.UTC().Format(clickHouseTimeFormat),
+	}, nil)
+	return err
+}
+
+func (ch *ClickHouse) LastSyncPoint(ctx context.Context) (time.Time, error) {
+	exists, err := ch.DoesTableExist(ctx, ch.conf.SyncTable)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !exists {
+		if err := ch.CreateSyncTable(ctx); err != nil {
+			log.Printf("Couldn't create sync table: %s", err)
+			return time.Time{}, err
+		}
+		return time.Time{}, nil
+	}
+
+	t, err := ch.fetchMaxTime(ctx, ch.conf.SyncTable, "BundleEndTime")
+	if err != nil {
+		log.Printf("Couldn't get max(BundleEndTime): %s", err)
+		return t, err
+	}
+
+	if err := ch.RemoveOrphanedRecords(ctx, t); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// RemoveOrphanedRecords deletes export records that were loaded after the last sync point, which
+// happens when hauser stops after loading a file but before saving the sync point.
+func (ch *ClickHouse) RemoveOrphanedRecords(ctx context.Context, lastSync time.Time) error {
+	exists, err := ch.DoesTableExist(ctx, ch.conf.ExportTable)
+	if err != nil || !exists {
+		// A missing table is okay, because the hauser process will ensure that the table exists.
+		return err
+	}
+
+	exportTime, err := ch.fetchMaxTime(ctx, ch.conf.ExportTable, ch.schema.ColumnFor("EventStart"))
+	if err != nil {
+		log.Printf("Couldn't get max(%s): %s", ch.schema.ColumnFor("EventStart"), err)
+		return err
+	}
+	if exportTime.After(lastSync) {
+		log.Printf("Export record timestamp after sync time (%s vs %s); cleaning", exportTime, lastSync)
+		stmt := fmt.Sprintf("ALTER TABLE %s DELETE WHERE %s > {end:DateTime64(6, 'UTC')}",
+			ch.qualifiedExportTableName(), ch.schema.ColumnFor("EventStart"))
+		if _, err := ch.query(ctx, stmt, map[string]string{
+			"param_end": lastSync.UTC().Format(clickHouseTimeFormat),
+			// Deletes are asynchronous by default, so wait for them to finish before loading more data
+			"mutations_sync": "1",
+		}, nil); err != nil {
+			log.Printf("failed to delete from %s: %s", ch.qualifiedExportTableName(), err)
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchMaxTime returns the maximum value of the column, or the zero time if the table is empty.
+// ClickHouse returns the epoch for max() over an empty table, so the row count is fetched as well.
+func (ch *ClickHouse) fetchMaxTime(ctx context.Context, table, column string) (time.Time, error) {
+	q := fmt.Sprintf("SELECT count(), max(%s) FROM %s.%s FORMAT TabSeparated", column, ch.conf.DB, table)
+	resp, err := ch.query(ctx, q, nil, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	parts := strings.Split(strings.TrimSpace(resp), "\t")
+	if len(parts) != 2 {
+		return time.Time{}, fmt.Errorf("unexpected response for max(%s): %q", column, resp)
+	}
+	if count, err := strconv.Atoi(parts[0]); err != nil {
+		return time.Time{}, err
+	} else if count == 0 {
+		return time.Time{}, nil
+	}
+	return time.Parse(clickHouseTimeFormat, parts[1])
+}
+
+// DoesTableExist checks if a table with a given name exists
+func (ch *ClickHouse) DoesTableExist(ctx context.Context, name string) (bool, error) {
+	log.Printf("Checking if table %s exists", name)
+	resp, err := ch.query(ctx, fmt.Sprintf("EXISTS TABLE %s.%s FORMAT TabSeparated", ch.conf.DB, name), nil, nil)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(resp) == "1", nil
+}
+
+func (ch *ClickHouse) getTableColumns(ctx context.Context, name string) ([]string, error) {
+	log.Printf("Fetching columns for table %s", name)
+	q := "SELECT name FROM system.columns WHERE database = {db:String} AND table = {table:String} ORDER BY position FORMAT TabSeparated"
+	resp, err := ch.query(ctx, q, map[string]string{
+		"param_db":    ch.conf.DB,
+		"param_table": name,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var columns []string
+	for _, line := range strings.Split(resp, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			columns = append(columns, line)
+		}
+	}
+	return columns, nil
+}
@@ -0,0 +1,133 @@
+package warehouse
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/hauser/config"
+	"github.com/fullstorydev/hauser/testing/testutils"
+)
+
+// fakeClickHouse records the statements sent to the HTTP interface and replies with canned responses.
+type fakeClickHouse struct {
+	statements []string
+	bodies     []string
+	responses  map[string]string
+}
+
+func (f *fakeClickHouse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	stmt := r.URL.Query().Get("query")
+	if stmt == "" {
+		stmt = string(body)
+	} else {
+		f.bodies = append(f.bodies, string(body))
+	}
+	f.statements = append(f.statements, stmt)
+	for prefix, resp := range f.responses {
+		if strings.HasPrefix(stmt, prefix) {
+			w.Write([]byte(resp))
+			return
+		}
+	}
+}
+
+// newTestClickHouse returns a ClickHouse that sends its statements to a fake server, which is closed
+// at the end of the test.
+func newTestClickHouse(t *testing.T, responses map[string]string) (*ClickHouse, *fakeClickHouse) {
+	fake := &fakeClickHouse{responses: responses}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	ch := NewClickHouse(&config.ClickHouseConfig{
+		URL:         server.URL,
+		DB:          "fs",
+		ExportTable: "fsexport",
+		SyncTable:   "fssync",
+		Timeout:     config.Duration{Duration: time.Second},
+	})
+	return ch, fake
+}
+
+func TestClickHouseSchema(t *testing.T) {
+	fs := MakeSchema(BaseExportFields{}, MobileFields{}, syncTable{})
+	for _, field := range fs {
+		_, ok := clickHouseSchemaMap[field.FieldType]
+		testutils.Assert(t, ok, "field type %v not found in clickHouseSchemaMap", field.FieldType)
+	}
+}
+
+func TestClickHouseInitExportTable(t *testing.T) {
+	ch, fake := newTestClickHouse(t, map[string]string{"EXISTS TABLE": "0\n"})
+
+	schema := MakeSchema(struct {
+		EventStart   time.Time
+		PageDuration int64
+	}{})
+	created, err := ch.InitExportTable(context.Background(), schema)
+	testutils.Assert(t, err == nil, "unexpected error: %s", err)
+	testutils.Assert(t, created, "expected table to be created")
+	testutils.StrSliceEquals(t, []string{
+		"EXISTS TABLE fs.fsexport FORMAT TabSeparated",
+		"CREATE TABLE fs.fsexport (EventStart DateTime64(6, 'UTC'),PageDuration Nullable(Int64)) ENGINE = MergeTree PARTITION BY toDate(EventStart) ORDER BY EventStart",
+	}, fake.statements, "wrong statements")
+}
+
+func TestClickHouseApplyExportSchema(t *testing.T) {
+	ch, fake := newTestClickHouse(t, map[string]string{"SELECT name FROM system.columns": "EventStart\n"})
+
+	schema := MakeSchema(struct {
+		EventStart time.Time
+		PageUrl    string
+		CustomVars string
+	}{})
+	testutils.Assert(t, ch.ApplyExportSchema(context.Background(), schema) == nil, "failed to apply schema")
+	testutils.Equals(t, "ALTER TABLE fs.fsexport ADD COLUMN PageUrl Nullable(String), ADD COLUMN CustomVars Nullable(String)",
+		fake.statements[len(fake.statements)-1], "wrong alter statement")
+}
+
+func TestClickHouseLoadToWarehouse(t *testing.T) {
+	ch, fake := newTestClickHouse(t, nil)
+
+	dir, err := ioutil.TempDir("", "hauser-clickhouse")
+	testutils.Assert(t, err == nil, "failed to create temp dir: %s", err)
+	defer os.RemoveAll(dir)
+	data := "EventStart,PageDuration\n2020-08-26 01:00:00,42\n"
+	csvFile := filepath.Join(dir, "bundle.csv")
+	testutils.Assert(t, ioutil.WriteFile(csvFile, []byte(data), 0666) == nil, "failed to write csv")
+
+	testutils.Assert(t, ch.LoadToWarehouse(context.Background(), csvFile, time.Time{}) == nil, "failed to load")
+	testutils.StrSliceEquals(t, []string{"INSERT INTO fs.fsexport FORMAT CSVWithNames"}, fake.statements, "wrong statements")
+	testutils.StrSliceEquals(t, []string{data}, fake.bodies, "wrong body")
+}
+
+func TestClickHouseLastSyncPoint(t *testing.T) {
+	ch, fake := newTestClickHouse(t, map[string]string{
+		"EXISTS TABLE": "1\n",
+		"SELECT count(), max(BundleEndTime) FROM fs.fssync": "3\t2020-08-26 00:00:00.000000\n",
+		"SELECT count(), max(EventStart) FROM fs.fsexport":  "10\t2020-08-26 05:00:00.500000\n",
+	})
+
+	lastSync, err := ch.LastSyncPoint(context.Background())
+	testutils.Assert(t, err == nil, "unexpected error: %s", err)
+	testutils.Assert(t, lastSync.Equal(time.Date(2020, 8, 26, 0, 0, 0, 0, time.UTC)), "wrong sync point: %s", lastSync)
+	testutils.Equals(t, "ALTER TABLE fs.fsexport DELETE WHERE EventStart > {end:DateTime64(6, 'UTC')}",
+		fake.statements[len(fake.statements)-1], "expected orphaned records to be removed")
+}
+
+func TestClickHouseQueryError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Code: 60. DB::Exception: Table fs.fsexport doesn't exist", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	ch := NewClickHouse(&config.ClickHouseConfig{URL: server.URL, DB: "fs", ExportTable: "fsexport"})
+	_, err := ch.getTableColumns(context.Background(), "fsexport")
+	testutils.Assert(t, err != nil && strings.Contains(err.Error(), "Code: 60"), "expected error from server, got %v", err)
+}
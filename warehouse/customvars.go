@@ -0,0 +1,81 @@
+package warehouse
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// customVarPrefixes are the prefixes of the custom var names in the export, one for each of the
+// wildcard fields that are requested.
+var customVarPrefixes = []string{"user_", "evt_", "page_"}
+
+// customVarTypes maps the type suffixes of custom var names to the type of their column.
+// Lists are stored as JSON arrays in a string column.
+var customVarTypes = map[string]reflect.Type{
+	"str":   reflect.TypeOf(""),
+	"int":   reflect.TypeOf(int64(0)),
+	"real":  reflect.TypeOf(float64(0)),
+	"bool":  reflect.TypeOf(false),
+	"date":  reflect.TypeOf(time.Time{}),
+	"strs":  reflect.TypeOf(""),
+	"ints":  reflect.TypeOf(""),
+	"reals": reflect.TypeOf(""),
+	"bools": reflect.TypeOf(""),
+	"dates": reflect.TypeOf(""),
+}
+
+var invalidColumnChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// IsCustomVar returns true if the field name is a custom var rather than a standard export field.
+func IsCustomVar(name string) bool {
+	lower := strings.ToLower(name)
+	for _, prefix := range customVarPrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// CustomVarField returns the field for a custom var with its own column, using the type suffix of
+// the name. It returns false if the name isn't a custom var or the suffix is unknown.
+func CustomVarField(name string) (WarehouseField, bool) {
+	if !IsCustomVar(name) {
+		return WarehouseField{}, false
+	}
+	i := strings.LastIndex(name, "_")
+	fieldType, ok := customVarTypes[strings.ToLower(name[i+1:])]
+	if !ok || i < strings.Index(name, "_")+1 {
+		// There has to be a name between the prefix and the suffix
+		return WarehouseField{}, false
+	}
+	return WarehouseField{
+		DBName:             invalidColumnChars.ReplaceAllString(name, "_"),
+		FullStoryFieldName: name,
+		FieldType:          fieldType,
+	}, true
+}
+
+// WithCustomVarColumns returns the schema with the columns that aren't part of the export replaced
+// by custom var fields if their names match a custom var, so that exploded custom vars are restored
+// from an existing table. The column prefix of the namer is ignored when matching the names.
+func (s Schema) WithCustomVarColumns(n ColumnNamer) Schema {
+	newSchema := make(Schema, len(s))
+	for i, field := range s {
+		newSchema[i] = field
+		if field.FullStoryFieldName != "" {
+			continue
+		}
+		name := field.DBName
+		if len(name) > len(n.Prefix) && strings.EqualFold(name[:len(n.Prefix)], n.Prefix) {
+			name = name[len(n.Prefix):]
+		}
+		if cv, ok := CustomVarField(name); ok {
+			cv.DBName = field.DBName
+			newSchema[i] = cv
+		}
+	}
+	return newSchema
+}
@@ -0,0 +1,57 @@
+package warehouse
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/hauser/testing/testutils"
+)
+
+func TestCustomVarField(t *testing.T) {
+	testCases := []struct {
+		name     string
+		ok       bool
+		dbName   string
+		expected reflect.Type
+	}{
+		{"user_plan_str", true, "user_plan_str", reflect.TypeOf("")},
+		{"evt_price_raw_real", true, "evt_price_raw_real", reflect.TypeOf(float64(0))},
+		{"page_count_int", true, "page_count_int", reflect.TypeOf(int64(0))},
+		{"user_admin_bool", true, "user_admin_bool", reflect.TypeOf(false)},
+		{"user_signup_date", true, "user_signup_date", reflect.TypeOf(time.Time{})},
+		{"user_tags_strs", true, "user_tags_strs", reflect.TypeOf("")},
+		{"evt_order.total_real", true, "evt_order_total_real", reflect.TypeOf(float64(0))},
+		{"user_plan_unknown", false, "", nil},
+		{"user_str", false, "", nil},
+		{"PageUrl", false, "", nil},
+	}
+	for _, tc := range testCases {
+		field, ok := CustomVarField(tc.name)
+		testutils.Equals(t, tc.ok, ok, "wrong result for %s", tc.name)
+		if ok {
+			testutils.Equals(t, tc.dbName, field.DBName, "wrong column name for %s", tc.name)
+			testutils.Equals(t, tc.name, field.FullStoryFieldName, "wrong field name for %s", tc.name)
+			testutils.Equals(t, tc.expected, field.FieldType, "wrong type for %s", tc.name)
+		}
+	}
+}
+
+func TestWithCustomVarColumns(t *testing.T) {
+	schema := Schema{
+		{DBName: "EventStart", FullStoryFieldName: "EventStart", FieldType: reflect.TypeOf(time.Time{})},
+		{DBName: "evt_code_str"},
+		{DBName: "CustomColumn"},
+	}
+	restored := schema.WithCustomVarColumns(ColumnNamer{})
+	testutils.Equals(t, schema[0], restored[0], "standard field changed")
+	testutils.Equals(t, WarehouseField{DBName: "evt_code_str", FullStoryFieldName: "evt_code_str", FieldType: reflect.TypeOf("")}, restored[1], "custom var not restored")
+	testutils.Equals(t, schema[2], restored[2], "extra column changed")
+
+	// The column prefix isn't part of the custom var name
+	prefixed := Schema{{DBName: "fs_evt_code_str"}}.WithCustomVarColumns(ColumnNamer{Prefix: "fs_"})
+	testutils.Equals(t, WarehouseField{DBName: "fs_evt_code_str", FullStoryFieldName: "evt_code_str", FieldType: reflect.TypeOf("")}, prefixed[0], "prefixed custom var not restored")
+
+	// The custom var columns are covered by the wildcards
+	testutils.StrSliceEquals(t, []string{"EventStart"}, Schema{restored[0], restored[1]}.GetFullStoryFields(), "wrong export fields")
+}
@@ -0,0 +1,339 @@
+package warehouse
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/fullstorydev/hauser/config"
+)
+
+const (
+	defaultElasticsearchBatchSize = 1000
+
+	// Daily indices are named with the date of the event
+	elasticsearchIndexDateFormat = "2006.01.02"
+)
+
+var (
+	elasticsearchTypeMap = map[reflect.Type]string{
+		reflect.TypeOf(int64(0)):    "long",
+		reflect.TypeOf(""):          "text",
+		reflect.TypeOf(time.Time{}): "date",
+		reflect.TypeOf(float64(0)):  "double",
+		reflect.TypeOf(int32(0)):    "integer",
+		reflect.TypeOf(false):       "boolean",
+	}
+)
+
+// Elasticsearch indexes export records into daily indices with the bulk API. It works with both
+// Elasticsearch and OpenSearch. Like Kafka, there are no tables, so the schema is used to generate
+// an index template instead. Sync points are kept as documents in a dedicated index.
+type Elasticsearch struct {
+	conf       *config.ElasticsearchConfig
+	httpClient *http.Client
+	schema     Schema
+}
+
+var _ DatabaseV2 = (*Elasticsearch)(nil)
+
+func NewElasticsearch(c *config.ElasticsearchConfig) *Elasticsearch {
+	return &Elasticsearch{
+		conf:       c,
+		httpClient: &http.Client{Timeout: c.Timeout.Duration},
+	}
+}
+
+func (es *Elasticsearch) batchSize() int {
+	if es.conf.BatchSize > 0 {
+		return es.conf.BatchSize
+	}
+	return defaultElasticsearchBatchSize
+}
+
+func (es *Elasticsearch) indexName(eventStart time.Time) string {
+	return fmt.Sprintf("%s-%s", es.conf.IndexPrefix, eventStart.UTC().Format(elasticsearchIndexDateFormat))
+}
+
+// do sends a request with a JSON body and decodes the JSON response into out, if provided.
+// It returns the response status code so that callers can handle missing indices.
+func (es *Elasticsearch) do(ctx context.Context, method, path string, body io.Reader, out interface{}) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(es.conf.URL, "/")+path, body)
+	if err != nil {
+		return 0, err
+	}
+	if strings.HasPrefix(path, "/_bulk") {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if es.conf.APIKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+es.conf.APIKey)
+	} else if es.conf.User != "" {
+		req.SetBasicAuth(es.conf.User, es.conf.Password)
+	}
+
+	resp, err := es.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("elasticsearch request error : (%v)", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("elasticsearch request %s %s failed with status %s: %s",
+			method, path, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	if out != nil {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return resp.StatusCode, fmt.Errorf("failed to decode elasticsearch response: %s", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// indexTemplate generates the mappings for the export indices from the schema. Strings are
+// indexed as text for full-text search, with a keyword sub-field for aggregations.
+func (es *Elasticsearch) indexTemplate(s Schema) (map[string]interface{}, error) {
+	properties := make(map[string]interface{}, len(s))
+	for _, field := range s {
+		if field.FieldType == nil {
+			continue
+		}
+		if field.FullStoryFieldName == "CustomVars" {
+			properties[field.DBName] = map[string]interface{}{"type": "object"}
+			continue
+		}
+		esType, ok := elasticsearchTypeMap[field.FieldType]
+		if !ok {
+			return nil, fmt.Errorf("elasticsearch field type not found for schema type %s", field.FieldType)
+		}
+		mapping := map[string]interface{}{"type": esType}
+		if esType == "text" {
+			mapping["fields"] = map[string]interface{}{
+				"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 256},
+			}
+		}
+		properties[field.DBName] = mapping
+	}
+	return map[string]interface{}{
+		"index_patterns": []string{es.conf.IndexPrefix + "-*"},
+		"template": map[string]interface{}{
+			"mappings": map[string]interface{}{
+				"properties": properties,
+			},
+		},
+	}, nil
+}
+
+// InitExportTable creates or updates the index template for the daily indices. Existing indices keep
+// their mappings, but new fields are mapped dynamically, so no further schema changes are needed.
+func (es *Elasticsearch) InitExportTable(ctx context.Context, s Schema) (bool, error) {
+	es.schema = s
+	template, err := es.indexTemplate(s)
+	if err != nil {
+		return false, err
+	}
+	body, err := json.Marshal(template)
+	if err != nil {
+		return false, err
+	}
+	log.Printf("Updating index template %s", es.conf.IndexPrefix)
+	if _, err := es.do(ctx, http.MethodPut, "/_index_template/"+es.conf.IndexPrefix, bytes.NewReader(body), nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (es *Elasticsearch) ApplyExportSchema(ctx context.Context, s Schema) error {
+	_, err := es.InitExportTable(ctx, s)
+	return err
+}
+
+func (es *Elasticsearch) GetExportTableColumns(_ context.Context) ([]string, error) {
+	cols := make([]string, 0, len(es.schema))
+	for _, f := range es.schema {
+		cols = append(cols, f.DBName)
+	}
+	return cols, nil
+}
+
+// ValueToString keeps values intact since they are re-encoded as JSON.
+func (es *Elasticsearch) ValueToString(val interface{}, isTime bool) string {
+	s := fmt.Sprintf("%v", val)
+	if isTime {
+		t, _ := time.Parse(time.RFC3339Nano, s)
+		return t.Format(RFC3339Micro)
+	}
+	return s
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// LoadToWarehouse indexes the records of the local CSV file with the bulk API. Document IDs are derived
+// from the record contents, so reloading a bundle after a failure overwrites instead of duplicating.
+func (es *Elasticsearch) LoadToWarehouse(ctx context.Context, localFile string, _ time.Time) error {
+	f, err := os.Open(localFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	csvIn := csv.NewReader(f)
+	headers, err := csvIn.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read csv header: %s", err)
+	}
+	fields := make([]WarehouseField, len(headers))
+	eventStartIdx := -1
+	for i, h := range headers {
+		fields[i] = es.schema.GetFieldForName(h)
+		if fields[i].FullStoryFieldName == "EventStart" {
+			eventStartIdx = i
+		}
+	}
+	if eventStartIdx == -1 {
+		return fmt.Errorf("EventStart column missing from %s", localFile)
+	}
+
+	var buf bytes.Buffer
+	var count, total int
+	for {
+		record, err := csvIn.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read csv record: %s", err)
+		}
+		if err := es.appendBulkItem(&buf, fields, record, eventStartIdx); err != nil {
+			return err
+		}
+		count++
+		if count == es.batchSize() {
+			if err := es.sendBulk(ctx, &buf); err != nil {
+				return err
+			}
+			total += count
+			count = 0
+		}
+	}
+	if count > 0 {
+		if err := es.sendBulk(ctx, &buf); err != nil {
+			return err
+		}
+		total += count
+	}
+	log.Printf("Indexed %d documents", total)
+	return nil
+}
+
+func (es *Elasticsearch) appendBulkItem(buf *bytes.Buffer, fields []WarehouseField, record []string, eventStartIdx int) error {
+	eventStart, err := time.Parse(time.RFC3339Nano, record[eventStartIdx])
+	if err != nil {
+		return fmt.Errorf("invalid EventStart %q: %s", record[eventStartIdx], err)
+	}
+	hash := sha1.Sum([]byte(strings.Join(record, "\x00")))
+	action := map[string]interface{}{
+		"index": map[string]string{
+			"_index": es.indexName(eventStart),
+			"_id":    hex.EncodeToString(hash[:]),
+		},
+	}
+	enc := json.NewEncoder(buf)
+	if err := enc.Encode(action); err != nil {
+		return err
+	}
+	return enc.Encode(recordToObject(fields, record))
+}
+
+func (es *Elasticsearch) sendBulk(ctx context.Context, buf *bytes.Buffer) error {
+	defer buf.Reset()
+	var resp bulkResponse
+	if _, err := es.do(ctx, http.MethodPost, "/_bulk", bytes.NewReader(buf.Bytes()), &resp); err != nil {
+		return err
+	}
+	if !resp.Errors {
+		return nil
+	}
+	var failed int
+	var firstErr string
+	for _, item := range resp.Items {
+		for _, result := range item {
+			if result.Status >= 300 {
+				if failed == 0 {
+					firstErr = string(result.Error)
+				}
+				failed++
+			}
+		}
+	}
+	return fmt.Errorf("failed to index %d documents, first error: %s", failed, firstErr)
+}
+
+// createSyncIndex creates the index used to store sync points.
+func (es *Elasticsearch) createSyncIndex(ctx context.Context) error {
+	log.Printf("Creating index %s", es.conf.SyncIndex)
+	body := `{"mappings":{"properties":{"ID":{"type":"long"},"Processed":{"type":"date"},"BundleEndTime":{"type":"date"}}}}`
+	_, err := es.do(ctx, http.MethodPut, "/"+es.conf.SyncIndex, strings.NewReader(body), nil)
+	return err
+}
+
+func (es *Elasticsearch) SaveSyncPoint(ctx context.Context, endTime time.Time) error {
+	doc, err := json.Marshal(map[string]interface{}{
+		"ID":            -1,
+		"Processed":     time.Now().UTC().Format(time.RFC3339),
+		"BundleEndTime": endTime.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	// Wait for the refresh so that the sync point is visible to the next search
+	_, err = es.do(ctx, http.MethodPost, "/"+es.conf.SyncIndex+"/_doc?refresh=wait_for", bytes.NewReader(doc), nil)
+	return err
+}
+
+func (es *Elasticsearch) LastSyncPoint(ctx context.Context) (time.Time, error) {
+	query := `{"size":0,"aggs":{"last_sync":{"max":{"field":"BundleEndTime"}}}}`
+	var resp struct {
+		Aggregations struct {
+			LastSync struct {
+				Value         *float64 `json:"value"`
+				ValueAsString string   `json:"value_as_string"`
+			} `json:"last_sync"`
+		} `json:"aggregations"`
+	}
+	status, err := es.do(ctx, http.MethodPost, "/"+es.conf.SyncIndex+"/_search", strings.NewReader(query), &resp)
+	if status == http.StatusNotFound {
+		return time.Time{}, es.createSyncIndex(ctx)
+	}
+	if err != nil {
+		log.Printf("Couldn't get max(BundleEndTime): %s", err)
+		return time.Time{}, err
+	}
+	if resp.Aggregations.LastSync.Value == nil {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, resp.Aggregations.LastSync.ValueAsString)
+}
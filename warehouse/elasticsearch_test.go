@@ -0,0 +1,139 @@
+package warehouse
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/hauser/config"
+	"github.com/fullstorydev/hauser/testing/testutils"
+)
+
+// fakeElasticsearch records the requests it receives and keeps the saved sync points in memory.
+type fakeElasticsearch struct {
+	templates  map[string]string
+	bulkLines  []string
+	syncPoints []string
+	syncExists bool
+	bulkErrors bool
+}
+
+func (f *fakeElasticsearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/_index_template/"):
+		f.templates[strings.TrimPrefix(r.URL.Path, "/_index_template/")] = string(body)
+		w.Write([]byte(`{"acknowledged":true}`))
+	case r.URL.Path == "/_bulk":
+		scanner := bufio.NewScanner(strings.NewReader(string(body)))
+		for scanner.Scan() {
+			f.bulkLines = append(f.bulkLines, scanner.Text())
+		}
+		if f.bulkErrors {
+			w.Write([]byte(`{"errors":true,"items":[{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}}]}`))
+			return
+		}
+		w.Write([]byte(`{"errors":false,"items":[]}`))
+	case r.URL.Path == "/fssync" && r.Method == http.MethodPut:
+		f.syncExists = true
+		w.Write([]byte(`{"acknowledged":true}`))
+	case r.URL.Path == "/fssync/_doc":
+		var doc map[string]interface{}
+		json.Unmarshal(body, &doc)
+		f.syncPoints = append(f.syncPoints, doc["BundleEndTime"].(string))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"result":"created"}`))
+	case r.URL.Path == "/fssync/_search":
+		if !f.syncExists {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"type":"index_not_found_exception"}}`))
+			return
+		}
+		if len(f.syncPoints) == 0 {
+			w.Write([]byte(`{"aggregations":{"last_sync":{"value":null}}}`))
+			return
+		}
+		// The fake only ever returns the latest sync point, which is enough for the test
+		last := f.syncPoints[len(f.syncPoints)-1]
+		w.Write([]byte(`{"aggregations":{"last_sync":{"value":1,"value_as_string":"` + last + `"}}}`))
+	default:
+		http.Error(w, "unexpected request "+r.URL.Path, http.StatusBadRequest)
+	}
+}
+
+func newTestElasticsearch(t *testing.T) (*Elasticsearch, *fakeElasticsearch, func()) {
+	fake := &fakeElasticsearch{templates: make(map[string]string)}
+	server := httptest.NewServer(fake)
+	es := NewElasticsearch(&config.ElasticsearchConfig{
+		URL:         server.URL,
+		IndexPrefix: "fsexport",
+		SyncIndex:   "fssync",
+		BatchSize:   2,
+	})
+	return es, fake, server.Close
+}
+
+func TestElasticsearchSyncPoints(t *testing.T) {
+	es, fake, cleanup := newTestElasticsearch(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	last, err := es.LastSyncPoint(ctx)
+	testutils.Assert(t, err == nil, "unexpected error: %s", err)
+	testutils.Assert(t, last.IsZero(), "expected zero time, got %s", last)
+	testutils.Assert(t, fake.syncExists, "expected sync index to be created")
+
+	last, err = es.LastSyncPoint(ctx)
+	testutils.Assert(t, err == nil && last.IsZero(), "expected zero time for empty sync index")
+
+	end := time.Date(2020, 8, 26, 2, 0, 0, 0, time.UTC)
+	testutils.Assert(t, es.SaveSyncPoint(ctx, end) == nil, "failed to save sync point")
+	last, err = es.LastSyncPoint(ctx)
+	testutils.Assert(t, err == nil, "unexpected error: %s", err)
+	testutils.Assert(t, last.Equal(end), "wrong sync point: %s", last)
+}
+
+func TestElasticsearchLoadToWarehouse(t *testing.T) {
+	csvFile, cleanup := writeTestFile(t, "bundle.csv", "UserId,EventStart,PageUrl,CustomVars\n"+
+		"123,2020-08-26T01:00:00Z,https://example.com,\"{\"\"acct_plan_str\"\":\"\"pro\"\"}\"\n"+
+		"456,2020-08-26T02:00:00Z,,{}\n"+
+		"789,2020-08-27T03:00:00Z,,{}\n")
+	defer cleanup()
+
+	es, fake, stop := newTestElasticsearch(t)
+	defer stop()
+
+	created, err := es.InitExportTable(context.Background(), MakeSchema(BaseExportFields{}))
+	testutils.Assert(t, err == nil && created, "failed to init: %s", err)
+	testutils.Assert(t, strings.Contains(fake.templates["fsexport"], `"index_patterns":["fsexport-*"]`), "missing index pattern")
+	testutils.Assert(t, strings.Contains(fake.templates["fsexport"], `"EventStart":{"type":"date"}`), "missing date mapping")
+
+	testutils.Assert(t, es.LoadToWarehouse(context.Background(), csvFile, time.Time{}) == nil, "failed to load")
+	testutils.Equals(t, 6, len(fake.bulkLines), "wrong number of bulk lines")
+
+	var action map[string]map[string]string
+	testutils.Assert(t, json.Unmarshal([]byte(fake.bulkLines[0]), &action) == nil, "bad action line")
+	testutils.Equals(t, "fsexport-2020.08.26", action["index"]["_index"], "wrong index")
+	testutils.Equals(t,
+		`{"CustomVars":{"acct_plan_str":"pro"},"EventStart":"2020-08-26T01:00:00Z","PageUrl":"https://example.com","UserId":123}`,
+		fake.bulkLines[1], "wrong document")
+	testutils.Assert(t, json.Unmarshal([]byte(fake.bulkLines[4]), &action) == nil, "bad action line")
+	testutils.Equals(t, "fsexport-2020.08.27", action["index"]["_index"], "wrong index")
+
+	// Loading the same file again must produce the same document IDs
+	firstID := action["index"]["_id"]
+	fake.bulkLines = nil
+	testutils.Assert(t, es.LoadToWarehouse(context.Background(), csvFile, time.Time{}) == nil, "failed to reload")
+	testutils.Assert(t, json.Unmarshal([]byte(fake.bulkLines[4]), &action) == nil, "bad action line")
+	testutils.Equals(t, firstID, action["index"]["_id"], "document id changed between loads")
+
+	fake.bulkErrors = true
+	err = es.LoadToWarehouse(context.Background(), csvFile, time.Time{})
+	testutils.Assert(t, err != nil && strings.Contains(err.Error(), "mapper_parsing_exception"), "expected bulk error, got %v", err)
+}
@@ -0,0 +1,189 @@
+package warehouse
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fullstorydev/hauser/config"
+	"github.com/segmentio/kafka-go"
+)
+
+const defaultKafkaBatchSize = 1000
+
+// messageWriter is the subset of kafka.Writer used to publish messages.
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Kafka publishes every export record as a JSON message to a topic. It has no tables, but implements
+// the DatabaseV2 interface so that it can be used like any other destination. Sync points are saved
+// to the provided storage, since there is no good place to keep them in Kafka.
+//
+// Bundles are written as newline-delimited JSON (see config.LoadFormat), and each line is published
+// as it is.
+type Kafka struct {
+	conf      *config.KafkaConfig
+	storage   Storage
+	schema    Schema
+	newWriter func() messageWriter
+}
+
+var _ DatabaseV2 = (*Kafka)(nil)
+var _ JSONCustomVarsDatabase = (*Kafka)(nil)
+
+func NewKafka(c *config.KafkaConfig, storage Storage) *Kafka {
+	k := &Kafka{
+		conf:    c,
+		storage: storage,
+	}
+	k.newWriter = func() messageWriter {
+		return &kafka.Writer{
+			Addr:         kafka.TCP(c.Brokers...),
+			Topic:        c.Topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			BatchSize:    k.batchSize(),
+			WriteTimeout: c.Timeout.Duration,
+		}
+	}
+	return k
+}
+
+func (k *Kafka) batchSize() int {
+	if k.conf.BatchSize > 0 {
+		return k.conf.BatchSize
+	}
+	return defaultKafkaBatchSize
+}
+
+func (k *Kafka) LastSyncPoint(ctx context.Context) (time.Time, error) {
+	return SyncViaStorageMixin{k.storage}.LastSyncPoint(ctx)
+}
+
+func (k *Kafka) SaveSyncPoint(ctx context.Context, endTime time.Time) error {
+	return SyncViaStorageMixin{k.storage}.SaveSyncPoint(ctx, endTime)
+}
+
+// InitExportTable keeps the schema to find the key of each message. There is no table to create, but
+// it reports that the table already exists, so that the schema is reconciled like for any other
+// database, e.g. to add the exploded custom var columns.
+func (k *Kafka) InitExportTable(_ context.Context, s Schema) (bool, error) {
+	k.schema = s
+	return false, nil
+}
+
+func (k *Kafka) ApplyExportSchema(_ context.Context, s Schema) error {
+	k.schema = s
+	return nil
+}
+
+func (k *Kafka) GetExportTableColumns(_ context.Context) ([]string, error) {
+	cols := make([]string, 0, len(k.schema))
+	for _, f := range k.schema {
+		cols = append(cols, f.DBName)
+	}
+	return cols, nil
+}
+
+// CustomVarsAsJSON publishes the custom vars as a nested object rather than an encoded string.
+func (k *Kafka) CustomVarsAsJSON() bool {
+	return true
+}
+
+// ValueToString keeps values intact since they are encoded as JSON.
+func (k *Kafka) ValueToString(val interface{}, isTime bool) string {
+	s := fmt.Sprintf("%v", val)
+	if isTime {
+		t, _ := time.Parse(time.RFC3339Nano, s)
+		return t.Format(RFC3339Micro)
+	}
+	return s
+}
+
+// LoadToWarehouse publishes one message per line of the local NDJSON file. It returns once every
+// message has been acknowledged by all in-sync replicas, so that the sync point is only saved
+// after the whole bundle has been delivered.
+func (k *Kafka) LoadToWarehouse(ctx context.Context, localFile string, _ time.Time) error {
+	f, err := os.Open(localFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := k.newWriter()
+	defer w.Close()
+
+	keyColumn := k.keyColumn()
+	in := bufio.NewReader(f)
+	batch := make([]kafka.Message, 0, k.batchSize())
+	var count int
+	for {
+		line, err := in.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read json record: %s", err)
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			msg, err := makeMessage(keyColumn, line)
+			if err != nil {
+				return err
+			}
+			batch = append(batch, msg)
+		}
+		if len(batch) == cap(batch) || (err == io.EOF && len(batch) > 0) {
+			if err := w.WriteMessages(ctx, batch...); err != nil {
+				return fmt.Errorf("failed to publish to %s: %s", k.conf.Topic, err)
+			}
+			count += len(batch)
+			batch = batch[:0]
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	log.Printf("Published %d messages to %s", count, k.conf.Topic)
+	return nil
+}
+
+// keyColumn returns the name of the column holding the message key, which may be configured
+// either by export field name or by column name.
+func (k *Kafka) keyColumn() string {
+	if k.conf.KeyField == "" {
+		return ""
+	}
+	for _, field := range k.schema {
+		if strings.EqualFold(field.FullStoryFieldName, k.conf.KeyField) || strings.EqualFold(field.DBName, k.conf.KeyField) {
+			return field.DBName
+		}
+	}
+	return ""
+}
+
+// makeMessage wraps a JSON record into a message, keyed by the value of keyColumn.
+func makeMessage(keyColumn string, line []byte) (kafka.Message, error) {
+	msg := kafka.Message{Value: line}
+	if keyColumn == "" {
+		return msg, nil
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(line, &obj); err != nil {
+		return kafka.Message{}, fmt.Errorf("failed to decode json record: %s", err)
+	}
+	if raw, ok := obj[keyColumn]; ok {
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			msg.Key = []byte(s)
+		} else {
+			msg.Key = raw
+		}
+	}
+	return msg, nil
+}
@@ -0,0 +1,111 @@
+package warehouse
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/hauser/config"
+	"github.com/fullstorydev/hauser/testing/testutils"
+	"github.com/segmentio/kafka-go"
+)
+
+type fakeWriter struct {
+	batches [][]kafka.Message
+	err     error
+	closed  bool
+}
+
+func (w *fakeWriter) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	if w.err != nil {
+		return w.err
+	}
+	batch := make([]kafka.Message, len(msgs))
+	copy(batch, msgs)
+	w.batches = append(w.batches, batch)
+	return nil
+}
+
+func (w *fakeWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func writeTestFile(t *testing.T, name, data string) (string, func()) {
+	dir, err := ioutil.TempDir("", "hauser-warehouse")
+	testutils.Assert(t, err == nil, "failed to create temp dir: %s", err)
+	filename := filepath.Join(dir, name)
+	testutils.Assert(t, ioutil.WriteFile(filename, []byte(data), 0666) == nil, "failed to write %s", name)
+	return filename, func() { os.RemoveAll(dir) }
+}
+
+func TestKafkaLoadToWarehouse(t *testing.T) {
+	jsonFile, cleanup := writeTestFile(t, "bundle.json",
+		`{"CustomVars":{"acct_plan_str":"pro"},"EventStart":"2020-08-26T01:00:00Z","PageDuration":42,"UserId":123}`+"\n"+
+			`{"CustomVars":{},"EventStart":"2020-08-26T02:00:00Z","UserId":456}`+"\n"+
+			"\n"+
+			`{"EventStart":"2020-08-26T03:00:00Z"}`)
+	defer cleanup()
+
+	w := &fakeWriter{}
+	k := NewKafka(&config.KafkaConfig{Topic: "fs", KeyField: "UserId", BatchSize: 2}, nil)
+	k.newWriter = func() messageWriter { return w }
+
+	created, err := k.InitExportTable(context.Background(), MakeSchema(BaseExportFields{}))
+	testutils.Assert(t, err == nil && !created, "failed to init")
+	testutils.Assert(t, k.LoadToWarehouse(context.Background(), jsonFile, time.Time{}) == nil, "failed to load")
+
+	testutils.Equals(t, 2, len(w.batches), "wrong number of batches")
+	testutils.Equals(t, 2, len(w.batches[0]), "wrong size of first batch")
+	testutils.Assert(t, w.closed, "expected writer to be closed")
+
+	first := w.batches[0][0]
+	testutils.Equals(t, "123", string(first.Key), "wrong key")
+	testutils.Equals(t,
+		`{"CustomVars":{"acct_plan_str":"pro"},"EventStart":"2020-08-26T01:00:00Z","PageDuration":42,"UserId":123}`,
+		string(first.Value), "wrong value")
+	testutils.Equals(t, `{"CustomVars":{},"EventStart":"2020-08-26T02:00:00Z","UserId":456}`, string(w.batches[0][1].Value), "wrong value")
+
+	// The blank line is skipped, and the last line is published even without a trailing newline
+	testutils.Equals(t, 1, len(w.batches[1]), "wrong size of last batch")
+	testutils.Equals(t, 0, len(w.batches[1][0].Key), "expected no key without a UserId")
+}
+
+func TestKafkaSyncPointWithPrefix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hauser-warehouse")
+	testutils.Assert(t, err == nil, "failed to create temp dir: %s", err)
+	defer os.RemoveAll(dir)
+
+	storage, err := NewLocalDisk(&config.LocalConfig{
+		StorageConfig: config.StorageConfig{FilePrefix: "hauser/"},
+		SaveDir:       dir,
+	})
+	testutils.Assert(t, err == nil, "failed to create storage: %s", err)
+	k := NewKafka(&config.KafkaConfig{Topic: "fs"}, storage)
+
+	ctx := context.Background()
+	last, err := k.LastSyncPoint(ctx)
+	testutils.Assert(t, err == nil && last.IsZero(), "expected no sync point, got %s (%v)", last, err)
+	end := time.Date(2020, 8, 26, 1, 0, 0, 0, time.UTC)
+	testutils.Assert(t, k.SaveSyncPoint(ctx, end) == nil, "failed to save sync point")
+	last, err = k.LastSyncPoint(ctx)
+	testutils.Assert(t, err == nil && last.Equal(end), "wrong sync point %s (%v)", last, err)
+	_, err = os.Stat(filepath.Join(dir, "hauser", ".sync.hauser"))
+	testutils.Assert(t, err == nil, "expected the sync point to be saved under the prefix: %s", err)
+}
+
+func TestKafkaLoadToWarehouseError(t *testing.T) {
+	jsonFile, cleanup := writeTestFile(t, "bundle.json", `{"UserId":123}`+"\n")
+	defer cleanup()
+
+	w := &fakeWriter{err: errors.New("not enough replicas")}
+	k := NewKafka(&config.KafkaConfig{Topic: "fs"}, nil)
+	k.newWriter = func() messageWriter { return w }
+	k.InitExportTable(context.Background(), MakeSchema(BaseExportFields{}))
+
+	testutils.Assert(t, k.LoadToWarehouse(context.Background(), jsonFile, time.Time{}) != nil, "expected error when messages aren't acknowledged")
+}
@@ -1,10 +1,11 @@
 package warehouse
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"time"
@@ -18,14 +19,14 @@ type LocalDisk struct {
 
 var _ Storage = (*LocalDisk)(nil)
 
-func NewLocalDisk(c *config.LocalConfig) *LocalDisk {
+// NewLocalDisk returns an error if the SaveDir doesn't exist.
+func NewLocalDisk(c *config.LocalConfig) (*LocalDisk, error) {
 	if _, err := os.Stat(c.SaveDir); os.IsNotExist(err) {
-		errorMessage := fmt.Sprintf("Cannot find folder %s, make sure it exists", c.SaveDir)
-		log.Fatalf(errorMessage)
+		return nil, fmt.Errorf("cannot find folder %s, make sure it exists", c.SaveDir)
 	}
 
 	if c.UseStartTime {
-		filename := filepath.Join(c.SaveDir, timestampFile)
+		filename := filepath.Join(c.SaveDir, c.FilePrefix, timestampFile)
 		if _, err := os.Stat(filename); !os.IsNotExist(err) {
 			os.Remove(filename)
 		}
@@ -33,7 +34,7 @@ func NewLocalDisk(c *config.LocalConfig) *LocalDisk {
 
 	return &LocalDisk{
 		conf: c,
-	}
+	}, nil
 }
 
 func (w *LocalDisk) LastSyncPoint(ctx context.Context) (time.Time, error) {
@@ -65,12 +66,15 @@ func (w *LocalDisk) DeleteFile(_ context.Context, name string) error {
 	return os.Remove(filename)
 }
 
+// ReadFile reads the whole file into memory, so that it doesn't have to be closed by the caller.
 func (w *LocalDisk) ReadFile(_ context.Context, name string) (io.Reader, error) {
-	filename := filepath.Join(w.conf.SaveDir, timestampFile)
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
+	b, err := ioutil.ReadFile(filepath.Join(w.conf.SaveDir, name))
+	if os.IsNotExist(err) {
 		return nil, ErrFileNotFound
+	} else if err != nil {
+		return nil, err
 	}
-	return os.Open(filename)
+	return bytes.NewReader(b), nil
 }
 
 func (w *LocalDisk) GetFileReference(name string) string {
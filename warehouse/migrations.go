@@ -0,0 +1,107 @@
+package warehouse
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Migration changes the type of an existing column of the export table to a wider type, so that it can
+// hold the values of its field without losing data.
+type Migration struct {
+	Column string
+	From   string
+	To     string
+	// Statements perform the migration. Columns that can't be altered in place are rewritten into a
+	// new column, which moves them to the end of the table.
+	Statements []string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%s: %s -> %s", m.Column, m.From, m.To)
+}
+
+// MigratingDatabase is implemented by databases that can migrate the types of the existing columns
+// of the export table.
+type MigratingDatabase interface {
+	// PlanMigrations returns the migrations needed for the existing columns to hold the values of the
+	// schema. Columns that already have a wider type, or whose type can't be changed safely, are skipped.
+	PlanMigrations(ctx context.Context, s Schema) ([]Migration, error)
+
+	// ApplyMigrations runs the migrations and records each of them in the migrations table, which is
+	// named after the export table with a "_migrations" suffix.
+	ApplyMigrations(ctx context.Context, migrations []Migration) error
+}
+
+// migrationsTable represents the fields of the table that records the applied migrations.
+type migrationsTable struct {
+	Applied    time.Time
+	ColumnName string
+	FromType   string
+	ToType     string
+}
+
+func migrationsTableName(exportTable string) string {
+	return exportTable + "_migrations"
+}
+
+// typeWidenings maps a column type to the types it can be changed to without losing data.
+// The type names use the spelling of the database's type map.
+type typeWidenings map[string][]string
+
+// canWiden returns true if the type can be changed. Types with a length, such as "VARCHAR(256)", use the
+// widenings of the base type if they don't have their own.
+func (w typeWidenings) canWiden(from, to string) bool {
+	from = strings.ToUpper(from)
+	targets, ok := w[from]
+	if i := strings.Index(from, "("); !ok && i > 0 {
+		targets = w[from[:i]]
+	}
+	for _, t := range targets {
+		if strings.EqualFold(t, to) {
+			return true
+		}
+	}
+	return false
+}
+
+// planMigrations compares the types of the existing columns, keyed by their lowercased names, with the
+// column definitions of the export fields. New columns are skipped since ApplyExportSchema adds them.
+func planMigrations(columns columnSchema, existing map[string]string, widenings typeWidenings, statements func(col columnConfig, from string) []string) []Migration {
+	var plan []Migration
+	for _, col := range columns {
+		from, ok := existing[strings.ToLower(col.DBName)]
+		if !ok || strings.EqualFold(from, col.DBType) {
+			continue
+		}
+		switch {
+		case widenings.canWiden(from, col.DBType):
+			plan = append(plan, Migration{
+				Column:     col.DBName,
+				From:       from,
+				To:         col.DBType,
+				Statements: statements(col, from),
+			})
+		case widenings.canWiden(col.DBType, from):
+			// The column can already hold the values
+		default:
+			log.Printf("Column %s has type %s instead of %s, which can't be migrated safely", col.DBName, from, col.DBType)
+		}
+	}
+	return plan
+}
+
+// exportColumns returns the column definitions of the fields that are part of the export. Columns
+// that aren't part of the export have no type to migrate to.
+func exportColumns(s Schema, typeMap map[reflect.Type]string, dbName string) columnSchema {
+	fields := make(Schema, 0, len(s))
+	for _, field := range s {
+		if field.FieldType != nil {
+			fields = append(fields, field)
+		}
+	}
+	return schemaToColumns(fields, typeMap, dbName)
+}
@@ -0,0 +1,75 @@
+package warehouse
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/fullstorydev/hauser/testing/testutils"
+)
+
+func TestCanWiden(t *testing.T) {
+	testCases := []struct {
+		from, to string
+		expected bool
+	}{
+		{"INTEGER", "BIGINT", true},
+		{"integer", "bigint", true},
+		{"BIGINT", "INTEGER", false},
+		{"REAL", "FLOAT", true},
+		{"VARCHAR(256)", "VARCHAR(max)", true},
+		{"VARCHAR(max)", "VARCHAR(256)", false},
+		{"BOOLEAN", "BIGINT", false},
+	}
+	for _, tc := range testCases {
+		testutils.Equals(t, tc.expected, redshiftWidenings.canWiden(tc.from, tc.to), "wrong result for %s -> %s", tc.from, tc.to)
+	}
+}
+
+func TestPlanMigrations(t *testing.T) {
+	columns := columnSchema{
+		{DBName: "Count", DBType: "BIGINT"},
+		{DBName: "Id", DBType: "INTEGER"},
+		{DBName: "Url", DBType: "VARCHAR(max)"},
+		{DBName: "Active", DBType: "BOOLEAN"},
+		{DBName: "NewColumn", DBType: "BIGINT"},
+		{DBName: "Score", DBType: "FLOAT"},
+	}
+	existing := map[string]string{
+		"count":  "INTEGER",
+		"id":     "BIGINT",
+		"url":    "VARCHAR(256)",
+		"active": "VARCHAR(max)",
+		"score":  "FLOAT",
+	}
+	wh := &Redshift{conf: makeConf("some_schema")}
+	plan := planMigrations(columns, existing, redshiftWidenings, wh.migrationStatements)
+
+	testutils.Equals(t, 2, len(plan), "wrong number of migrations: %v", plan)
+	testutils.Equals(t, "Count: INTEGER -> BIGINT", plan[0].String(), "wrong migration")
+	testutils.StrSliceEquals(t, []string{
+		"ALTER TABLE some_schema.exportTable ADD COLUMN Count_migrated BIGINT;",
+		"UPDATE some_schema.exportTable SET Count_migrated = Count;",
+		"ALTER TABLE some_schema.exportTable DROP COLUMN Count;",
+		"ALTER TABLE some_schema.exportTable RENAME COLUMN Count_migrated TO Count;",
+	}, plan[0].Statements, "wrong rewrite statements")
+	testutils.Equals(t, "Url: VARCHAR(256) -> VARCHAR(max)", plan[1].String(), "wrong migration")
+	testutils.StrSliceEquals(t, []string{
+		"ALTER TABLE some_schema.exportTable ALTER COLUMN Url TYPE VARCHAR(max);",
+	}, plan[1].Statements, "wrong alter statement")
+}
+
+func TestRedshiftTypeName(t *testing.T) {
+	testCases := []struct {
+		dataType string
+		length   sql.NullInt64
+		expected string
+	}{
+		{"bigint", sql.NullInt64{}, "BIGINT"},
+		{"double precision", sql.NullInt64{}, "FLOAT"},
+		{"character varying", sql.NullInt64{Int64: 256, Valid: true}, "VARCHAR(256)"},
+		{"character varying", sql.NullInt64{Int64: redshiftVarCharMax, Valid: true}, "VARCHAR(max)"},
+	}
+	for _, tc := range testCases {
+		testutils.Equals(t, tc.expected, redshiftTypeName(tc.dataType, tc.length), "wrong type name for %s", tc.dataType)
+	}
+}
@@ -0,0 +1,451 @@
+package warehouse
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fullstorydev/hauser/config"
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	// mysqlTimeFormat matches the DATETIME(6) column type, which doesn't accept a time zone suffix.
+	// All times are stored in UTC.
+	mysqlTimeFormat = "2006-01-02 15:04:05.999999"
+
+	// mysqlTextMax is the maximum number of bytes that fit in a TEXT column.
+	mysqlTextMax = 65535
+
+	// Custom vars can grow beyond the size of TEXT, so they are stored as LONGTEXT.
+	mysqlCustomVarsType = "LONGTEXT"
+)
+
+type MySQL struct {
+	conn       *sql.DB
+	conf       *config.MySQLConfig
+	syncSchema Schema
+	// schema is the last export schema that was applied, used to find the EventStart column
+	schema Schema
+}
+
+var (
+	mysqlSchemaMap = map[reflect.Type]string{
+		reflect.TypeOf(int64(0)):    "BIGINT",
+		reflect.TypeOf(""):          "TEXT",
+		reflect.TypeOf(time.Time{}): "DATETIME(6)",
+		reflect.TypeOf(float64(0)):  "DOUBLE",
+		reflect.TypeOf(int32(0)):    "INT",
+		reflect.TypeOf(false):       "BOOLEAN",
+	}
+
+	mysqlWidenings = typeWidenings{
+		"SMALLINT":   {"INT", "BIGINT", "DOUBLE"},
+		"MEDIUMINT":  {"INT", "BIGINT", "DOUBLE"},
+		"INT":        {"BIGINT", "DOUBLE"},
+		"FLOAT":      {"DOUBLE"},
+		"VARCHAR":    {"TEXT", "MEDIUMTEXT", "LONGTEXT"},
+		"TEXT":       {"MEDIUMTEXT", "LONGTEXT"},
+		"MEDIUMTEXT": {"LONGTEXT"},
+	}
+
+	// mysqlTypeNames maps the data types of information_schema to the names used in the schema map.
+	// The precision of DATETIME columns isn't migrated.
+	mysqlTypeNames = map[string]string{
+		"datetime": "DATETIME(6)",
+		"tinyint":  "BOOLEAN",
+	}
+)
+
+var _ DatabaseV2 = (*MySQL)(nil)
+var _ MigratingDatabase = (*MySQL)(nil)
+
+func NewMySQL(c *config.MySQLConfig) *MySQL {
+	return &MySQL{
+		conf:       c,
+		syncSchema: MakeSchema(syncTable{}),
+	}
+}
+
+func toMySQLColumns(columns []columnConfig) columnSchema {
+	for i := range columns {
+		if columns[i].FullStoryFieldName == "CustomVars" {
+			columns[i].DBType = mysqlCustomVarsType
+		}
+	}
+	return columns
+}
+
+func (my *MySQL) MakeMySQLConnection() (*sql.DB, error) {
+	cfg := mysql.NewConfig()
+	cfg.User = my.conf.User
+	cfg.Passwd = my.conf.Password
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(my.conf.Host, my.conf.Port)
+	cfg.DBName = my.conf.DB
+	cfg.ParseTime = true
+	cfg.Loc = time.UTC
+
+	var err error
+	var db *sql.DB
+	if db, err = sql.Open("mysql", cfg.FormatDSN()); err != nil {
+		return nil, fmt.Errorf("mysql connect error : (%v)", err)
+	}
+
+	if err = db.Ping(); err != nil {
+		return nil, fmt.Errorf("mysql ping error : (%v)", err)
+	}
+	return db, nil
+}
+
+// GetExportTableColumns returns all the columns of the export table.
+func (my *MySQL) GetExportTableColumns(_ context.Context) ([]string, error) {
+	var err error
+	my.conn, err = my.MakeMySQLConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer my.conn.Close()
+
+	return my.getTableColumns(my.conf.ExportTable)
+}
+
+// ValueToString formats times for DATETIME(6) columns and truncates strings to fit in a TEXT column.
+func (my *MySQL) ValueToString(val interface{}, isTime bool) string {
+	if b, ok := val.(bool); ok {
+		// BOOLEAN is an alias for TINYINT(1), which doesn't accept "true" and "false"
+		return boolToInt(b)
+	}
+	s := fmt.Sprintf("%v", val)
+	if isTime {
+		t, _ := time.Parse(time.RFC3339Nano, s)
+		return t.UTC().Format(mysqlTimeFormat)
+	}
+
+	s = strings.Replace(s, "\n", " ", -1)
+	s = strings.Replace(s, "\r", " ", -1)
+	s = strings.Replace(s, "\x00", "", -1)
+
+	if len(s) > mysqlTextMax {
+		// Don't cut a multi-byte character in half
+		end := mysqlTextMax
+		for end > 0 && !utf8.RuneStart(s[end]) {
+			end--
+		}
+		s = s[:end]
+	}
+	return s
+}
+
+// LoadToWarehouse bulk loads the local CSV file into the export table with LOAD DATA LOCAL INFILE.
+func (my *MySQL) LoadToWarehouse(ctx context.Context, localFile string, _ time.Time) error {
+	absPath, err := filepath.Abs(localFile)
+	if err != nil {
+		return err
+	}
+	headers, err := readCSVHeader(absPath)
+	if err != nil {
+		return err
+	}
+
+	my.conn, err = my.MakeMySQLConnection()
+	if err != nil {
+		return err
+	}
+	defer my.conn.Close()
+
+	f, err := os.Open(absPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The file is sent through a registered reader rather than by path, so that the path never has to be
+	// quoted inside the statement.
+	name := fmt.Sprintf("hauser_%d", atomic.AddUint64(&mysqlReaderCount, 1))
+	mysql.RegisterReaderHandler(name, func() io.Reader { return f })
+	defer mysql.DeregisterReaderHandler(name)
+
+	_, err = my.conn.ExecContext(ctx, my.loadDataStatement("Reader::"+name, headers))
+	return err
+}
+
+// mysqlReaderCount keeps the reader handler names unique when several loads run at once.
+var mysqlReaderCount uint64
+
+// loadDataStatement builds the LOAD DATA statement for a CSV file with the provided header. Each value is
+// read into a variable first so that empty values can be converted to NULL, which non-text columns require.
+func (my *MySQL) loadDataStatement(file string, headers []string) string {
+	vars := make([]string, len(headers))
+	sets := make([]string, len(headers))
+	for i, h := range headers {
+		vars[i] = fmt.Sprintf("@v%d", i)
+		sets[i] = fmt.Sprintf("%s = NULLIF(@v%d, '')", h, i)
+	}
+	return fmt.Sprintf("LOAD DATA LOCAL INFILE '%s' INTO TABLE %s CHARACTER SET utf8mb4 "+
+		"FIELDS TERMINATED BY ',' OPTIONALLY ENCLOSED BY '\"' ESCAPED BY '' LINES TERMINATED BY '\\n' IGNORE 1 LINES (%s) SET %s;",
+		file, my.conf.ExportTable, strings.Join(vars, ","), strings.Join(sets, ", "))
+}
+
+func readCSVHeader(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	headers, err := csv.NewReader(f).Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv header: %s", err)
+	}
+	return headers, nil
+}
+
+func (my *MySQL) InitExportTable(_ context.Context, schema Schema) (bool, error) {
+	my.schema = schema
+	var err error
+	my.conn, err = my.MakeMySQLConnection()
+	if err != nil {
+		return false, err
+	}
+	defer my.conn.Close()
+
+	exists, err := my.DoesTableExist(my.conf.ExportTable)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		log.Printf("Export table %s does not exist! Creating one!", my.conf.ExportTable)
+		if err = my.createExportTable(schema); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func (my *MySQL) ApplyExportSchema(ctx context.Context, newSchema Schema) error {
+	my.schema = newSchema
+	var err error
+	my.conn, err = my.MakeMySQLConnection()
+	if err != nil {
+		return err
+	}
+	defer my.conn.Close()
+
+	existingColumns, err := my.getTableColumns(my.conf.ExportTable)
+	if err != nil {
+		return err
+	}
+	missingFields, err := getColumnsToAdd(newSchema, existingColumns, mysqlSchemaMap)
+	if err != nil {
+		return err
+	}
+	if len(missingFields) > 0 {
+		log.Printf("Found %d missing fields. Adding columns for these fields.", len(missingFields))
+		adds := make([]string, len(missingFields))
+		for i, f := range toMySQLColumns(missingFields) {
+			adds[i] = fmt.Sprintf("ADD COLUMN %s %s", f.DBName, f.DBType)
+		}
+		alterStmt := fmt.Sprintf("ALTER TABLE %s %s;", my.conf.ExportTable, strings.Join(adds, ", "))
+		if _, err = my.conn.ExecContext(ctx, alterStmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (my *MySQL) createExportTable(schema Schema) error {
+	log.Printf("Creating table %s", my.conf.ExportTable)
+
+	columns := toMySQLColumns(schemaToColumns(schema, mysqlSchemaMap, "mysql"))
+	stmt := fmt.Sprintf("CREATE TABLE %s(%s) CHARACTER SET utf8mb4;", my.conf.ExportTable, columns)
+	_, err := my.conn.Exec(stmt)
+	return err
+}
+
+// CreateSyncTable creates a sync table with the hauser sync table schema
+func (my *MySQL) CreateSyncTable() error {
+	log.Printf("Creating table %s", my.conf.SyncTable)
+
+	stmt := fmt.Sprintf("CREATE TABLE %s(%s);", my.conf.SyncTable, schemaToColumns(my.syncSchema, mysqlSchemaMap, "mysql"))
+	_, err := my.conn.Exec(stmt)
+	return err
+}
+
+func (my *MySQL) SaveSyncPoint(_ context.Context, endTime time.Time) error {
+	var err error
+	my.conn, err = my.MakeMySQLConnection()
+	if err != nil {
+		log.Printf("Couldn't connect to DB: %s", err)
+		return err
+	}
+	defer my.conn.Close()
+
+	insert := fmt.Sprintf("INSERT INTO %s VALUES (?, ?, ?);", my.conf.SyncTable)
+	_, err = my.conn.Exec(insert, -1, time.Now().UTC(), endTime.UTC())
+	return err
+}
+
+func (my *MySQL) LastSyncPoint(_ context.Context) (time.Time, error) {
+	t := time.Time{}
+	var err error
+	my.conn, err = my.MakeMySQLConnection()
+	if err != nil {
+		log.Printf("Couldn't connect to DB: %s", err)
+		return t, err
+	}
+	defer my.conn.Close()
+
+	exists, err := my.DoesTableExist(my.conf.SyncTable)
+	if err != nil {
+		return t, err
+	}
+	if !exists {
+		if err := my.CreateSyncTable(); err != nil {
+			log.Printf("Couldn't create sync table: %s", err)
+			return t, err
+		}
+		return t, nil
+	}
+
+	var syncTime sql.NullTime
+	q := fmt.Sprintf("SELECT max(BundleEndTime) FROM %s;", my.conf.SyncTable)
+	if err := my.conn.QueryRow(q).Scan(&syncTime); err != nil {
+		log.Printf("Couldn't get max(BundleEndTime): %s", err)
+		return t, err
+	}
+	if syncTime.Valid {
+		t = syncTime.Time
+	}
+
+	if err := my.RemoveOrphanedRecords(syncTime); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// RemoveOrphanedRecords deletes export records that were loaded after the last sync point, which
+// happens when hauser stops after loading a file but before saving the sync point.
+func (my *MySQL) RemoveOrphanedRecords(lastSync sql.NullTime) error {
+	exists, err := my.DoesTableExist(my.conf.ExportTable)
+	if err != nil || !exists {
+		// A missing table is okay, because the hauser process will ensure that the table exists.
+		return err
+	}
+
+	var exportTime sql.NullTime
+	q := fmt.Sprintf("SELECT max(%s) FROM %s;", my.schema.ColumnFor("EventStart"), my.conf.ExportTable)
+	if err := my.conn.QueryRow(q).Scan(&exportTime); err != nil {
+		log.Printf("Couldn't get max(%s): %s", my.schema.ColumnFor("EventStart"), err)
+		return err
+	}
+	if exportTime.Valid && exportTime.Time.After(lastSync.Time) {
+		log.Printf("Export record timestamp after sync time (%s vs %s); cleaning",
+			exportTime.Time, lastSync.Time)
+		stmt := fmt.Sprintf("DELETE FROM %s WHERE %s > ?;", my.conf.ExportTable, my.schema.ColumnFor("EventStart"))
+		if _, err := my.conn.Exec(stmt, lastSync.Time.UTC()); err != nil {
+			log.Printf("failed to delete from %s: %s", my.conf.ExportTable, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// DoesTableExist checks if a table with a given name exists in the configured database
+func (my *MySQL) DoesTableExist(name string) (bool, error) {
+	log.Printf("Checking if table %s exists", name)
+
+	var exists int
+	query := "SELECT count(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?;"
+	if err := my.conn.QueryRow(query, name).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists != 0, nil
+}
+
+func (my *MySQL) getTableColumns(name string) ([]string, error) {
+	log.Printf("Fetching columns for table %s", name)
+	query := "SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? ORDER BY ordinal_position;"
+	rows, err := my.conn.Query(query, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+// PlanMigrations returns the migrations for the existing columns that have a narrower type than the schema.
+func (my *MySQL) PlanMigrations(_ context.Context, s Schema) ([]Migration, error) {
+	var err error
+	my.conn, err = my.MakeMySQLConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer my.conn.Close()
+
+	existing, err := my.getColumnTypes(my.conf.ExportTable)
+	if err != nil {
+		return nil, err
+	}
+	columns := toMySQLColumns(exportColumns(s, mysqlSchemaMap, "mysql"))
+	return planMigrations(columns, existing, mysqlWidenings, func(col columnConfig, _ string) []string {
+		return []string{fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s;", my.conf.ExportTable, col.DBName, col.DBType)}
+	}), nil
+}
+
+// ApplyMigrations runs the migrations. MySQL commits each schema change on its own, so a migration that
+// fails leaves the earlier ones in place.
+func (my *MySQL) ApplyMigrations(ctx context.Context, migrations []Migration) error {
+	var err error
+	my.conn, err = my.MakeMySQLConnection()
+	if err != nil {
+		return err
+	}
+	defer my.conn.Close()
+
+	return applySQLMigrations(ctx, my.conn, migrations, migrationsTableName(my.conf.ExportTable), mysqlSchemaMap, "?, ?, ?, ?")
+}
+
+// getColumnTypes returns the types of the columns of the table, keyed by their lowercased names.
+func (my *MySQL) getColumnTypes(name string) (map[string]string, error) {
+	query := "SELECT column_name, data_type FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ?;"
+	rows, err := my.conn.Query(query, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types := make(map[string]string)
+	for rows.Next() {
+		var column, dataType string
+		if err := rows.Scan(&column, &dataType); err != nil {
+			return nil, err
+		}
+		if name, ok := mysqlTypeNames[strings.ToLower(dataType)]; ok {
+			dataType = name
+		}
+		types[strings.ToLower(column)] = strings.ToUpper(dataType)
+	}
+	return types, rows.Err()
+}
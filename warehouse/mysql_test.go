@@ -0,0 +1,58 @@
+package warehouse
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fullstorydev/hauser/config"
+	"github.com/fullstorydev/hauser/testing/testutils"
+)
+
+func TestMySQLSchema(t *testing.T) {
+	fs := MakeSchema(BaseExportFields{}, MobileFields{}, syncTable{})
+	for _, field := range fs {
+		_, ok := mysqlSchemaMap[field.FieldType]
+		testutils.Assert(t, ok, "field type %v not found in mysqlSchemaMap", field.FieldType)
+	}
+
+	columns := toMySQLColumns(schemaToColumns(MakeSchema(struct {
+		PageUrl    string
+		CustomVars string
+	}{}), mysqlSchemaMap, "mysql"))
+	testutils.Equals(t, "PageUrl TEXT,CustomVars LONGTEXT", columns.String(), "wrong columns")
+}
+
+func TestMySQLValueToString(t *testing.T) {
+	wh := NewMySQL(&config.MySQLConfig{})
+
+	var testCases = []struct {
+		input    interface{}
+		isTime   bool
+		expected string
+	}{
+		{"short string", false, "short string"},
+		{"no\nnew\nlines", false, "no new lines"},
+		{"no\x00null\x00chars", false, "nonullchars"},
+		{5, false, "5"},
+		{"2009-11-10T23:00:00.000Z", true, "2009-11-10 23:00:00"},
+		{"2009-11-10T23:00:00.123456789Z", true, "2009-11-10 23:00:00.123456"},
+		{"2009-11-10T18:00:00.5-05:00", true, "2009-11-10 23:00:00.5"},
+		{strings.Repeat("a", mysqlTextMax+10), false, strings.Repeat("a", mysqlTextMax)},
+		{strings.Repeat("a", mysqlTextMax-1) + "é", false, strings.Repeat("a", mysqlTextMax-1)},
+	}
+
+	for _, testCase := range testCases {
+		if got := wh.ValueToString(testCase.input, testCase.isTime); got != testCase.expected {
+			t.Errorf("Expected value %q, got %q", testCase.expected, got)
+		}
+	}
+}
+
+func TestMySQLLoadDataStatement(t *testing.T) {
+	wh := NewMySQL(&config.MySQLConfig{ExportTable: "fsexport"})
+	expected := `LOAD DATA LOCAL INFILE 'Reader::hauser_1' INTO TABLE fsexport CHARACTER SET utf8mb4 ` +
+		`FIELDS TERMINATED BY ',' OPTIONALLY ENCLOSED BY '"' ESCAPED BY '' LINES TERMINATED BY '\n' IGNORE 1 LINES ` +
+		`(@v0,@v1) SET EventStart = NULLIF(@v0, ''), PageDuration = NULLIF(@v1, '');`
+	got := wh.loadDataStatement("Reader::hauser_1", []string{"EventStart", "PageDuration"})
+	testutils.Equals(t, expected, got, "wrong load statement")
+}
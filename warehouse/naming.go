@@ -0,0 +1,74 @@
+package warehouse
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/fullstorydev/hauser/config"
+)
+
+// ColumnNamer converts export field names to column names, following the ColumnNaming and
+// ColumnPrefix options.
+type ColumnNamer struct {
+	Naming config.ColumnNaming
+	Prefix string
+}
+
+// ColumnName returns the column name for an export field.
+func (n ColumnNamer) ColumnName(name string) string {
+	switch n.Naming {
+	case config.SnakeCaseNaming:
+		name = toSnakeCase(name)
+	case config.LowercaseNaming:
+		name = strings.ToLower(name)
+	}
+	return n.Prefix + name
+}
+
+// WithColumnNames returns the schema with the column names converted. Fields whose column was renamed,
+// such as in a schema file, keep their column names.
+func (s Schema) WithColumnNames(n ColumnNamer) Schema {
+	newSchema := make(Schema, len(s))
+	for i, field := range s {
+		newSchema[i] = field
+		if field.FullStoryFieldName != "" && field.DBName == field.FullStoryFieldName {
+			newSchema[i].DBName = n.ColumnName(field.DBName)
+		}
+	}
+	return newSchema
+}
+
+// toSnakeCase converts a name such as "EventTargetSelector" or "HTTPStatus" to "event_target_selector"
+// or "http_status". Names that are already snake case only have their letters lowercased.
+func toSnakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 && runes[i-1] != '_' {
+			prevLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// normalizeColumnName lowercases a column name and removes its underscores, so that the same field
+// matches in any of the naming conventions.
+func normalizeColumnName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
+
+// findExportColumn returns the column of an existing table that holds the export field, or an empty
+// string if there is none. The column can be named after any of the column naming conventions.
+func findExportColumn(s Schema, cols []string, fsName string) string {
+	for _, col := range cols {
+		if s.GetFieldForName(col).FullStoryFieldName == fsName {
+			return col
+		}
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+package warehouse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/hauser/config"
+	"github.com/fullstorydev/hauser/testing/testutils"
+)
+
+func TestColumnName(t *testing.T) {
+	testCases := []struct {
+		namer    ColumnNamer
+		name     string
+		expected string
+	}{
+		{ColumnNamer{}, "EventTargetSelector", "EventTargetSelector"},
+		{ColumnNamer{Naming: config.SnakeCaseNaming}, "EventTargetSelector", "event_target_selector"},
+		{ColumnNamer{Naming: config.SnakeCaseNaming}, "IndvId", "indv_id"},
+		{ColumnNamer{Naming: config.SnakeCaseNaming}, "HTTPStatus", "http_status"},
+		{ColumnNamer{Naming: config.SnakeCaseNaming}, "PageNum2Errors", "page_num2_errors"},
+		{ColumnNamer{Naming: config.SnakeCaseNaming}, "user_Plan_str", "user_plan_str"},
+		{ColumnNamer{Naming: config.LowercaseNaming}, "EventTargetSelector", "eventtargetselector"},
+		{ColumnNamer{Naming: config.SnakeCaseNaming, Prefix: "fs_"}, "PageUrl", "fs_page_url"},
+		{ColumnNamer{Prefix: "fs_"}, "PageUrl", "fs_PageUrl"},
+	}
+	for _, tc := range testCases {
+		testutils.Equals(t, tc.expected, tc.namer.ColumnName(tc.name), "wrong column name for %s", tc.name)
+	}
+}
+
+func TestWithColumnNames(t *testing.T) {
+	schema := Schema{
+		{DBName: "EventStart", FullStoryFieldName: "EventStart", FieldType: timeType},
+		{DBName: "PageAgent", FullStoryFieldName: "PageUserAgent", FieldType: stringType},
+		{DBName: "ExtraColumn"},
+	}
+	named := schema.WithColumnNames(ColumnNamer{Naming: config.SnakeCaseNaming})
+	testutils.Equals(t, "event_start", named[0].DBName, "field not renamed")
+	testutils.Equals(t, "PageAgent", named[1].DBName, "renamed column changed")
+	testutils.Equals(t, "ExtraColumn", named[2].DBName, "extra column changed")
+	testutils.Equals(t, "EventStart", schema[0].DBName, "original schema modified")
+}
+
+func TestReconcileWithOtherNaming(t *testing.T) {
+	schema := MakeSchema(struct {
+		EventStart time.Time
+		PageUrl    string
+		CustomVars string
+	}{}).WithColumnNames(ColumnNamer{Naming: config.SnakeCaseNaming})
+
+	// A table created before the naming was set keeps its columns
+	reconciled := schema.ReconcileWithExisting([]string{"EventStart", "PageUrl"})
+	expected := Schema{
+		{DBName: "EventStart", FullStoryFieldName: "EventStart", FieldType: timeType},
+		{DBName: "PageUrl", FullStoryFieldName: "PageUrl", FieldType: stringType},
+		{DBName: "custom_vars", FullStoryFieldName: "CustomVars", FieldType: stringType},
+	}
+	testutils.Assert(t, reconciled.Equals(expected), "wrong schema: %v", reconciled)
+	testutils.Equals(t, "EventStart", reconciled.ColumnFor("EventStart"), "wrong EventStart column")
+
+	cols := []string{"eventstart", "page_url", "custom_vars"}
+	testutils.Equals(t, "custom_vars", findExportColumn(schema, cols, "CustomVars"), "CustomVars column not found")
+	testutils.Equals(t, "", findExportColumn(schema, cols[:2], "CustomVars"), "unexpected CustomVars column")
+}
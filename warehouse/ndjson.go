@@ -0,0 +1,45 @@
+package warehouse
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// NDJSONWriter writes export records as newline-delimited JSON, with one object per record keyed by
+// column name. Values keep their JSON types, and aren't altered to fit into a CSV file.
+type NDJSONWriter struct {
+	fields []WarehouseField
+	enc    *json.Encoder
+	// CustomVarsAsObject writes the custom vars as a JSON object. By default they are kept encoded,
+	// for databases that store them in a string column.
+	CustomVarsAsObject bool
+}
+
+func NewNDJSONWriter(w io.Writer, s Schema) *NDJSONWriter {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &NDJSONWriter{
+		fields: s,
+		enc:    enc,
+	}
+}
+
+// Write adds a single record. Empty values are omitted, so they are loaded as nulls.
+func (n *NDJSONWriter) Write(record []string) error {
+	if len(record) != len(n.fields) {
+		return fmt.Errorf("record has %d values, expected %d", len(record), len(n.fields))
+	}
+	obj := make(map[string]interface{}, len(record))
+	for i, val := range record {
+		if val == "" {
+			continue
+		}
+		if n.fields[i].FullStoryFieldName == "CustomVars" && !n.CustomVarsAsObject {
+			obj[n.fields[i].DBName] = val
+			continue
+		}
+		obj[n.fields[i].DBName] = typedValue(n.fields[i], val)
+	}
+	return n.enc.Encode(obj)
+}
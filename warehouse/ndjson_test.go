@@ -0,0 +1,45 @@
+package warehouse
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/hauser/testing/testutils"
+)
+
+func TestNDJSONWriter(t *testing.T) {
+	schema := Schema{
+		{DBName: "UserId", FullStoryFieldName: "UserId", FieldType: reflect.TypeOf(int64(0))},
+		{DBName: "EventStart", FullStoryFieldName: "EventStart", FieldType: reflect.TypeOf(time.Time{})},
+		{DBName: "EventCumulativeLayoutShift", FullStoryFieldName: "EventCumulativeLayoutShift", FieldType: reflect.TypeOf(float64(0))},
+		{DBName: "PageUrl", FullStoryFieldName: "PageUrl", FieldType: reflect.TypeOf("")},
+		{DBName: "CustomVars", FullStoryFieldName: "CustomVars", FieldType: reflect.TypeOf("")},
+		{DBName: "CustomColumn"},
+	}
+
+	var buf bytes.Buffer
+	nw := NewNDJSONWriter(&buf, schema)
+	testutils.Assert(t, nw.Write([]string{"123", "2020-08-26T01:00:00.123456Z", "0.5", "https://example.com/?a=1&b=2", `{"nested":{"a":[1,2]}}`, ""}) == nil, "failed to write")
+	testutils.Assert(t, nw.Write([]string{"456", "2020-08-26T02:00:00Z", "", "line\nbreak", "{}", ""}) == nil, "failed to write")
+	testutils.Assert(t, nw.Write([]string{"1"}) != nil, "expected error for short record")
+
+	expected := `{"CustomVars":"{\"nested\":{\"a\":[1,2]}}","EventCumulativeLayoutShift":0.5,"EventStart":"2020-08-26T01:00:00.123456Z","PageUrl":"https://example.com/?a=1&b=2","UserId":123}
+{"CustomVars":"{}","EventStart":"2020-08-26T02:00:00Z","PageUrl":"line\nbreak","UserId":456}
+`
+	testutils.Equals(t, expected, buf.String(), "wrong output")
+}
+
+func TestNDJSONWriterCustomVarsAsObject(t *testing.T) {
+	schema := Schema{
+		{DBName: "UserId", FullStoryFieldName: "UserId", FieldType: reflect.TypeOf(int64(0))},
+		{DBName: "CustomVars", FullStoryFieldName: "CustomVars", FieldType: reflect.TypeOf("")},
+	}
+
+	var buf bytes.Buffer
+	nw := NewNDJSONWriter(&buf, schema)
+	nw.CustomVarsAsObject = true
+	testutils.Assert(t, nw.Write([]string{"123", `{"user_plan_str":"pro"}`}) == nil, "failed to write")
+	testutils.Equals(t, `{"CustomVars":{"user_plan_str":"pro"},"UserId":123}`+"\n", buf.String(), "wrong output")
+}
@@ -0,0 +1,76 @@
+package warehouse
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/xitongsys/parquet-go-source/writer"
+	parquetwriter "github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetTypeMap maps the schema types to Parquet column types. Times are stored as microseconds
+// since the epoch, which is the precision of the export and is understood by BigQuery and Redshift.
+var parquetTypeMap = map[reflect.Type]string{
+	reflect.TypeOf(int64(0)):    "INT64",
+	reflect.TypeOf(""):          "UTF8",
+	reflect.TypeOf(time.Time{}): "TIMESTAMP_MICROS",
+	reflect.TypeOf(float64(0)):  "DOUBLE",
+	reflect.TypeOf(int32(0)):    "INT32",
+	reflect.TypeOf(false):       "BOOLEAN",
+}
+
+// ParquetWriter writes export records to a Parquet file, with a typed column for every schema field.
+// Like csv.Writer, records are provided as strings; they are converted using the field types.
+// Custom vars are stored as a JSON string.
+type ParquetWriter struct {
+	fields []WarehouseField
+	pw     *parquetwriter.CSVWriter
+}
+
+func NewParquetWriter(w io.Writer, s Schema) (*ParquetWriter, error) {
+	md := make([]string, len(s))
+	for i, field := range s {
+		// Columns that aren't part of the export have no type, but they are always empty
+		pqType := "UTF8"
+		if field.FieldType != nil {
+			var ok bool
+			if pqType, ok = parquetTypeMap[field.FieldType]; !ok {
+				return nil, fmt.Errorf("parquet type not found for schema type %s", field.FieldType)
+			}
+		}
+		md[i] = fmt.Sprintf("name=%s, type=%s", field.DBName, pqType)
+	}
+
+	pw, err := parquetwriter.NewCSVWriter(md, writer.NewWriterFile(w), 1)
+	if err != nil {
+		return nil, err
+	}
+	return &ParquetWriter{
+		fields: s,
+		pw:     pw,
+	}, nil
+}
+
+// Write adds a single record. Empty values, and values that don't match the type of their column,
+// are written as nulls.
+func (p *ParquetWriter) Write(record []string) error {
+	if len(record) != len(p.fields) {
+		return fmt.Errorf("record has %d values, expected %d", len(record), len(p.fields))
+	}
+	row := make([]interface{}, len(record))
+	for i, val := range record {
+		v := parseValueOrNull(p.fields[i], val)
+		if t, ok := v.(time.Time); ok {
+			v = t.UnixNano() / int64(time.Microsecond)
+		}
+		row[i] = v
+	}
+	return p.pw.Write(row)
+}
+
+// Close writes the remaining rows and the file footer. It doesn't close the underlying writer.
+func (p *ParquetWriter) Close() error {
+	return p.pw.WriteStop()
+}
@@ -0,0 +1,57 @@
+package warehouse
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/hauser/testing/testutils"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func TestParquetWriter(t *testing.T) {
+	schema := Schema{
+		{DBName: "UserId", FullStoryFieldName: "UserId", FieldType: reflect.TypeOf(int64(0))},
+		{DBName: "EventStart", FullStoryFieldName: "EventStart", FieldType: reflect.TypeOf(time.Time{})},
+		{DBName: "PageNumEvents", FullStoryFieldName: "PageNumEvents", FieldType: reflect.TypeOf(int32(0))},
+		{DBName: "EventCumulativeLayoutShift", FullStoryFieldName: "EventCumulativeLayoutShift", FieldType: reflect.TypeOf(float64(0))},
+		{DBName: "CustomVars", FullStoryFieldName: "CustomVars", FieldType: reflect.TypeOf("")},
+		{DBName: "CustomColumn"},
+	}
+
+	var buf bytes.Buffer
+	pw, err := NewParquetWriter(&buf, schema)
+	testutils.Assert(t, err == nil, "failed to create writer: %s", err)
+	testutils.Assert(t, pw.Write([]string{"123", "2020-08-26T01:00:00.123456Z", "7", "0.5", `{"a":"b"}`, ""}) == nil, "failed to write")
+	testutils.Assert(t, pw.Write([]string{"456", "2020-08-26T02:00:00Z", "", "", "{}", ""}) == nil, "failed to write")
+	testutils.Assert(t, pw.Write([]string{"abc", "2020-08-26T03:00:00Z", "x", "", "", ""}) == nil, "failed to write invalid values")
+	testutils.Assert(t, pw.Write([]string{"1"}) != nil, "expected error for short record")
+	testutils.Assert(t, pw.Close() == nil, "failed to close writer")
+
+	pf, err := buffer.NewBufferFile(buf.Bytes())
+	testutils.Assert(t, err == nil, "failed to open buffer: %s", err)
+	pr, err := reader.NewParquetColumnReader(pf, 1)
+	testutils.Assert(t, err == nil, "failed to create reader: %s", err)
+	testutils.Equals(t, int64(3), pr.GetNumRows(), "wrong number of rows")
+
+	// The invalid values of the last row are written as nulls
+	expected := [][]interface{}{
+		{int64(123), int64(456), nil},
+		{
+			time.Date(2020, 8, 26, 1, 0, 0, 123456000, time.UTC).UnixNano() / 1000,
+			time.Date(2020, 8, 26, 2, 0, 0, 0, time.UTC).UnixNano() / 1000,
+			time.Date(2020, 8, 26, 3, 0, 0, 0, time.UTC).UnixNano() / 1000,
+		},
+		{int32(7), nil, nil},
+		{0.5, nil, nil},
+		{`{"a":"b"}`, "{}", nil},
+		{nil, nil, nil},
+	}
+	for i, want := range expected {
+		values, _, _, err := pr.ReadColumnByIndex(int64(i), 3)
+		testutils.Assert(t, err == nil, "failed to read column %d: %s", i, err)
+		testutils.Assert(t, reflect.DeepEqual(want, values), "column %s: want %v, got %v", schema[i].DBName, want, values)
+	}
+}
@@ -0,0 +1,465 @@
+package warehouse
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/fullstorydev/hauser/config"
+	"github.com/lib/pq"
+)
+
+type Postgres struct {
+	conn       *sql.DB
+	conf       *config.PostgresConfig
+	syncSchema Schema
+	// schema is the last export schema that was applied, used to find the EventStart column
+	schema Schema
+}
+
+var (
+	postgresSchemaMap = map[reflect.Type]string{
+		reflect.TypeOf(int64(0)):    "BIGINT",
+		reflect.TypeOf(""):          "TEXT",
+		reflect.TypeOf(time.Time{}): "TIMESTAMPTZ",
+		reflect.TypeOf(float64(0)):  "DOUBLE PRECISION",
+		reflect.TypeOf(int32(0)):    "INTEGER",
+		reflect.TypeOf(false):       "BOOLEAN",
+	}
+
+	postgresWidenings = typeWidenings{
+		"SMALLINT": {"INTEGER", "BIGINT", "DOUBLE PRECISION"},
+		"INTEGER":  {"BIGINT", "DOUBLE PRECISION"},
+		"REAL":     {"DOUBLE PRECISION"},
+		"VARCHAR":  {"TEXT"},
+		"TEXT":     {"JSONB"},
+		"JSON":     {"JSONB"},
+	}
+
+	// postgresTypeNames maps the data types of information_schema to the names used in the schema map
+	postgresTypeNames = map[string]string{
+		"timestamp with time zone":    "TIMESTAMPTZ",
+		"timestamp without time zone": "TIMESTAMP",
+		"character varying":           "VARCHAR",
+	}
+)
+
+const postgresCustomVarsType = "JSONB"
+
+var _ DatabaseV2 = (*Postgres)(nil)
+var _ MigratingDatabase = (*Postgres)(nil)
+
+func NewPostgres(c *config.PostgresConfig) *Postgres {
+	return &Postgres{
+		conf:       c,
+		syncSchema: MakeSchema(syncTable{}),
+	}
+}
+
+func (pg *Postgres) qualifiedExportTableName() string {
+	return fmt.Sprintf("%s.%s", pg.conf.DatabaseSchema, pg.conf.ExportTable)
+}
+
+func (pg *Postgres) qualifiedSyncTableName() string {
+	return fmt.Sprintf("%s.%s", pg.conf.DatabaseSchema, pg.conf.SyncTable)
+}
+
+// toPostgresColumns converts the schema to column definitions. The custom vars are always
+// valid JSON, so they are stored as JSONB to allow them to be queried directly.
+func toPostgresColumns(columns []columnConfig) columnSchema {
+	for i := range columns {
+		if columns[i].FullStoryFieldName == "CustomVars" {
+			columns[i].DBType = postgresCustomVarsType
+		}
+	}
+	return columns
+}
+
+func (pg *Postgres) MakePostgresConnection() (*sql.DB, error) {
+	url := fmt.Sprintf("user=%v password=%v host=%v port=%v dbname=%v",
+		pg.conf.User,
+		pg.conf.Password,
+		pg.conf.Host,
+		pg.conf.Port,
+		pg.conf.DB)
+	if pg.conf.SSLMode != "" {
+		url = fmt.Sprintf("%s sslmode=%v", url, pg.conf.SSLMode)
+	}
+
+	var err error
+	var db *sql.DB
+	if db, err = sql.Open("postgres", url); err != nil {
+		return nil, fmt.Errorf("postgres connect error : (%v)", err)
+	}
+
+	if err = db.Ping(); err != nil {
+		return nil, fmt.Errorf("postgres ping error : (%v)", err)
+	}
+	return db, nil
+}
+
+// GetExportTableColumns returns all the columns of the export table.
+func (pg *Postgres) GetExportTableColumns(_ context.Context) ([]string, error) {
+	var err error
+	pg.conn, err = pg.MakePostgresConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer pg.conn.Close()
+
+	return pg.getTableColumns(pg.conf.ExportTable)
+}
+
+// ValueToString converts the value for the COPY statement. Postgres text columns cannot contain
+// null characters, but the COPY protocol escapes newlines so they can be kept.
+func (pg *Postgres) ValueToString(val interface{}, isTime bool) string {
+	s := fmt.Sprintf("%v", val)
+	if isTime {
+		t, _ := time.Parse(time.RFC3339Nano, s)
+		return t.Format(RFC3339Micro)
+	}
+	return strings.Replace(s, "\x00", "", -1)
+}
+
+// LoadToWarehouse streams the rows of the local CSV file into the export table.
+func (pg *Postgres) LoadToWarehouse(ctx context.Context, localFile string, _ time.Time) error {
+	f, err := os.Open(localFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	pg.conn, err = pg.MakePostgresConnection()
+	if err != nil {
+		return err
+	}
+	defer pg.conn.Close()
+
+	return pg.CopyInData(ctx, f)
+}
+
+// CopyInData copies the rows of the CSV stream into the export table using "COPY ... FROM STDIN".
+// The first row of the stream must contain the column names. Cancelling the context rolls back the copy.
+func (pg *Postgres) CopyInData(ctx context.Context, r io.Reader) error {
+	csvIn := csv.NewReader(r)
+	headers, err := csvIn.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read csv header: %s", err)
+	}
+	// Unquoted identifiers are folded to lower case when the table is created, but CopyInSchema quotes them
+	columns := make([]string, len(headers))
+	for i, h := range headers {
+		columns[i] = strings.ToLower(h)
+	}
+
+	txn, err := pg.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	stmt, err := txn.PrepareContext(ctx, pq.CopyInSchema(
+		strings.ToLower(pg.conf.DatabaseSchema), strings.ToLower(pg.conf.ExportTable), columns...))
+	if err != nil {
+		return err
+	}
+
+	keepEmpty := pg.keepEmptyValues(headers)
+	args := make([]interface{}, len(columns))
+	for {
+		record, err := csvIn.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			stmt.Close()
+			return fmt.Errorf("failed to read csv record: %s", err)
+		}
+		for i, val := range record {
+			// Empty values are missing fields, which need to be null for non-text columns
+			if val == "" && !keepEmpty[i] {
+				args[i] = nil
+			} else {
+				args[i] = val
+			}
+		}
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+
+	// Flush the buffered rows
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// keepEmptyValues returns whether the empty values of each column are loaded as empty strings rather
+// than nulls, which is the case for text columns like with the Redshift and BigQuery loads. CustomVars
+// can be a JSONB column, which doesn't accept an empty string.
+func (pg *Postgres) keepEmptyValues(headers []string) []bool {
+	keep := make([]bool, len(headers))
+	for i, h := range headers {
+		field := pg.schema.GetFieldForName(h)
+		keep[i] = field.FieldType == reflect.TypeOf("") && field.FullStoryFieldName != "CustomVars"
+	}
+	return keep
+}
+
+func (pg *Postgres) InitExportTable(_ context.Context, schema Schema) (bool, error) {
+	pg.schema = schema
+	var err error
+	pg.conn, err = pg.MakePostgresConnection()
+	if err != nil {
+		return false, err
+	}
+	defer pg.conn.Close()
+
+	exists, err := pg.DoesTableExist(pg.conf.ExportTable)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		log.Printf("Export table %s does not exist! Creating one!", pg.qualifiedExportTableName())
+		if err = pg.createExportTable(schema); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func (pg *Postgres) ApplyExportSchema(ctx context.Context, newSchema Schema) error {
+	pg.schema = newSchema
+	var err error
+	pg.conn, err = pg.MakePostgresConnection()
+	if err != nil {
+		return err
+	}
+	defer pg.conn.Close()
+
+	existingColumns, err := pg.getTableColumns(pg.conf.ExportTable)
+	if err != nil {
+		return err
+	}
+	missingFields, err := getColumnsToAdd(newSchema, existingColumns, postgresSchemaMap)
+	if err != nil {
+		return err
+	}
+	if len(missingFields) > 0 {
+		log.Printf("Found %d missing fields. Adding columns for these fields.", len(missingFields))
+		adds := make([]string, len(missingFields))
+		for i, f := range toPostgresColumns(missingFields) {
+			adds[i] = fmt.Sprintf("ADD COLUMN %s %s", f.DBName, f.DBType)
+		}
+		alterStmt := fmt.Sprintf("ALTER TABLE %s %s;", pg.qualifiedExportTableName(), strings.Join(adds, ", "))
+		if _, err = pg.conn.ExecContext(ctx, alterStmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pg *Postgres) createExportTable(schema Schema) error {
+	log.Printf("Creating table %s", pg.qualifiedExportTableName())
+
+	columns := toPostgresColumns(schemaToColumns(schema, postgresSchemaMap, "postgres"))
+	stmt := fmt.Sprintf("CREATE TABLE %s(%s);", pg.qualifiedExportTableName(), columns)
+	_, err := pg.conn.Exec(stmt)
+	return err
+}
+
+// CreateSyncTable creates a sync table with the hauser sync table schema
+func (pg *Postgres) CreateSyncTable() error {
+	log.Printf("Creating table %s", pg.qualifiedSyncTableName())
+
+	stmt := fmt.Sprintf("CREATE TABLE %s(%s);", pg.qualifiedSyncTableName(), schemaToColumns(pg.syncSchema, postgresSchemaMap, "postgres"))
+	_, err := pg.conn.Exec(stmt)
+	return err
+}
+
+func (pg *Postgres) SaveSyncPoint(_ context.Context, endTime time.Time) error {
+	var err error
+	pg.conn, err = pg.MakePostgresConnection()
+	if err != nil {
+		log.Printf("Couldn't connect to DB: %s", err)
+		return err
+	}
+	defer pg.conn.Close()
+
+	insert := fmt.Sprintf("INSERT INTO %s VALUES ($1, $2, $3);", pg.qualifiedSyncTableName())
+	_, err = pg.conn.Exec(insert, -1, time.Now().UTC(), endTime.UTC())
+	return err
+}
+
+func (pg *Postgres) LastSyncPoint(_ context.Context) (time.Time, error) {
+	t := time.Time{}
+	var err error
+	pg.conn, err = pg.MakePostgresConnection()
+	if err != nil {
+		log.Printf("Couldn't connect to DB: %s", err)
+		return t, err
+	}
+	defer pg.conn.Close()
+
+	exists, err := pg.DoesTableExist(pg.conf.SyncTable)
+	if err != nil {
+		return t, err
+	}
+	if !exists {
+		if err := pg.CreateSyncTable(); err != nil {
+			log.Printf("Couldn't create sync table: %s", err)
+			return t, err
+		}
+		return t, nil
+	}
+
+	var syncTime pq.NullTime
+	q := fmt.Sprintf("SELECT max(BundleEndTime) FROM %s;", pg.qualifiedSyncTableName())
+	if err := pg.conn.QueryRow(q).Scan(&syncTime); err != nil {
+		log.Printf("Couldn't get max(BundleEndTime): %s", err)
+		return t, err
+	}
+	if syncTime.Valid {
+		t = syncTime.Time
+	}
+
+	if err := pg.RemoveOrphanedRecords(syncTime); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// RemoveOrphanedRecords deletes export records that were loaded after the last sync point, which
+// happens when hauser stops after loading a file but before saving the sync point.
+func (pg *Postgres) RemoveOrphanedRecords(lastSync pq.NullTime) error {
+	exists, err := pg.DoesTableExist(pg.conf.ExportTable)
+	if err != nil || !exists {
+		// A missing table is okay, because the hauser process will ensure that the table exists.
+		return err
+	}
+
+	var exportTime pq.NullTime
+	q := fmt.Sprintf("SELECT max(%s) FROM %s;", pg.schema.ColumnFor("EventStart"), pg.qualifiedExportTableName())
+	if err := pg.conn.QueryRow(q).Scan(&exportTime); err != nil {
+		log.Printf("Couldn't get max(%s): %s", pg.schema.ColumnFor("EventStart"), err)
+		return err
+	}
+	if exportTime.Valid && exportTime.Time.After(lastSync.Time) {
+		log.Printf("Export record timestamp after sync time (%s vs %s); cleaning",
+			exportTime.Time, lastSync.Time)
+		return pg.DeleteExportRecordsAfter(lastSync.Time)
+	}
+	return nil
+}
+
+func (pg *Postgres) DeleteExportRecordsAfter(end time.Time) error {
+	stmt := fmt.Sprintf("DELETE FROM %s WHERE %s > $1;", pg.qualifiedExportTableName(), pg.schema.ColumnFor("EventStart"))
+	if _, err := pg.conn.Exec(stmt, end); err != nil {
+		log.Printf("failed to delete from %s: %s", pg.qualifiedExportTableName(), err)
+		return err
+	}
+	return nil
+}
+
+// DoesTableExist checks if a table with a given name exists
+func (pg *Postgres) DoesTableExist(name string) (bool, error) {
+	log.Printf("Checking if table %s exists", name)
+
+	var exists int
+	query := "SELECT count(*) FROM information_schema.tables WHERE table_schema = lower($1) AND table_name = lower($2);"
+	if err := pg.conn.QueryRow(query, pg.conf.DatabaseSchema, name).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists != 0, nil
+}
+
+func (pg *Postgres) getTableColumns(name string) ([]string, error) {
+	log.Printf("Fetching columns for table %s", name)
+	query := "SELECT column_name FROM information_schema.columns WHERE table_schema = lower($1) AND table_name = lower($2) ORDER BY ordinal_position;"
+	rows, err := pg.conn.Query(query, pg.conf.DatabaseSchema, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+// PlanMigrations returns the migrations for the existing columns that have a narrower type than the schema.
+func (pg *Postgres) PlanMigrations(_ context.Context, s Schema) ([]Migration, error) {
+	var err error
+	pg.conn, err = pg.MakePostgresConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer pg.conn.Close()
+
+	existing, err := pg.getColumnTypes(pg.conf.ExportTable)
+	if err != nil {
+		return nil, err
+	}
+	columns := toPostgresColumns(exportColumns(s, postgresSchemaMap, "postgres"))
+	return planMigrations(columns, existing, postgresWidenings, func(col columnConfig, _ string) []string {
+		stmt := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s", pg.qualifiedExportTableName(), col.DBName, col.DBType)
+		if col.DBType == postgresCustomVarsType {
+			stmt += fmt.Sprintf(" USING %s::jsonb", col.DBName)
+		}
+		return []string{stmt + ";"}
+	}), nil
+}
+
+func (pg *Postgres) ApplyMigrations(ctx context.Context, migrations []Migration) error {
+	var err error
+	pg.conn, err = pg.MakePostgresConnection()
+	if err != nil {
+		return err
+	}
+	defer pg.conn.Close()
+
+	return applySQLMigrations(ctx, pg.conn, migrations, migrationsTableName(pg.qualifiedExportTableName()), postgresSchemaMap, "$1, $2, $3, $4")
+}
+
+// getColumnTypes returns the types of the columns of the table, keyed by their lowercased names.
+func (pg *Postgres) getColumnTypes(name string) (map[string]string, error) {
+	query := "SELECT column_name, data_type FROM information_schema.columns WHERE table_schema = lower($1) AND table_name = lower($2);"
+	rows, err := pg.conn.Query(query, pg.conf.DatabaseSchema, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types := make(map[string]string)
+	for rows.Next() {
+		var column, dataType string
+		if err := rows.Scan(&column, &dataType); err != nil {
+			return nil, err
+		}
+		if name, ok := postgresTypeNames[dataType]; ok {
+			dataType = name
+		}
+		types[strings.ToLower(column)] = strings.ToUpper(dataType)
+	}
+	return types, rows.Err()
+}
@@ -0,0 +1,66 @@
+package warehouse
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/hauser/config"
+	"github.com/fullstorydev/hauser/testing/testutils"
+)
+
+func TestPostgresSchema(t *testing.T) {
+	fs := MakeSchema(BaseExportFields{}, MobileFields{}, syncTable{})
+	for _, field := range fs {
+		_, ok := postgresSchemaMap[field.FieldType]
+		testutils.Assert(t, ok, "field type %v not found in postgresSchemaMap", field.FieldType)
+	}
+}
+
+func TestPostgresColumns(t *testing.T) {
+	s := MakeSchema(struct {
+		EventStart      string
+		EventTargetText string
+		CustomVars      string
+	}{})
+	columns := toPostgresColumns(schemaToColumns(s, postgresSchemaMap, "postgres"))
+	testutils.Equals(t, "EventStart TEXT,EventTargetText TEXT,CustomVars JSONB", columns.String(), "wrong columns")
+
+	missing, err := getColumnsToAdd(s, []string{"eventstart"}, postgresSchemaMap)
+	testutils.Assert(t, err == nil, "unexpected error: %s", err)
+	testutils.Equals(t, "EventTargetText TEXT,CustomVars JSONB", toPostgresColumns(missing).String(), "wrong missing columns")
+}
+
+func TestPostgresValueToString(t *testing.T) {
+	wh := NewPostgres(&config.PostgresConfig{})
+
+	var testCases = []struct {
+		input    interface{}
+		isTime   bool
+		expected string
+	}{
+		{"short string", false, "short string"},
+		{"keeps\nnew\nlines", false, "keeps\nnew\nlines"},
+		{"no\x00null\x00chars", false, "nonullchars"},
+		{5, false, "5"},
+		{"2009-11-10T23:00:00.000Z", true, "2009-11-10T23:00:00Z"},
+	}
+
+	for _, testCase := range testCases {
+		if got := wh.ValueToString(testCase.input, testCase.isTime); got != testCase.expected {
+			t.Errorf("Expected value %q, got %q", testCase.expected, got)
+		}
+	}
+}
+
+func TestPostgresKeepEmptyValues(t *testing.T) {
+	wh := NewPostgres(&config.PostgresConfig{})
+	wh.schema = MakeSchema(struct {
+		EventStart      time.Time
+		EventTargetText string
+		PageDuration    int64
+		CustomVars      string
+	}{})
+	keep := wh.keepEmptyValues([]string{"EventStart", "EventTargetText", "PageDuration", "CustomVars", "Unknown"})
+	testutils.Assert(t, reflect.DeepEqual([]bool{false, true, false, false, false}, keep), "wrong columns with empty strings: %v", keep)
+}
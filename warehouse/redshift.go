@@ -1,8 +1,10 @@
 package warehouse
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -18,6 +20,12 @@ type Redshift struct {
 	conn       *sql.DB
 	conf       *config.RedshiftConfig
 	syncSchema Schema
+	// storage is used to upload the jsonpaths file when loading JSON
+	storage      Storage
+	schema       Schema
+	jsonPathsRef string
+	// superCustomVars is set if the CustomVars column of the export table has the SUPER type
+	superCustomVars bool
 }
 
 var (
@@ -27,30 +35,42 @@ var (
 		reflect.TypeOf(time.Time{}): "TIMESTAMP",
 		reflect.TypeOf(float64(0)):  "FLOAT",
 		reflect.TypeOf(int32(0)):    "INTEGER",
+		reflect.TypeOf(false):       "BOOLEAN",
 	}
-)
-
-type columnConfig struct {
-	DBName string
-	DBType string
-}
 
-type redshiftSchema []columnConfig
+	// redshiftWidenings are the type changes for migrations. Only the length of VARCHAR columns can be
+	// altered in place, other columns are rewritten.
+	redshiftWidenings = typeWidenings{
+		"SMALLINT": {"INTEGER", "BIGINT"},
+		"INTEGER":  {"BIGINT"},
+		"REAL":     {"FLOAT"},
+		"VARCHAR":  {"VARCHAR(max)"},
+	}
 
-func (c redshiftSchema) String() string {
-	ss := make([]string, len(c))
-	for i, f := range c {
-		ss[i] = fmt.Sprintf("%s %s", f.DBName, f.DBType)
+	// redshiftTypeNames maps the data types of information_schema to the names used in the schema map
+	redshiftTypeNames = map[string]string{
+		"double precision":            "FLOAT",
+		"timestamp without time zone": "TIMESTAMP",
+		"timestamp with time zone":    "TIMESTAMPTZ",
 	}
-	return strings.Join(ss, ",")
-}
+)
+
+const (
+	redshiftCustomVarsType = "SUPER"
+	// redshiftVarCharMax is the length of VARCHAR(max) columns
+	redshiftVarCharMax = 65535
+	// redshiftStagingTable is a temporary table, so it can't be qualified with the schema
+	redshiftStagingTable = "hauser_staging"
+)
 
-var _ Database = (*Redshift)(nil)
+var _ DatabaseV2 = (*Redshift)(nil)
+var _ MigratingDatabase = (*Redshift)(nil)
 
-func NewRedshift(c *config.RedshiftConfig) *Redshift {
+func NewRedshift(c *config.RedshiftConfig, storage Storage) *Redshift {
 	return &Redshift{
 		conf:       c,
 		syncSchema: MakeSchema(syncTable{}),
+		storage:    storage,
 	}
 }
 
@@ -87,15 +107,15 @@ func (rs *Redshift) validateSchemaConfig() error {
 
 // GetExportTableColumns returns all the columns of the export table.
 // It opens a connection and calls getTableColumns
-func (rs *Redshift) GetExportTableColumns() []string {
+func (rs *Redshift) GetExportTableColumns(ctx context.Context) ([]string, error) {
 	var err error
 	rs.conn, err = rs.MakeRedshiftConnection()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer rs.conn.Close()
 
-	return rs.getTableColumns(rs.conf.ExportTable)
+	return rs.getTableColumns(ctx, rs.conf.ExportTable)
 }
 
 func (rs *Redshift) ValueToString(val interface{}, isTime bool) string {
@@ -117,7 +137,7 @@ func (rs *Redshift) ValueToString(val interface{}, isTime bool) string {
 
 func (rs *Redshift) MakeRedshiftConnection() (*sql.DB, error) {
 	if err := rs.validateSchemaConfig(); err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	url := fmt.Sprintf("user=%v password=%v host=%v port=%v dbname=%v",
 		rs.conf.User,
@@ -147,7 +167,7 @@ func getBucketAndKey(bucketConfig, objName string) (string, string) {
 	return bucketName, key
 }
 
-func (rs *Redshift) LoadToWarehouse(s3obj string, _ time.Time) error {
+func (rs *Redshift) LoadToWarehouse(ctx context.Context, s3obj string, _ time.Time) error {
 	var err error
 	rs.conn, err = rs.MakeRedshiftConnection()
 	if err != nil {
@@ -155,44 +175,79 @@ func (rs *Redshift) LoadToWarehouse(s3obj string, _ time.Time) error {
 	}
 	defer rs.conn.Close()
 
-	if err = rs.CopyInData(s3obj); err != nil {
+	if rs.conf.FileFormat == config.JSONFormat && rs.jsonPathsRef == "" {
+		if err = rs.saveJSONPaths(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err = rs.CopyInData(ctx, s3obj); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func getColumnsToAdd(s Schema, existing []string) ([]columnConfig, error) {
-	if len(s) < len(existing) {
-		return nil, fmt.Errorf("incompatible schema: have %v, got %v", existing, s)
+// saveJSONPaths uploads a jsonpaths file that maps the JSON keys to the columns of the export table.
+// COPY matches the paths to the columns by position, so it follows the order of the schema.
+func (rs *Redshift) saveJSONPaths(ctx context.Context) error {
+	b, err := redshiftJSONPaths(rs.schema)
+	if err != nil {
+		return err
 	}
-	missing := make([]columnConfig, len(s)-len(existing))
-	for i := 0; i < len(missing); i++ {
-		field := s[len(existing)+i]
-		missing[i] = columnConfig{
-			DBName: field.DBName,
-			DBType: redshiftSchemaMap[field.FieldType],
-		}
+	name := fmt.Sprintf("%s%s.jsonpaths", rs.storage.GetFilePrefix(), rs.conf.ExportTable)
+	ref, err := rs.storage.SaveFile(ctx, name, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("failed to save jsonpaths file: %s", err)
 	}
-	return missing, nil
+	rs.jsonPathsRef = ref
+	return nil
 }
 
-func schemaToRedshiftSchema(s Schema) redshiftSchema {
-	columns := make([]columnConfig, len(s))
+func redshiftJSONPaths(s Schema) ([]byte, error) {
+	paths := make([]string, len(s))
 	for i, field := range s {
-		dbType, ok := redshiftSchemaMap[field.FieldType]
-		if !ok {
-			panic(fmt.Sprintf("field %s does not have a mapping to a database type for redshift", field.DBName))
-		}
-		columns[i] = columnConfig{
-			DBName: field.DBName,
-			DBType: dbType,
+		paths[i] = fmt.Sprintf("$['%s']", field.DBName)
+	}
+	return json.Marshal(map[string][]string{"jsonpaths": paths})
+}
+
+func schemaToRedshiftSchema(s Schema) columnSchema {
+	return schemaToColumns(s, redshiftSchemaMap, "redshift")
+}
+
+// nativeCustomVars returns whether a new CustomVars column is created with the SUPER type.
+func (rs *Redshift) nativeCustomVars() bool {
+	return rs.conf.NativeCustomVars || rs.conf.MigrateCustomVars
+}
+
+// exportTableColumns returns the columns of a new export table.
+func (rs *Redshift) exportTableColumns(s Schema) columnSchema {
+	columns := schemaToRedshiftSchema(s)
+	if rs.nativeCustomVars() {
+		return toRedshiftColumns(columns)
+	}
+	return columns
+}
+
+// toRedshiftColumns uses the SUPER type for the custom vars, so that they can be queried
+// with PartiQL instead of parsing the JSON string.
+func toRedshiftColumns(columns []columnConfig) columnSchema {
+	for i := range columns {
+		if columns[i].FullStoryFieldName == "CustomVars" {
+			columns[i].DBType = redshiftCustomVarsType
 		}
 	}
 	return columns
 }
 
-func (rs *Redshift) InitExportTable(schema Schema) (bool, error) {
+// redshiftParseJSON returns the expression that converts a JSON string column to SUPER.
+// Strings that aren't valid JSON, e.g. because they were truncated, become null.
+func redshiftParseJSON(col string) string {
+	return fmt.Sprintf("CASE WHEN IS_VALID_JSON(%s) THEN JSON_PARSE(%s) END", col, col)
+}
+
+func (rs *Redshift) InitExportTable(ctx context.Context, schema Schema) (bool, error) {
 	var err error
 	rs.conn, err = rs.MakeRedshiftConnection()
 	if err != nil {
@@ -200,18 +255,70 @@ func (rs *Redshift) InitExportTable(schema Schema) (bool, error) {
 	}
 	defer rs.conn.Close()
 
-	if !rs.DoesTableExist(rs.conf.ExportTable) {
+	rs.schema = schema
+	exists, err := rs.DoesTableExist(rs.conf.ExportTable)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
 		// if the export table does not exist we create one with all the columns we expect!
 		log.Printf("Export table %s does not exist! Creating one!", rs.qualifiedExportTableName())
 		if err = rs.createExportTable(schema); err != nil {
 			return false, err
 		}
+		rs.superCustomVars = rs.nativeCustomVars()
 		return true, nil
 	}
+	if err = rs.initCustomVars(ctx); err != nil {
+		return false, err
+	}
 	return false, nil
 }
 
-func (rs *Redshift) ApplyExportSchema(newSchema Schema) error {
+// initCustomVars checks the type of the CustomVars column in the existing export table, and converts
+// it to SUPER if MigrateCustomVars is set. The migration moves the column to the end of the table.
+func (rs *Redshift) initCustomVars(ctx context.Context) error {
+	columns, err := rs.getTableColumns(ctx, rs.conf.ExportTable)
+	if err != nil {
+		return err
+	}
+	column := findExportColumn(rs.schema, columns, "CustomVars")
+	if column == "" {
+		// A missing column is added with the SUPER type if NativeCustomVars is set
+		rs.superCustomVars = rs.nativeCustomVars()
+		return nil
+	}
+	dataType, err := rs.getColumnType(rs.conf.ExportTable, column)
+	if err != nil {
+		return err
+	}
+	switch {
+	case strings.EqualFold(dataType, redshiftCustomVarsType):
+		rs.superCustomVars = true
+	case rs.conf.MigrateCustomVars:
+		log.Printf("Converting CustomVars column of %s from %s to %s", rs.qualifiedExportTableName(), dataType, redshiftCustomVarsType)
+		if err := rs.migrateCustomVars(ctx, column); err != nil {
+			return fmt.Errorf("failed to migrate CustomVars column: %s", err)
+		}
+		rs.superCustomVars = true
+	default:
+		log.Printf("CustomVars column of %s has type %s. Set MigrateCustomVars to convert it to %s.",
+			rs.qualifiedExportTableName(), dataType, redshiftCustomVarsType)
+	}
+	return nil
+}
+
+func (rs *Redshift) migrateCustomVars(ctx context.Context, column string) error {
+	table := rs.qualifiedExportTableName()
+	return execInTransaction(ctx, rs.conn, []string{
+		fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s_super %s;", table, column, redshiftCustomVarsType),
+		fmt.Sprintf("UPDATE %s SET %s_super = %s;", table, column, redshiftParseJSON(column)),
+		fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", table, column),
+		fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s_super TO %s;", table, column, column),
+	})
+}
+
+func (rs *Redshift) ApplyExportSchema(ctx context.Context, newSchema Schema) error {
 	var err error
 	rs.conn, err = rs.MakeRedshiftConnection()
 	if err != nil {
@@ -219,17 +326,26 @@ func (rs *Redshift) ApplyExportSchema(newSchema Schema) error {
 	}
 	defer rs.conn.Close()
 
-	existingColumns := rs.getTableColumns(rs.conf.ExportTable)
-	missingFields, err := getColumnsToAdd(newSchema, existingColumns)
+	existingColumns, err := rs.getTableColumns(ctx, rs.conf.ExportTable)
 	if err != nil {
 		return err
 	}
+	missingFields, err := getColumnsToAdd(newSchema, existingColumns, redshiftSchemaMap)
+	if err != nil {
+		return err
+	}
+	rs.schema = newSchema
+	// The columns may have changed since the jsonpaths file was saved
+	rs.jsonPathsRef = ""
 	if len(missingFields) > 0 {
+		if rs.superCustomVars {
+			missingFields = toRedshiftColumns(missingFields)
+		}
 		log.Printf("Found %d missing fields. Adding columns for these fields.", len(missingFields))
 		for _, f := range missingFields {
 			// Redshift only allows addition of one column at a time, hence the the alter statements in a loop yuck
 			alterStmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", rs.qualifiedExportTableName(), f.DBName, f.DBType)
-			if _, err = rs.conn.Exec(alterStmt); err != nil {
+			if _, err = rs.conn.ExecContext(ctx, alterStmt); err != nil {
 				return err
 			}
 		}
@@ -238,18 +354,65 @@ func (rs *Redshift) ApplyExportSchema(newSchema Schema) error {
 }
 
 // CopyInData copies data from the given s3File to the export table
-func (rs *Redshift) CopyInData(s3file string) error {
-	copyStatement := fmt.Sprintf("COPY %s FROM '%s' CREDENTIALS '%s' DELIMITER ',' REGION '%s' FORMAT AS CSV IGNOREHEADER 1 ACCEPTINVCHARS;",
-		rs.qualifiedExportTableName(), s3file, rs.conf.Credentials, rs.conf.S3Region)
-	_, err := rs.conn.Exec(copyStatement)
+func (rs *Redshift) CopyInData(ctx context.Context, s3file string) error {
+	if rs.superCustomVars {
+		// The temporary table only exists in the session, so a transaction is used to keep the statements on one connection
+		return execInTransaction(ctx, rs.conn, rs.stagingStatements(s3file))
+	}
+	_, err := rs.conn.ExecContext(ctx, rs.copyStatement(rs.qualifiedExportTableName(), s3file))
 	return err
 }
 
+// stagingStatements copies the data into a temporary table first, since COPY doesn't parse JSON strings
+// into a SUPER column. The custom vars are parsed when the rows are inserted into the export table.
+func (rs *Redshift) stagingStatements(s3file string) []string {
+	staging := make(columnSchema, len(rs.schema))
+	var columns, values []string
+	for i, field := range rs.schema {
+		staging[i] = columnConfig{DBName: field.DBName, DBType: "VARCHAR(max)"}
+		if field.FieldType == nil {
+			// Columns that aren't part of the export are always empty
+			continue
+		}
+		staging[i].DBType = redshiftSchemaMap[field.FieldType]
+		columns = append(columns, field.DBName)
+		if field.FullStoryFieldName == "CustomVars" {
+			values = append(values, redshiftParseJSON(field.DBName))
+		} else {
+			values = append(values, field.DBName)
+		}
+	}
+	return []string{
+		fmt.Sprintf("CREATE TEMP TABLE %s(%s);", redshiftStagingTable, staging),
+		rs.copyStatement(redshiftStagingTable, s3file),
+		fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s;",
+			rs.qualifiedExportTableName(), strings.Join(columns, ","), strings.Join(values, ","), redshiftStagingTable),
+		fmt.Sprintf("DROP TABLE %s;", redshiftStagingTable),
+	}
+}
+
+func (rs *Redshift) copyStatement(table, s3file string) string {
+	switch rs.conf.FileFormat {
+	case config.JSONFormat:
+		// Values are loaded without modification, so long strings have to be truncated by COPY
+		return fmt.Sprintf("COPY %s FROM '%s' CREDENTIALS '%s' REGION '%s' FORMAT AS JSON '%s' TIMEFORMAT 'auto' TRUNCATECOLUMNS ACCEPTINVCHARS;",
+			table, s3file, rs.conf.Credentials, rs.conf.S3Region, rs.jsonPathsRef)
+	case config.ParquetFormat:
+		// Parquet columns are matched by position. Columnar formats don't accept the REGION option,
+		// the bucket has to be in the same region as the cluster.
+		return fmt.Sprintf("COPY %s FROM '%s' CREDENTIALS '%s' FORMAT AS PARQUET;",
+			table, s3file, rs.conf.Credentials)
+	default:
+		return fmt.Sprintf("COPY %s FROM '%s' CREDENTIALS '%s' DELIMITER ',' REGION '%s' FORMAT AS CSV IGNOREHEADER 1 ACCEPTINVCHARS;",
+			table, s3file, rs.conf.Credentials, rs.conf.S3Region)
+	}
+}
+
 // CreateExportTable creates an export table with the hauser export table schema
 func (rs *Redshift) createExportTable(schema Schema) error {
 	log.Printf("Creating table %s", rs.qualifiedExportTableName())
 
-	stmt := fmt.Sprintf("create table %s(%s);", rs.qualifiedExportTableName(), schemaToRedshiftSchema(schema))
+	stmt := fmt.Sprintf("create table %s(%s);", rs.qualifiedExportTableName(), rs.exportTableColumns(schema))
 	_, err := rs.conn.Exec(stmt)
 	return err
 }
@@ -282,8 +445,8 @@ func (rs *Redshift) SaveSyncPoint(_ context.Context, endTime time.Time) error {
 }
 
 func (rs *Redshift) DeleteExportRecordsAfter(end time.Time) error {
-	stmt := fmt.Sprintf("DELETE FROM %s where EventStart > '%s';",
-		rs.qualifiedExportTableName(), end.Format(time.RFC3339))
+	stmt := fmt.Sprintf("DELETE FROM %s where %s > '%s';",
+		rs.qualifiedExportTableName(), rs.schema.ColumnFor("EventStart"), end.Format(time.RFC3339))
 	_, err := rs.conn.Exec(stmt)
 	if err != nil {
 		log.Printf("failed to delete from %s: %s", rs.qualifiedExportTableName(), err)
@@ -303,7 +466,11 @@ func (rs *Redshift) LastSyncPoint(_ context.Context) (time.Time, error) {
 	}
 	defer rs.conn.Close()
 
-	if rs.DoesTableExist(rs.conf.SyncTable) {
+	exists, err := rs.DoesTableExist(rs.conf.SyncTable)
+	if err != nil {
+		return t, err
+	}
+	if exists {
 		var syncTime pq.NullTime
 		q := fmt.Sprintf("SELECT max(BundleEndTime) FROM %s;", rs.qualifiedSyncTableName())
 		if err := rs.conn.QueryRow(q).Scan(&syncTime); err != nil {
@@ -328,9 +495,9 @@ func (rs *Redshift) LastSyncPoint(_ context.Context) (time.Time, error) {
 }
 
 func (rs *Redshift) RemoveOrphanedRecords(lastSync pq.NullTime) error {
-	if !rs.DoesTableExist(rs.conf.ExportTable) {
-		// This is okay, because the hauser process will ensure that the table exists.
-		return nil
+	if exists, err := rs.DoesTableExist(rs.conf.ExportTable); err != nil || !exists {
+		// A missing table is okay, because the hauser process will ensure that the table exists.
+		return err
 	}
 
 	// Find the time of the latest export record...if it's after
@@ -339,9 +506,9 @@ func (rs *Redshift) RemoveOrphanedRecords(lastSync pq.NullTime) error {
 	// was written. Use this as the latest sync time, and don't load
 	// any records before this point to prevent duplication
 	var exportTime pq.NullTime
-	q := fmt.Sprintf("SELECT max(EventStart) FROM %s;", rs.qualifiedExportTableName())
+	q := fmt.Sprintf("SELECT max(%s) FROM %s;", rs.schema.ColumnFor("EventStart"), rs.qualifiedExportTableName())
 	if err := rs.conn.QueryRow(q).Scan(&exportTime); err != nil {
-		log.Printf("Couldn't get max(EventStart): %s", err)
+		log.Printf("Couldn't get max(%s): %s", rs.schema.ColumnFor("EventStart"), err)
 		return err
 	}
 	if exportTime.Valid && exportTime.Time.After(lastSync.Time) {
@@ -354,26 +521,34 @@ func (rs *Redshift) RemoveOrphanedRecords(lastSync pq.NullTime) error {
 }
 
 // DoesTableExist checks if a table with a given name exists
-func (rs *Redshift) DoesTableExist(name string) bool {
+func (rs *Redshift) DoesTableExist(name string) (bool, error) {
 	log.Printf("Checking if table %s exists", name)
 
 	var exists int
 	query := fmt.Sprintf("SELECT count(*) FROM information_schema.tables WHERE table_schema = %s AND table_name = $1;", rs.getSchemaParameter())
-	err := rs.conn.QueryRow(query, name).Scan(&exists)
-	if err != nil {
-		// something is horribly wrong...just give up
-		log.Fatal(err)
+	if err := rs.conn.QueryRow(query, name).Scan(&exists); err != nil {
+		return false, err
 	}
-	return (exists != 0)
+	return exists != 0, nil
 }
 
-func (rs *Redshift) getTableColumns(name string) []string {
+// getColumnType returns the data type of a column, or an empty string if the column doesn't exist.
+func (rs *Redshift) getColumnType(table, column string) (string, error) {
+	var dataType string
+	query := fmt.Sprintf("SELECT data_type FROM information_schema.columns WHERE table_schema = %s AND table_name = $1 AND column_name = $2;", rs.getSchemaParameter())
+	err := rs.conn.QueryRow(query, table, column).Scan(&dataType)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return dataType, err
+}
+
+func (rs *Redshift) getTableColumns(ctx context.Context, name string) ([]string, error) {
 	log.Printf("Fetching columns for table %s", name)
-	ctx := context.Background()
 	query := fmt.Sprintf("SELECT column_name FROM information_schema.columns WHERE table_schema = %s AND table_name = $1 order by ordinal_position;", rs.getSchemaParameter())
 	rows, err := rs.conn.QueryContext(ctx, query, name)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	var columns []string
 
@@ -381,14 +556,94 @@ func (rs *Redshift) getTableColumns(name string) []string {
 	for rows.Next() {
 		var column string
 		if err = rows.Scan(&column); err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		columns = append(columns, column)
 	}
 
 	// get any error encountered during iteration
-	if err = rows.Err(); err != nil {
-		log.Fatal(err)
+	return columns, rows.Err()
+}
+
+// PlanMigrations returns the migrations for the existing columns that have a narrower type than the schema.
+// The CustomVars column is converted to SUPER by MigrateCustomVars instead.
+func (rs *Redshift) PlanMigrations(_ context.Context, s Schema) ([]Migration, error) {
+	var err error
+	rs.conn, err = rs.MakeRedshiftConnection()
+	if err != nil {
+		return nil, err
 	}
-	return columns
+	defer rs.conn.Close()
+
+	existing, err := rs.getColumnTypes(rs.conf.ExportTable)
+	if err != nil {
+		return nil, err
+	}
+	var columns columnSchema
+	for _, col := range exportColumns(s, redshiftSchemaMap, "redshift") {
+		if col.FullStoryFieldName != "CustomVars" {
+			columns = append(columns, col)
+		}
+	}
+	return planMigrations(columns, existing, redshiftWidenings, rs.migrationStatements), nil
+}
+
+// migrationStatements alters the length of VARCHAR columns, which can't be done in a transaction. Other
+// columns are copied into a new column that replaces them, which moves them to the end of the table.
+func (rs *Redshift) migrationStatements(col columnConfig, from string) []string {
+	table := rs.qualifiedExportTableName()
+	if strings.HasPrefix(from, "VARCHAR") {
+		return []string{fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s;", table, col.DBName, col.DBType)}
+	}
+	return []string{
+		fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s_migrated %s;", table, col.DBName, col.DBType),
+		fmt.Sprintf("UPDATE %s SET %s_migrated = %s;", table, col.DBName, col.DBName),
+		fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", table, col.DBName),
+		fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s_migrated TO %s;", table, col.DBName, col.DBName),
+	}
+}
+
+func (rs *Redshift) ApplyMigrations(ctx context.Context, migrations []Migration) error {
+	var err error
+	rs.conn, err = rs.MakeRedshiftConnection()
+	if err != nil {
+		return err
+	}
+	defer rs.conn.Close()
+
+	return applySQLMigrations(ctx, rs.conn, migrations, migrationsTableName(rs.qualifiedExportTableName()), redshiftSchemaMap, "$1, $2, $3, $4")
+}
+
+// getColumnTypes returns the types of the columns of the table, keyed by their lowercased names.
+func (rs *Redshift) getColumnTypes(name string) (map[string]string, error) {
+	query := fmt.Sprintf("SELECT column_name, data_type, character_maximum_length FROM information_schema.columns WHERE table_schema = %s AND table_name = $1;", rs.getSchemaParameter())
+	rows, err := rs.conn.Query(query, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types := make(map[string]string)
+	for rows.Next() {
+		var column, dataType string
+		var length sql.NullInt64
+		if err := rows.Scan(&column, &dataType, &length); err != nil {
+			return nil, err
+		}
+		types[strings.ToLower(column)] = redshiftTypeName(dataType, length)
+	}
+	return types, rows.Err()
+}
+
+func redshiftTypeName(dataType string, length sql.NullInt64) string {
+	if name, ok := redshiftTypeNames[dataType]; ok {
+		return name
+	}
+	if dataType == "character varying" {
+		if !length.Valid || length.Int64 >= redshiftVarCharMax {
+			return "VARCHAR(max)"
+		}
+		return fmt.Sprintf("VARCHAR(%d)", length.Int64)
+	}
+	return strings.ToUpper(dataType)
 }
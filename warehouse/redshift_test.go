@@ -1,7 +1,9 @@
 package warehouse
 
 import (
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/fullstorydev/hauser/config"
 	"github.com/fullstorydev/hauser/testing/testutils"
@@ -116,7 +118,7 @@ func TestValidateSchemaConfig(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		wh := NewRedshift(tc.conf)
+		wh := NewRedshift(tc.conf, nil)
 		err := wh.validateSchemaConfig()
 		if tc.hasError && err == nil {
 			t.Errorf("expected Redshift.validateSchemaConfig() to return an error when config.Config.Redshift.DatabaseSchema is empty")
@@ -146,7 +148,7 @@ func TestGetExportTableName(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		wh := NewRedshift(tc.conf)
+		wh := NewRedshift(tc.conf, nil)
 		if got := wh.qualifiedExportTableName(); got != tc.expected {
 			t.Errorf("Expected value %q, got %q", tc.expected, got)
 		}
@@ -169,7 +171,7 @@ func TestGetSyncTableName(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		wh := NewRedshift(tc.conf)
+		wh := NewRedshift(tc.conf, nil)
 		if got := wh.qualifiedSyncTableName(); got != tc.expected {
 			t.Errorf("Expected value %q, got %q", tc.expected, got)
 		}
@@ -192,9 +194,78 @@ func TestGetSchemaParameter(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		wh := NewRedshift(tc.conf)
+		wh := NewRedshift(tc.conf, nil)
 		if got := wh.getSchemaParameter(); got != tc.expected {
 			t.Errorf("Expected value %q, got %q", tc.expected, got)
 		}
 	}
 }
+
+func TestRedshiftCopyStatement(t *testing.T) {
+	conf := makeConf("some_schema")
+	conf.Credentials = "aws_iam_role=arn"
+	conf.S3Region = "us-east-2"
+	wh := &Redshift{conf: conf}
+
+	testutils.Equals(t,
+		"COPY some_schema.exportTable FROM 's3://bucket/file.csv' CREDENTIALS 'aws_iam_role=arn' DELIMITER ',' REGION 'us-east-2' FORMAT AS CSV IGNOREHEADER 1 ACCEPTINVCHARS;",
+		wh.copyStatement(wh.qualifiedExportTableName(), "s3://bucket/file.csv"), "wrong csv copy statement")
+
+	conf.FileFormat = config.ParquetFormat
+	testutils.Equals(t,
+		"COPY some_schema.exportTable FROM 's3://bucket/file.parquet' CREDENTIALS 'aws_iam_role=arn' FORMAT AS PARQUET;",
+		wh.copyStatement(wh.qualifiedExportTableName(), "s3://bucket/file.parquet"), "wrong parquet copy statement")
+
+	conf.FileFormat = config.JSONFormat
+	wh.jsonPathsRef = "s3://bucket/exportTable.jsonpaths"
+	testutils.Equals(t,
+		"COPY some_schema.exportTable FROM 's3://bucket/file.json' CREDENTIALS 'aws_iam_role=arn' REGION 'us-east-2' FORMAT AS JSON 's3://bucket/exportTable.jsonpaths' TIMEFORMAT 'auto' TRUNCATECOLUMNS ACCEPTINVCHARS;",
+		wh.copyStatement(wh.qualifiedExportTableName(), "s3://bucket/file.json"), "wrong json copy statement")
+}
+
+func TestRedshiftStagingStatements(t *testing.T) {
+	conf := makeConf("some_schema")
+	conf.Credentials = "aws_iam_role=arn"
+	conf.S3Region = "us-east-2"
+	wh := &Redshift{
+		conf: conf,
+		schema: Schema{
+			{DBName: "EventStart", FullStoryFieldName: "EventStart", FieldType: reflect.TypeOf(time.Time{})},
+			{DBName: "CustomColumn"},
+			{DBName: "CustomVars", FullStoryFieldName: "CustomVars", FieldType: reflect.TypeOf("")},
+		},
+	}
+
+	expected := []string{
+		"CREATE TEMP TABLE hauser_staging(EventStart TIMESTAMP,CustomColumn VARCHAR(max),CustomVars VARCHAR(max));",
+		"COPY hauser_staging FROM 's3://bucket/file.csv' CREDENTIALS 'aws_iam_role=arn' DELIMITER ',' REGION 'us-east-2' FORMAT AS CSV IGNOREHEADER 1 ACCEPTINVCHARS;",
+		"INSERT INTO some_schema.exportTable (EventStart,CustomVars) SELECT EventStart,CASE WHEN IS_VALID_JSON(CustomVars) THEN JSON_PARSE(CustomVars) END FROM hauser_staging;",
+		"DROP TABLE hauser_staging;",
+	}
+	testutils.StrSliceEquals(t, expected, wh.stagingStatements("s3://bucket/file.csv"), "wrong staging statements")
+}
+
+func TestRedshiftCustomVarsColumn(t *testing.T) {
+	schema := Schema{
+		{DBName: "EventStart", FullStoryFieldName: "EventStart", FieldType: reflect.TypeOf(time.Time{})},
+		{DBName: "CustomVars", FullStoryFieldName: "CustomVars", FieldType: reflect.TypeOf("")},
+	}
+	wh := NewRedshift(&config.RedshiftConfig{}, nil)
+	testutils.Equals(t, "EventStart TIMESTAMP,CustomVars VARCHAR(max)", wh.exportTableColumns(schema).String(), "wrong default columns")
+
+	wh = NewRedshift(&config.RedshiftConfig{NativeCustomVars: true}, nil)
+	testutils.Equals(t, "EventStart TIMESTAMP,CustomVars SUPER", wh.exportTableColumns(schema).String(), "wrong native columns")
+	wh = NewRedshift(&config.RedshiftConfig{MigrateCustomVars: true}, nil)
+	testutils.Equals(t, "EventStart TIMESTAMP,CustomVars SUPER", wh.exportTableColumns(schema).String(), "wrong migrated columns")
+}
+
+func TestRedshiftJSONPaths(t *testing.T) {
+	schema := Schema{
+		{DBName: "EventCustomName", FullStoryFieldName: "EventCustomName"},
+		{DBName: "CustomVars", FullStoryFieldName: "CustomVars"},
+		{DBName: "CustomColumn"},
+	}
+	b, err := redshiftJSONPaths(schema)
+	testutils.Assert(t, err == nil, "unexpected error: %s", err)
+	testutils.Equals(t, `{"jsonpaths":["$['EventCustomName']","$['CustomVars']","$['CustomColumn']"]}`, string(b), "wrong jsonpaths")
+}
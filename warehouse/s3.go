@@ -2,14 +2,18 @@ package warehouse
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -40,9 +44,13 @@ func (s *S3Storage) SaveFile(ctx context.Context, name string, reader io.Reader)
 	ctx, cancelFn := context.WithTimeout(ctx, s.conf.Timeout.Duration)
 	defer cancelFn()
 
+	sess, err := s.newSession()
+	if err != nil {
+		return "", err
+	}
 	bucket, key := s.getBucketAndKey(name)
-	uploader := s3manager.NewUploader(s.newSession())
-	_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+	uploader := s3manager.NewUploader(sess)
+	_, err = uploader.UploadWithContext(ctx, &s3manager.UploadInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 		Body:   reader,
@@ -54,8 +62,12 @@ func (s *S3Storage) ReadFile(ctx context.Context, name string) (io.Reader, error
 	ctx, cancelFn := context.WithTimeout(ctx, s.conf.Timeout.Duration)
 	defer cancelFn()
 
+	sess, err := s.newSession()
+	if err != nil {
+		return nil, err
+	}
 	bucket, key := s.getBucketAndKey(name)
-	client := s3.New(s.newSession())
+	client := s3.New(sess)
 	out, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
@@ -73,9 +85,13 @@ func (s *S3Storage) DeleteFile(ctx context.Context, name string) error {
 	ctx, cancelFn := context.WithTimeout(ctx, s.conf.Timeout.Duration)
 	defer cancelFn()
 
+	sess, err := s.newSession()
+	if err != nil {
+		return err
+	}
 	bucket, key := s.getBucketAndKey(name)
-	client := s3.New(s.newSession())
-	_, err := client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+	client := s3.New(sess)
+	_, err = client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	})
@@ -95,8 +111,42 @@ func (s *S3Storage) GetFilePrefix() string {
 	return s.conf.FilePrefix
 }
 
-func (s *S3Storage) newSession() *session.Session {
-	return session.Must(session.NewSession(aws.NewConfig().WithRegion(s.conf.Region)))
+func (s *S3Storage) newSession() (*session.Session, error) {
+	cfg := aws.NewConfig().WithRegion(s.conf.Region)
+	if s.conf.Endpoint != "" {
+		cfg = cfg.WithEndpoint(s.conf.Endpoint)
+	}
+	if s.conf.ForcePathStyle {
+		cfg = cfg.WithS3ForcePathStyle(true)
+	}
+	if s.conf.AccessKeyID != "" {
+		cfg = cfg.WithCredentials(credentials.NewStaticCredentials(s.conf.AccessKeyID, s.conf.SecretAccessKey, ""))
+	}
+	if s.conf.InsecureSkipVerify {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		cfg = cfg.WithHTTPClient(&http.Client{Transport: transport})
+	}
+
+	opts := session.Options{Config: *cfg}
+	if s.conf.Profile != "" {
+		opts.Profile = s.conf.Profile
+		opts.SharedConfigState = session.SharedConfigEnable
+	}
+	if s.conf.CACertFile != "" {
+		caBundle, err := os.Open(s.conf.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open S3 CA bundle: %s", err)
+		}
+		defer caBundle.Close()
+		opts.CustomCABundle = caBundle
+	}
+
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 session: %s", err)
+	}
+	return sess, nil
 }
 
 func (s *S3Storage) getBucketAndKey(objName string) (string, string) {
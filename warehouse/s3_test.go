@@ -0,0 +1,102 @@
+package warehouse
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/hauser/config"
+	"github.com/fullstorydev/hauser/testing/testutils"
+)
+
+// fakeS3 is a minimal path-style S3 API, like the one served by MinIO.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	authz   []string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.authz = append(f.authz, r.Header.Get("Authorization"))
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := ioutil.ReadAll(r.Body)
+		f.objects[r.URL.Path] = body
+	case http.MethodGet:
+		obj, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`))
+			return
+		}
+		w.Write(obj)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestS3StorageCustomEndpoint(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewTLSServer(fake)
+	defer server.Close()
+
+	storage := NewS3Storage(&config.S3Config{
+		Bucket:             "hauser/exports",
+		Region:             "us-east-1",
+		Timeout:            config.Duration{Duration: time.Minute},
+		Endpoint:           server.URL,
+		ForcePathStyle:     true,
+		AccessKeyID:        "minio",
+		SecretAccessKey:    "minio123",
+		InsecureSkipVerify: true,
+	})
+	ctx := context.Background()
+
+	ref, err := storage.SaveFile(ctx, "bundle.csv", strings.NewReader("a,b\n1,2\n"))
+	testutils.Assert(t, err == nil, "failed to save file: %s", err)
+	testutils.Equals(t, "s3://hauser/exports/bundle.csv", ref, "wrong file reference")
+	testutils.Equals(t, "a,b\n1,2\n", string(fake.objects["/hauser/exports/bundle.csv"]), "wrong object contents")
+	testutils.Assert(t, strings.Contains(fake.authz[0], "Credential=minio/"), "expected static credentials, got %q", fake.authz[0])
+
+	r, err := storage.ReadFile(ctx, "bundle.csv")
+	testutils.Assert(t, err == nil, "failed to read file: %s", err)
+	data, _ := ioutil.ReadAll(r)
+	testutils.Equals(t, "a,b\n1,2\n", string(data), "wrong file contents")
+
+	_, err = storage.ReadFile(ctx, "missing.csv")
+	testutils.Equals(t, ErrFileNotFound, err, "expected file not found")
+
+	testutils.Assert(t, storage.DeleteFile(ctx, "bundle.csv") == nil, "failed to delete file")
+	_, ok := fake.objects["/hauser/exports/bundle.csv"]
+	testutils.Assert(t, !ok, "expected object to be deleted")
+}
+
+func TestS3StorageVerifiesTLS(t *testing.T) {
+	server := httptest.NewTLSServer(&fakeS3{objects: map[string][]byte{}})
+	defer server.Close()
+
+	// The test server's certificate isn't trusted, so requests fail unless verification is disabled
+	storage := NewS3Storage(&config.S3Config{
+		Bucket:          "hauser",
+		Region:          "us-east-1",
+		Timeout:         config.Duration{Duration: time.Minute},
+		Endpoint:        server.URL,
+		ForcePathStyle:  true,
+		AccessKeyID:     "minio",
+		SecretAccessKey: "minio123",
+	})
+	_, err := storage.SaveFile(context.Background(), "bundle.csv", strings.NewReader("a,b\n"))
+	testutils.Assert(t, err != nil, "expected certificate error")
+}
@@ -1,6 +1,8 @@
 package warehouse
 
 import (
+	"encoding/json"
+	"fmt"
 	"reflect"
 	"strings"
 	"time"
@@ -159,6 +161,8 @@ var specialCasedFields = map[string]WarehouseField{
 
 // GetFieldForName takes an existing column name and returns the matching schema field.
 // It performs some conversions between the legacy field names and the new field names.
+// Columns that were created with a different column naming also match their fields, in which
+// case the returned field keeps the existing column name.
 func (s Schema) GetFieldForName(col string) WarehouseField {
 	if specialCasedField, ok := specialCasedFields[strings.ToLower(col)]; ok {
 		return specialCasedField
@@ -168,11 +172,31 @@ func (s Schema) GetFieldForName(col string) WarehouseField {
 			return field
 		}
 	}
+	normalized := normalizeColumnName(col)
+	for _, field := range s {
+		if field.FullStoryFieldName == "" {
+			continue
+		}
+		if normalizeColumnName(field.DBName) == normalized || normalizeColumnName(field.FullStoryFieldName) == normalized {
+			field.DBName = col
+			return field
+		}
+	}
 	return WarehouseField{
 		DBName: col,
 	}
 }
 
+// ColumnFor returns the column name of an export field, or the field name if the schema doesn't have it.
+func (s Schema) ColumnFor(fsName string) string {
+	for _, field := range s {
+		if field.FullStoryFieldName == fsName {
+			return field.DBName
+		}
+	}
+	return fsName
+}
+
 func (s Schema) GetFullStoryFields() []string {
 	fsFields := make([]string, 0, len(s))
 	for _, field := range s {
@@ -180,7 +204,8 @@ func (s Schema) GetFullStoryFields() []string {
 			// We have to special case custom vars since they are combined into a single column
 			// Add the wildcards for each type of exportable custom var.
 			fsFields = append(fsFields, wildcardFields...)
-		} else if field.FullStoryFieldName != "" {
+		} else if field.FullStoryFieldName != "" && !IsCustomVar(field.FullStoryFieldName) {
+			// Custom vars with their own columns are already included by the wildcards.
 			// Only add non-empty fields since the database can have existing columns which
 			// are not part of the export.
 			fsFields = append(fsFields, field.FullStoryFieldName)
@@ -191,7 +216,12 @@ func (s Schema) GetFullStoryFields() []string {
 
 func IndexField(needle WarehouseField, haystack Schema) int {
 	for i, elm := range haystack {
-		if needle.FullStoryFieldName == elm.FullStoryFieldName {
+		if needle.FullStoryFieldName == "" {
+			// Columns that aren't part of the export are matched by name
+			if elm.FullStoryFieldName == "" && strings.EqualFold(needle.DBName, elm.DBName) {
+				return i
+			}
+		} else if needle.FullStoryFieldName == elm.FullStoryFieldName {
 			return i
 		}
 	}
@@ -233,3 +263,63 @@ func MakeSchema(vals ...interface{}) Schema {
 	}
 	return result
 }
+
+// fieldTypeNames are the names used for the field types when a schema is saved outside of the code.
+var fieldTypeNames = map[string]reflect.Type{
+	"string":    reflect.TypeOf(""),
+	"int64":     reflect.TypeOf(int64(0)),
+	"int32":     reflect.TypeOf(int32(0)),
+	"float64":   reflect.TypeOf(float64(0)),
+	"bool":      reflect.TypeOf(false),
+	"timestamp": reflect.TypeOf(time.Time{}),
+}
+
+// ParseFieldType returns the field type for one of the names returned by FieldTypeName.
+func ParseFieldType(name string) (reflect.Type, error) {
+	if t, ok := fieldTypeNames[strings.ToLower(name)]; ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("unknown field type %q", name)
+}
+
+// FieldTypeName returns the name of a field type, or an empty string if the type isn't supported.
+func FieldTypeName(t reflect.Type) string {
+	for name, fieldType := range fieldTypeNames {
+		if fieldType == t {
+			return name
+		}
+	}
+	return ""
+}
+
+// InferField returns the field for an export field that isn't part of the schema, with the type
+// inferred from one of its values. It returns false if the value is null.
+func InferField(name string, val interface{}) (WarehouseField, bool) {
+	var fieldType reflect.Type
+	switch v := val.(type) {
+	case nil:
+		return WarehouseField{}, false
+	case json.Number:
+		fieldType = reflect.TypeOf(float64(0))
+		if _, err := v.Int64(); err == nil {
+			fieldType = reflect.TypeOf(int64(0))
+		}
+	case float64:
+		fieldType = reflect.TypeOf(float64(0))
+	case bool:
+		fieldType = reflect.TypeOf(false)
+	case string:
+		fieldType = reflect.TypeOf("")
+		if _, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			fieldType = reflect.TypeOf(time.Time{})
+		}
+	default:
+		// Lists and objects are stored as JSON
+		fieldType = reflect.TypeOf("")
+	}
+	return WarehouseField{
+		DBName:             invalidColumnChars.ReplaceAllString(name, "_"),
+		FullStoryFieldName: name,
+		FieldType:          fieldType,
+	}, true
+}
@@ -1,6 +1,7 @@
 package warehouse
 
 import (
+	"encoding/json"
 	"reflect"
 	"testing"
 	"time"
@@ -278,3 +279,67 @@ func TestSchema_ReconcileWithExisting(t *testing.T) {
 		})
 	}
 }
+
+func TestReconcileWithExtraColumns(t *testing.T) {
+	s := Schema{
+		{"EventStart", "EventStart", timeType},
+		{"OrgId", "", stringType},
+	}
+	expect := Schema{
+		{"preexisting", "", nil},
+		{"EventStart", "EventStart", timeType},
+		{"OrgId", "", stringType},
+	}
+	updatedSchema := s.ReconcileWithExisting([]string{"preexisting", "eventstart"})
+	testutils.Assert(t, expect.Equals(updatedSchema), "wrong schema:\nwant %#v,\ngot  %#v", expect, updatedSchema)
+
+	updatedSchema = s.ReconcileWithExisting([]string{"orgid", "EventStart"})
+	testutils.Equals(t, 2, len(updatedSchema), "extra column shouldn't be added twice: %#v", updatedSchema)
+	testutils.Equals(t, stringType, updatedSchema[0].FieldType, "extra column should keep its type")
+}
+
+func TestInferField(t *testing.T) {
+	testCases := []struct {
+		name    string
+		val     interface{}
+		expType reflect.Type
+		expOk   bool
+	}{
+		{"NewCount", json.Number("42"), reflect.TypeOf(int64(0)), true},
+		{"NewRatio", json.Number("0.5"), reflect.TypeOf(float64(0)), true},
+		{"NewFlag", true, reflect.TypeOf(false), true},
+		{"NewTime", "2020-08-26T12:30:00.123Z", reflect.TypeOf(time.Time{}), true},
+		{"NewText", "hello", reflect.TypeOf(""), true},
+		{"NewList", []interface{}{"a", "b"}, reflect.TypeOf(""), true},
+		{"NewObject", map[string]interface{}{"a": 1}, reflect.TypeOf(""), true},
+		{"NewNull", nil, nil, false},
+	}
+	for _, tc := range testCases {
+		field, ok := InferField(tc.name, tc.val)
+		testutils.Equals(t, tc.expOk, ok, "wrong result for %s", tc.name)
+		if ok {
+			testutils.Equals(t, tc.expType, field.FieldType, "wrong type for %s", tc.name)
+			testutils.Equals(t, tc.name, field.FullStoryFieldName, "wrong export field for %s", tc.name)
+		}
+	}
+
+	field, _ := InferField("New.Field", "x")
+	testutils.Equals(t, "New_Field", field.DBName, "column name not sanitized")
+}
+
+func TestFieldTypeName(t *testing.T) {
+	for _, fieldType := range []reflect.Type{
+		reflect.TypeOf(""),
+		reflect.TypeOf(int64(0)),
+		reflect.TypeOf(int32(0)),
+		reflect.TypeOf(float64(0)),
+		reflect.TypeOf(false),
+		reflect.TypeOf(time.Time{}),
+	} {
+		parsed, err := ParseFieldType(FieldTypeName(fieldType))
+		testutils.Assert(t, err == nil, "unexpected error for %s: %s", fieldType, err)
+		testutils.Equals(t, fieldType, parsed, "type didn't round trip")
+	}
+	_, err := ParseFieldType("decimal")
+	testutils.Assert(t, err != nil, "expected error for unknown type")
+}
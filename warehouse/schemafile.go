@@ -0,0 +1,90 @@
+package warehouse
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// schemaFile is the format of the file set by the SchemaFile option. Each field lists the FullStory
+// export field, the column it is stored in and its type:
+//
+//	[[Field]]
+//	Name = "PageUserAgent"
+//	Column = "PageAgent"
+//	Type = "string"
+type schemaFile struct {
+	Field []schemaFileField
+}
+
+type schemaFileField struct {
+	Name string
+	// Column defaults to Name
+	Column string
+	// Type is one of the names returned by FieldTypeName
+	Type string
+}
+
+// LoadSchemaFile reads the export schema from a schema file. It replaces the schema made from
+// BaseExportFields and MobileFields, so fields that aren't listed are not requested in exports.
+func LoadSchemaFile(filename string) (Schema, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	s, err := ParseSchemaFile(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema file %s: %s", filename, err)
+	}
+	return s, nil
+}
+
+// ParseSchemaFile parses the contents of a schema file.
+func ParseSchemaFile(data string) (Schema, error) {
+	var file schemaFile
+	md, err := toml.Decode(data, &file)
+	if err != nil {
+		return nil, err
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("unknown key %s", undecoded[0])
+	}
+	if len(file.Field) == 0 {
+		return nil, fmt.Errorf("no fields")
+	}
+
+	s := make(Schema, 0, len(file.Field))
+	names := make(map[string]bool)
+	columns := make(map[string]bool)
+	for i, f := range file.Field {
+		if f.Name == "" {
+			return nil, fmt.Errorf("field %d has no name", i+1)
+		}
+		if f.Column == "" {
+			f.Column = f.Name
+		}
+		fieldType, err := ParseFieldType(f.Type)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %s", f.Name, err)
+		}
+		if f.Name == "CustomVars" && FieldTypeName(fieldType) != "string" {
+			return nil, fmt.Errorf("field CustomVars must be a string")
+		}
+		if names[strings.ToLower(f.Name)] {
+			return nil, fmt.Errorf("duplicate field %s", f.Name)
+		}
+		if columns[strings.ToLower(f.Column)] {
+			return nil, fmt.Errorf("duplicate column %s", f.Column)
+		}
+		names[strings.ToLower(f.Name)] = true
+		columns[strings.ToLower(f.Column)] = true
+		s = append(s, WarehouseField{
+			DBName:             f.Column,
+			FullStoryFieldName: f.Name,
+			FieldType:          fieldType,
+		})
+	}
+	return s, nil
+}
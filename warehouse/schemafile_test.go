@@ -0,0 +1,49 @@
+package warehouse
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fullstorydev/hauser/testing/testutils"
+)
+
+func TestExampleSchemaFile(t *testing.T) {
+	s, err := LoadSchemaFile("../example-schema.toml")
+	testutils.Assert(t, err == nil, "unexpected error: %s", err)
+	testutils.Assert(t, s.Equals(MakeSchema(BaseExportFields{})), "example schema doesn't match BaseExportFields")
+}
+
+func TestParseSchemaFile(t *testing.T) {
+	s, err := ParseSchemaFile(`
+[[Field]]
+Name = "EventStart"
+Type = "timestamp"
+
+[[Field]]
+Name = "PageUserAgent"
+Column = "PageAgent"
+Type = "string"
+`)
+	testutils.Assert(t, err == nil, "unexpected error: %s", err)
+	expected := Schema{
+		{DBName: "EventStart", FullStoryFieldName: "EventStart", FieldType: reflect.TypeOf(BaseExportFields{}.EventStart)},
+		{DBName: "PageAgent", FullStoryFieldName: "PageUserAgent", FieldType: reflect.TypeOf("")},
+	}
+	testutils.Assert(t, s.Equals(expected), "wrong schema: %v", s)
+	testutils.StrSliceEquals(t, []string{"EventStart", "PageUserAgent"}, s.GetFullStoryFields(), "wrong export fields")
+
+	errorCases := map[string]string{
+		"no fields":      ``,
+		"unknown key":    "[[Field]]\nName = \"EventStart\"\nType = \"timestamp\"\nNullable = true",
+		"missing name":   "[[Field]]\nType = \"string\"",
+		"unknown type":   "[[Field]]\nName = \"EventStart\"\nType = \"datetime\"",
+		"duplicate name": "[[Field]]\nName = \"PageUrl\"\nType = \"string\"\n[[Field]]\nName = \"pageurl\"\nColumn = \"Url\"\nType = \"string\"",
+		"duplicate column": "[[Field]]\nName = \"PageUrl\"\nType = \"string\"\n" +
+			"[[Field]]\nName = \"PageRefererUrl\"\nColumn = \"PageUrl\"\nType = \"string\"",
+		"typed custom vars": "[[Field]]\nName = \"CustomVars\"\nType = \"int64\"",
+	}
+	for name, data := range errorCases {
+		_, err := ParseSchemaFile(data)
+		testutils.Assert(t, err != nil, "expected error for %s", name)
+	}
+}
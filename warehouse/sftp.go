@@ -0,0 +1,240 @@
+package warehouse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fullstorydev/hauser/config"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPStorage uploads export files to a directory on an SFTP server. A new connection is opened
+// for every operation, since exports are infrequent and servers tend to drop idle connections.
+type SFTPStorage struct {
+	conf *config.SFTPConfig
+}
+
+var _ Storage = (*SFTPStorage)(nil)
+
+func NewSFTPStorage(conf *config.SFTPConfig) *SFTPStorage {
+	return &SFTPStorage{
+		conf: conf,
+	}
+}
+
+func (s *SFTPStorage) LastSyncPoint(ctx context.Context) (time.Time, error) {
+	return SyncViaStorageMixin{s}.LastSyncPoint(ctx)
+}
+
+func (s *SFTPStorage) SaveSyncPoint(ctx context.Context, endTime time.Time) error {
+	return SyncViaStorageMixin{s}.SaveSyncPoint(ctx, endTime)
+}
+
+// SaveFile uploads to a temporary name and renames the file once the upload is complete,
+// so that partners never pick up a partially written file. The context is checked between steps,
+// but an upload that has started runs to completion.
+//
+// Servers without the posix-rename extension can't replace a file atomically, so the existing file
+// is removed before the rename. If that rename fails, the file is missing until it is saved again.
+func (s *SFTPStorage) SaveFile(ctx context.Context, name string, reader io.Reader) (string, error) {
+	client, err := s.connect()
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	remotePath := s.remotePath(name)
+	if err := client.MkdirAll(path.Dir(remotePath)); err != nil {
+		return "", fmt.Errorf("failed to create remote directory %s: %s", path.Dir(remotePath), err)
+	}
+
+	tmpPath := remotePath + ".tmp"
+	f, err := client.Create(tmpPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %s", tmpPath, err)
+	}
+	if _, err := f.ReadFrom(reader); err != nil {
+		f.Close()
+		client.Remove(tmpPath)
+		return "", fmt.Errorf("failed to upload %s: %s", tmpPath, err)
+	}
+	if err := f.Close(); err != nil {
+		client.Remove(tmpPath)
+		return "", fmt.Errorf("failed to upload %s: %s", tmpPath, err)
+	}
+	if err := ctx.Err(); err != nil {
+		client.Remove(tmpPath)
+		return "", err
+	}
+
+	if err := client.PosixRename(tmpPath, remotePath); err != nil {
+		// Plain SFTP renames fail if the target exists, which is always the case for the sync file
+		if err := client.Remove(remotePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to replace %s: %s", remotePath, err)
+		}
+		if err := client.Rename(tmpPath, remotePath); err != nil {
+			return "", fmt.Errorf("failed to rename %s to %s: %s", tmpPath, remotePath, err)
+		}
+	}
+	return s.GetFileReference(name), nil
+}
+
+// ReadFile reads the whole file into memory, since the connection is closed before returning.
+func (s *SFTPStorage) ReadFile(_ context.Context, name string) (io.Reader, error) {
+	client, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	f, err := client.Open(s.remotePath(name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrFileNotFound
+		}
+		return nil, fmt.Errorf("failed to open sftp file %s: %s", name, err)
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		return nil, fmt.Errorf("failed to read sftp file %s: %s", name, err)
+	}
+	return &buf, nil
+}
+
+func (s *SFTPStorage) DeleteFile(_ context.Context, name string) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Remove(s.remotePath(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete sftp file %s: %s", name, err)
+	}
+	return nil
+}
+
+func (s *SFTPStorage) GetFileReference(name string) string {
+	return fmt.Sprintf("sftp://%s%s", net.JoinHostPort(s.conf.Host, s.port()), path.Join("/", s.remotePath(name)))
+}
+
+func (s *SFTPStorage) GetFilePrefix() string {
+	return s.conf.FilePrefix
+}
+
+// remotePath returns the path of the file on the server. File names include the FilePrefix,
+// so a prefix such as "hauser/" results in a sub-directory of the configured directory.
+func (s *SFTPStorage) remotePath(name string) string {
+	return path.Join(s.conf.Directory, name)
+}
+
+func (s *SFTPStorage) port() string {
+	if s.conf.Port == "" {
+		return "22"
+	}
+	return s.conf.Port
+}
+
+// sftpClient closes the underlying SSH connection along with the SFTP session.
+type sftpClient struct {
+	*sftp.Client
+	conn *ssh.Client
+}
+
+func (c *sftpClient) Close() error {
+	c.Client.Close()
+	return c.conn.Close()
+}
+
+func (s *SFTPStorage) connect() (*sftpClient, error) {
+	sshConf, err := s.sshConfig()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(s.conf.Host, s.port()), sshConf)
+	if err != nil {
+		return nil, fmt.Errorf("sftp connect error : (%v)", err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sftp session error : (%v)", err)
+	}
+	return &sftpClient{Client: client, conn: conn}, nil
+}
+
+func (s *SFTPStorage) sshConfig() (*ssh.ClientConfig, error) {
+	var auth []ssh.AuthMethod
+	if s.conf.PrivateKeyFile != "" {
+		keyFile, err := expandHome(s.conf.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		keyData, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key: %s", err)
+		}
+		var signer ssh.Signer
+		if s.conf.PrivateKeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(keyData, []byte(s.conf.PrivateKeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(keyData)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %s", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if s.conf.Password != "" {
+		auth = append(auth, ssh.Password(s.conf.Password))
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+	if s.conf.InsecureIgnoreHostKey {
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	} else {
+		knownHostsFile, err := expandHome(s.conf.KnownHostsFile)
+		if err != nil {
+			return nil, err
+		}
+		if hostKeyCallback, err = knownhosts.New(knownHostsFile); err != nil {
+			return nil, fmt.Errorf("failed to load known hosts: %s", err)
+		}
+	}
+
+	return &ssh.ClientConfig{
+		User:            s.conf.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         s.conf.Timeout.Duration,
+	}, nil
+}
+
+// expandHome replaces a leading "~/" with the user's home directory, like a shell would.
+func expandHome(p string) (string, error) {
+	if !strings.HasPrefix(p, "~/") {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to expand %s: %s", p, err)
+	}
+	return filepath.Join(home, p[2:]), nil
+}
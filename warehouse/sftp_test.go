@@ -0,0 +1,204 @@
+package warehouse
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/hauser/config"
+	"github.com/fullstorydev/hauser/testing/testutils"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// startSFTPServer runs an in-process SSH server that serves the local file system over SFTP.
+// It accepts the password "secret" and the provided client key.
+func startSFTPServer(t *testing.T, clientKey ssh.PublicKey) (net.Listener, ssh.PublicKey) {
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	testutils.Assert(t, err == nil, "failed to generate host key: %s", err)
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	testutils.Assert(t, err == nil, "failed to create host signer: %s", err)
+
+	serverConf := &ssh.ServerConfig{
+		PasswordCallback: func(_ ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if clientKey != nil && string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	serverConf.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	testutils.Assert(t, err == nil, "failed to listen: %s", err)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTPConn(conn, serverConf)
+		}
+	}()
+	return listener, hostSigner.PublicKey()
+}
+
+func serveSFTPConn(conn net.Conn, serverConf *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, serverConf)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					server, err := sftp.NewServer(channel)
+					if err == nil {
+						server.Serve()
+					}
+					channel.Close()
+				}
+			}
+		}()
+	}
+}
+
+func TestSFTPStorage(t *testing.T) {
+	listener, _ := startSFTPServer(t, nil)
+	defer listener.Close()
+	dir, err := ioutil.TempDir("", "hauser-sftp")
+	testutils.Assert(t, err == nil, "failed to create temp dir: %s", err)
+	defer os.RemoveAll(dir)
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	storage := NewSFTPStorage(&config.SFTPConfig{
+		StorageConfig:         config.StorageConfig{FilePrefix: "hauser/"},
+		Host:                  host,
+		Port:                  port,
+		User:                  "partner",
+		Password:              "secret",
+		InsecureIgnoreHostKey: true,
+		Directory:             filepath.ToSlash(dir),
+		Timeout:               config.Duration{Duration: 10 * time.Second},
+	})
+	ctx := context.Background()
+
+	name := storage.GetFilePrefix() + "bundle.csv"
+	ref, err := storage.SaveFile(ctx, name, strings.NewReader("a,b\n1,2\n"))
+	testutils.Assert(t, err == nil, "failed to save file: %s", err)
+	testutils.Equals(t, "sftp://"+listener.Addr().String()+filepath.ToSlash(dir)+"/hauser/bundle.csv", ref, "wrong file reference")
+	data, err := ioutil.ReadFile(filepath.Join(dir, "hauser", "bundle.csv"))
+	testutils.Assert(t, err == nil, "failed to read uploaded file: %s", err)
+	testutils.Equals(t, "a,b\n1,2\n", string(data), "wrong uploaded contents")
+	_, err = os.Stat(filepath.Join(dir, "hauser", "bundle.csv.tmp"))
+	testutils.Assert(t, os.IsNotExist(err), "expected temporary file to be renamed")
+
+	r, err := storage.ReadFile(ctx, name)
+	testutils.Assert(t, err == nil, "failed to read file: %s", err)
+	data, _ = ioutil.ReadAll(r)
+	testutils.Equals(t, "a,b\n1,2\n", string(data), "wrong file contents")
+
+	_, err = storage.ReadFile(ctx, "missing.csv")
+	testutils.Equals(t, ErrFileNotFound, err, "expected file not found")
+
+	testutils.Assert(t, storage.DeleteFile(ctx, name) == nil, "failed to delete file")
+	_, err = os.Stat(filepath.Join(dir, "hauser", "bundle.csv"))
+	testutils.Assert(t, os.IsNotExist(err), "expected file to be deleted")
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = storage.SaveFile(cancelled, name, strings.NewReader("a,b\n1,2\n"))
+	testutils.Equals(t, context.Canceled, err, "wrong error when cancelled")
+	_, err = os.Stat(filepath.Join(dir, "hauser", "bundle.csv"))
+	testutils.Assert(t, os.IsNotExist(err), "expected no file when cancelled")
+
+	// The sync file is overwritten on every save, which exercises replacing an existing file
+	for _, end := range []time.Time{
+		time.Date(2020, 8, 26, 1, 0, 0, 0, time.UTC),
+		time.Date(2020, 8, 26, 2, 0, 0, 0, time.UTC),
+	} {
+		testutils.Assert(t, storage.SaveSyncPoint(ctx, end) == nil, "failed to save sync point")
+		last, err := storage.LastSyncPoint(ctx)
+		testutils.Assert(t, err == nil && last.Equal(end), "wrong sync point %s (%v)", last, err)
+	}
+}
+
+func TestSFTPStorageKeyAuth(t *testing.T) {
+	clientKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	testutils.Assert(t, err == nil, "failed to generate client key: %s", err)
+	clientPub, err := ssh.NewPublicKey(&clientKey.PublicKey)
+	testutils.Assert(t, err == nil, "failed to create public key: %s", err)
+
+	listener, hostKey := startSFTPServer(t, clientPub)
+	defer listener.Close()
+	dir, err := ioutil.TempDir("", "hauser-sftp")
+	testutils.Assert(t, err == nil, "failed to create temp dir: %s", err)
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "id_rsa")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(clientKey)})
+	testutils.Assert(t, ioutil.WriteFile(keyFile, keyPEM, 0600) == nil, "failed to write key")
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{listener.Addr().String()}, hostKey)
+	testutils.Assert(t, ioutil.WriteFile(knownHostsFile, []byte(line+"\n"), 0600) == nil, "failed to write known hosts")
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	conf := &config.SFTPConfig{
+		Host:           host,
+		Port:           port,
+		User:           "partner",
+		PrivateKeyFile: keyFile,
+		KnownHostsFile: knownHostsFile,
+		Directory:      filepath.ToSlash(filepath.Join(dir, "drop")),
+	}
+	_, err = NewSFTPStorage(conf).SaveFile(context.Background(), "bundle.csv", strings.NewReader("a\n"))
+	testutils.Assert(t, err == nil, "failed to save file: %s", err)
+
+	// A host key that doesn't match the known hosts file must be rejected
+	otherListener, _ := startSFTPServer(t, clientPub)
+	defer otherListener.Close()
+	_, conf.Port, _ = net.SplitHostPort(otherListener.Addr().String())
+	_, err = NewSFTPStorage(conf).SaveFile(context.Background(), "bundle.csv", strings.NewReader("a\n"))
+	testutils.Assert(t, err != nil, "expected host key verification to fail")
+}
+
+func TestExpandHome(t *testing.T) {
+	home, err := os.UserHomeDir()
+	testutils.Assert(t, err == nil, "failed to get home directory: %s", err)
+
+	p, err := expandHome("~/.ssh/known_hosts")
+	testutils.Assert(t, err == nil, "failed to expand path: %s", err)
+	testutils.Equals(t, filepath.Join(home, ".ssh", "known_hosts"), p, "wrong expanded path")
+
+	p, err = expandHome("/etc/ssh/known_hosts")
+	testutils.Assert(t, err == nil, "failed to expand path: %s", err)
+	testutils.Equals(t, "/etc/ssh/known_hosts", p, "absolute path shouldn't change")
+}
@@ -0,0 +1,349 @@
+package warehouse
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/fullstorydev/hauser/config"
+	"github.com/snowflakedb/gosnowflake"
+)
+
+type Snowflake struct {
+	conn       *sql.DB
+	conf       *config.SnowflakeConfig
+	syncSchema Schema
+	// schema is the last export schema that was applied, used to find the EventStart column
+	schema Schema
+}
+
+var (
+	snowflakeSchemaMap = map[reflect.Type]string{
+		reflect.TypeOf(int64(0)):    "BIGINT",
+		reflect.TypeOf(""):          "VARCHAR",
+		reflect.TypeOf(time.Time{}): "TIMESTAMP_TZ",
+		reflect.TypeOf(float64(0)):  "FLOAT",
+		reflect.TypeOf(int32(0)):    "INTEGER",
+		reflect.TypeOf(false):       "BOOLEAN",
+	}
+)
+
+var _ DatabaseV2 = (*Snowflake)(nil)
+
+func NewSnowflake(c *config.SnowflakeConfig) *Snowflake {
+	return &Snowflake{
+		conf:       c,
+		syncSchema: MakeSchema(syncTable{}),
+	}
+}
+
+func (sf *Snowflake) qualifiedExportTableName() string {
+	return fmt.Sprintf("%s.%s", sf.conf.DatabaseSchema, sf.conf.ExportTable)
+}
+
+func (sf *Snowflake) qualifiedSyncTableName() string {
+	return fmt.Sprintf("%s.%s", sf.conf.DatabaseSchema, sf.conf.SyncTable)
+}
+
+// stageName returns the internal stage that files are uploaded to. If no stage is configured,
+// the export table's stage is used, which every Snowflake table has by default.
+func (sf *Snowflake) stageName() string {
+	if sf.conf.Stage == "" {
+		return fmt.Sprintf("@%s.%%%s", sf.conf.DatabaseSchema, sf.conf.ExportTable)
+	}
+	return "@" + sf.conf.Stage
+}
+
+func (sf *Snowflake) validateConfig() error {
+	if sf.conf.Account == "" {
+		return errors.New("Account definition missing from Snowflake configuration")
+	}
+	if sf.conf.DB == "" {
+		return errors.New("DB definition missing from Snowflake configuration")
+	}
+	if sf.conf.DatabaseSchema == "" {
+		return errors.New("DatabaseSchema definition missing from Snowflake configuration")
+	}
+	if sf.conf.ExportTable == "" {
+		return errors.New("ExportTable definition missing from Snowflake configuration")
+	}
+	if sf.conf.SyncTable == "" {
+		return errors.New("SyncTable definition missing from Snowflake configuration")
+	}
+	return nil
+}
+
+func (sf *Snowflake) MakeSnowflakeConnection() (*sql.DB, error) {
+	if err := sf.validateConfig(); err != nil {
+		return nil, err
+	}
+	dsn, err := gosnowflake.DSN(&gosnowflake.Config{
+		Account:   sf.conf.Account,
+		User:      sf.conf.User,
+		Password:  sf.conf.Password,
+		Role:      sf.conf.Role,
+		Warehouse: sf.conf.Warehouse,
+		Database:  sf.conf.DB,
+		Schema:    sf.conf.DatabaseSchema,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("snowflake config error : (%v)", err)
+	}
+
+	var db *sql.DB
+	if db, err = sql.Open("snowflake", dsn); err != nil {
+		return nil, fmt.Errorf("snowflake connect error : (%v)", err)
+	}
+
+	if err = db.Ping(); err != nil {
+		return nil, fmt.Errorf("snowflake ping error : (%v)", err)
+	}
+	return db, nil
+}
+
+// GetExportTableColumns returns all the columns of the export table.
+func (sf *Snowflake) GetExportTableColumns(_ context.Context) ([]string, error) {
+	var err error
+	sf.conn, err = sf.MakeSnowflakeConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer sf.conn.Close()
+
+	return sf.getTableColumns(sf.conf.ExportTable)
+}
+
+// ValueToString converts the value for a quoted CSV field. Unlike Redshift, Snowflake handles newlines
+// within quoted fields, so those are left intact.
+func (sf *Snowflake) ValueToString(val interface{}, isTime bool) string {
+	s := fmt.Sprintf("%v", val)
+	if isTime {
+		t, _ := time.Parse(time.RFC3339Nano, s)
+		return t.Format(RFC3339Micro)
+	}
+	return strings.Replace(s, "\x00", "", -1)
+}
+
+// LoadToWarehouse uploads the local file to the internal stage and copies it into the export table.
+func (sf *Snowflake) LoadToWarehouse(ctx context.Context, localFile string, _ time.Time) error {
+	var err error
+	sf.conn, err = sf.MakeSnowflakeConnection()
+	if err != nil {
+		return err
+	}
+	defer sf.conn.Close()
+
+	absPath, err := filepath.Abs(localFile)
+	if err != nil {
+		return err
+	}
+
+	// The file is compressed during upload, which adds a ".gz" extension to the staged file
+	put := fmt.Sprintf("PUT 'file://%s' %s AUTO_COMPRESS = TRUE OVERWRITE = TRUE;",
+		filepath.ToSlash(absPath), sf.stageName())
+	if _, err := sf.conn.ExecContext(ctx, put); err != nil {
+		return fmt.Errorf("failed to upload %s to stage %s: %s", localFile, sf.stageName(), err)
+	}
+
+	return sf.CopyInData(ctx, filepath.Base(absPath)+".gz")
+}
+
+// CopyInData copies the given staged file into the export table and removes it from the stage.
+func (sf *Snowflake) CopyInData(ctx context.Context, stagedFile string) error {
+	copyStatement := fmt.Sprintf("COPY INTO %s FROM %s FILES = ('%s') FILE_FORMAT = (TYPE = CSV SKIP_HEADER = 1 FIELD_OPTIONALLY_ENCLOSED_BY = '\"') PURGE = TRUE;",
+		sf.qualifiedExportTableName(), sf.stageName(), stagedFile)
+	_, err := sf.conn.ExecContext(ctx, copyStatement)
+	return err
+}
+
+func (sf *Snowflake) InitExportTable(_ context.Context, schema Schema) (bool, error) {
+	sf.schema = schema
+	var err error
+	sf.conn, err = sf.MakeSnowflakeConnection()
+	if err != nil {
+		return false, err
+	}
+	defer sf.conn.Close()
+
+	exists, err := sf.DoesTableExist(sf.conf.ExportTable)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		log.Printf("Export table %s does not exist! Creating one!", sf.qualifiedExportTableName())
+		if err = sf.createExportTable(schema); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func (sf *Snowflake) ApplyExportSchema(ctx context.Context, newSchema Schema) error {
+	sf.schema = newSchema
+	var err error
+	sf.conn, err = sf.MakeSnowflakeConnection()
+	if err != nil {
+		return err
+	}
+	defer sf.conn.Close()
+
+	existingColumns, err := sf.getTableColumns(sf.conf.ExportTable)
+	if err != nil {
+		return err
+	}
+	missingFields, err := getColumnsToAdd(newSchema, existingColumns, snowflakeSchemaMap)
+	if err != nil {
+		return err
+	}
+	if len(missingFields) > 0 {
+		log.Printf("Found %d missing fields. Adding columns for these fields.", len(missingFields))
+		alterStmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", sf.qualifiedExportTableName(), columnSchema(missingFields))
+		if _, err = sf.conn.ExecContext(ctx, alterStmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sf *Snowflake) createExportTable(schema Schema) error {
+	log.Printf("Creating table %s", sf.qualifiedExportTableName())
+
+	stmt := fmt.Sprintf("CREATE TABLE %s(%s);", sf.qualifiedExportTableName(), schemaToColumns(schema, snowflakeSchemaMap, "snowflake"))
+	_, err := sf.conn.Exec(stmt)
+	return err
+}
+
+// CreateSyncTable creates a sync table with the hauser sync table schema
+func (sf *Snowflake) CreateSyncTable() error {
+	log.Printf("Creating table %s", sf.qualifiedSyncTableName())
+
+	stmt := fmt.Sprintf("CREATE TABLE %s(%s);", sf.qualifiedSyncTableName(), schemaToColumns(sf.syncSchema, snowflakeSchemaMap, "snowflake"))
+	_, err := sf.conn.Exec(stmt)
+	return err
+}
+
+func (sf *Snowflake) SaveSyncPoint(_ context.Context, endTime time.Time) error {
+	var err error
+	sf.conn, err = sf.MakeSnowflakeConnection()
+	if err != nil {
+		log.Printf("Couldn't connect to DB: %s", err)
+		return err
+	}
+	defer sf.conn.Close()
+
+	insert := fmt.Sprintf("INSERT INTO %s VALUES (%d, '%s', '%s');",
+		sf.qualifiedSyncTableName(), -1, time.Now().UTC().Format(time.RFC3339), endTime.UTC().Format(time.RFC3339))
+	_, err = sf.conn.Exec(insert)
+	return err
+}
+
+func (sf *Snowflake) LastSyncPoint(_ context.Context) (time.Time, error) {
+	t := time.Time{}
+	var err error
+	sf.conn, err = sf.MakeSnowflakeConnection()
+	if err != nil {
+		log.Printf("Couldn't connect to DB: %s", err)
+		return t, err
+	}
+	defer sf.conn.Close()
+
+	exists, err := sf.DoesTableExist(sf.conf.SyncTable)
+	if err != nil {
+		return t, err
+	}
+	if !exists {
+		if err := sf.CreateSyncTable(); err != nil {
+			log.Printf("Couldn't create sync table: %s", err)
+			return t, err
+		}
+		return t, nil
+	}
+
+	var syncTime sql.NullTime
+	q := fmt.Sprintf("SELECT max(BundleEndTime) FROM %s;", sf.qualifiedSyncTableName())
+	if err := sf.conn.QueryRow(q).Scan(&syncTime); err != nil {
+		log.Printf("Couldn't get max(BundleEndTime): %s", err)
+		return t, err
+	}
+	if syncTime.Valid {
+		t = syncTime.Time
+	}
+
+	if err := sf.RemoveOrphanedRecords(syncTime); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// RemoveOrphanedRecords deletes any export records that were loaded after the last sync point.
+// See Redshift.RemoveOrphanedRecords for details.
+func (sf *Snowflake) RemoveOrphanedRecords(lastSync sql.NullTime) error {
+	exists, err := sf.DoesTableExist(sf.conf.ExportTable)
+	if err != nil || !exists {
+		// A missing table is okay, because the hauser process will ensure that the table exists.
+		return err
+	}
+
+	var exportTime sql.NullTime
+	q := fmt.Sprintf("SELECT max(%s) FROM %s;", sf.schema.ColumnFor("EventStart"), sf.qualifiedExportTableName())
+	if err := sf.conn.QueryRow(q).Scan(&exportTime); err != nil {
+		log.Printf("Couldn't get max(%s): %s", sf.schema.ColumnFor("EventStart"), err)
+		return err
+	}
+	if exportTime.Valid && exportTime.Time.After(lastSync.Time) {
+		log.Printf("Export record timestamp after sync time (%s vs %s); cleaning",
+			exportTime.Time, lastSync.Time)
+		return sf.DeleteExportRecordsAfter(lastSync.Time)
+	}
+	return nil
+}
+
+func (sf *Snowflake) DeleteExportRecordsAfter(end time.Time) error {
+	stmt := fmt.Sprintf("DELETE FROM %s WHERE %s > '%s';",
+		sf.qualifiedExportTableName(), sf.schema.ColumnFor("EventStart"), end.Format(time.RFC3339))
+	if _, err := sf.conn.Exec(stmt); err != nil {
+		log.Printf("failed to delete from %s: %s", sf.qualifiedExportTableName(), err)
+		return err
+	}
+	return nil
+}
+
+// DoesTableExist checks if a table with a given name exists. Unquoted identifiers are stored
+// in upper case by Snowflake, so the names are compared case-insensitively.
+func (sf *Snowflake) DoesTableExist(name string) (bool, error) {
+	log.Printf("Checking if table %s exists", name)
+
+	var exists int
+	query := "SELECT count(*) FROM information_schema.tables WHERE table_schema = UPPER(?) AND table_name = UPPER(?);"
+	if err := sf.conn.QueryRow(query, sf.conf.DatabaseSchema, name).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists != 0, nil
+}
+
+func (sf *Snowflake) getTableColumns(name string) ([]string, error) {
+	log.Printf("Fetching columns for table %s", name)
+	query := "SELECT column_name FROM information_schema.columns WHERE table_schema = UPPER(?) AND table_name = UPPER(?) ORDER BY ordinal_position;"
+	rows, err := sf.conn.Query(query, sf.conf.DatabaseSchema, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
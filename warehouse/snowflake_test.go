@@ -0,0 +1,90 @@
+package warehouse
+
+import (
+	"testing"
+
+	"github.com/fullstorydev/hauser/config"
+	"github.com/fullstorydev/hauser/testing/testutils"
+)
+
+func makeSnowflakeConf(stage string) *config.SnowflakeConfig {
+	return &config.SnowflakeConfig{
+		Account:        "account",
+		DB:             "db",
+		DatabaseSchema: "mySchema",
+		ExportTable:    "exportTable",
+		SyncTable:      "syncTable",
+		Stage:          stage,
+	}
+}
+
+func TestSnowflakeSchema(t *testing.T) {
+	fs := MakeSchema(BaseExportFields{}, MobileFields{}, syncTable{})
+	for _, field := range fs {
+		_, ok := snowflakeSchemaMap[field.FieldType]
+		testutils.Assert(t, ok, "field type %v not found in snowflakeSchemaMap", field.FieldType)
+	}
+}
+
+func TestSnowflakeValueToString(t *testing.T) {
+	wh := NewSnowflake(makeSnowflakeConf(""))
+
+	var testCases = []struct {
+		input    interface{}
+		isTime   bool
+		expected string
+	}{
+		{"short string", false, "short string"},
+		{"keeps\nnew\nlines", false, "keeps\nnew\nlines"},
+		{"no\x00null\x00chars", false, "nonullchars"},
+		{5, false, "5"},
+		{"2009-11-10T23:00:00.000Z", true, "2009-11-10T23:00:00Z"},
+		{"2009-11-10T23:00:00.123456789Z", true, "2009-11-10T23:00:00.123456Z"},
+	}
+
+	for _, testCase := range testCases {
+		if got := wh.ValueToString(testCase.input, testCase.isTime); got != testCase.expected {
+			t.Errorf("Expected value %q, got %q", testCase.expected, got)
+		}
+	}
+}
+
+func TestSnowflakeStageName(t *testing.T) {
+	testCases := []struct {
+		conf     *config.SnowflakeConfig
+		expected string
+	}{
+		{
+			conf:     makeSnowflakeConf(""),
+			expected: "@mySchema.%exportTable",
+		},
+		{
+			conf:     makeSnowflakeConf("otherSchema.hauser_stage"),
+			expected: "@otherSchema.hauser_stage",
+		},
+	}
+
+	for _, tc := range testCases {
+		wh := NewSnowflake(tc.conf)
+		if got := wh.stageName(); got != tc.expected {
+			t.Errorf("Expected value %q, got %q", tc.expected, got)
+		}
+	}
+}
+
+func TestSnowflakeValidateConfig(t *testing.T) {
+	conf := makeSnowflakeConf("")
+	testutils.Assert(t, NewSnowflake(conf).validateConfig() == nil, "expected valid config")
+
+	for name, unset := range map[string]func(c *config.SnowflakeConfig){
+		"Account":        func(c *config.SnowflakeConfig) { c.Account = "" },
+		"DB":             func(c *config.SnowflakeConfig) { c.DB = "" },
+		"DatabaseSchema": func(c *config.SnowflakeConfig) { c.DatabaseSchema = "" },
+		"ExportTable":    func(c *config.SnowflakeConfig) { c.ExportTable = "" },
+		"SyncTable":      func(c *config.SnowflakeConfig) { c.SyncTable = "" },
+	} {
+		conf := makeSnowflakeConf("")
+		unset(conf)
+		testutils.Assert(t, NewSnowflake(conf).validateConfig() != nil, "expected error for missing %s", name)
+	}
+}
@@ -0,0 +1,115 @@
+package warehouse
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+)
+
+type columnConfig struct {
+	DBName string
+	DBType string
+	// FullStoryFieldName is used to find the columns that need a special type, whatever their name
+	FullStoryFieldName string
+}
+
+// columnSchema is the list of column definitions used in a SQL "create table" statement.
+type columnSchema []columnConfig
+
+func (c columnSchema) String() string {
+	ss := make([]string, len(c))
+	for i, f := range c {
+		ss[i] = fmt.Sprintf("%s %s", f.DBName, f.DBType)
+	}
+	return strings.Join(ss, ",")
+}
+
+// schemaToColumns converts the schema into column definitions using the provided type mapping.
+// It panics if a field type doesn't have a mapping since that indicates a programming error.
+func schemaToColumns(s Schema, typeMap map[reflect.Type]string, dbName string) columnSchema {
+	columns := make([]columnConfig, len(s))
+	for i, field := range s {
+		dbType, ok := typeMap[field.FieldType]
+		if !ok {
+			panic(fmt.Sprintf("field %s does not have a mapping to a database type for %s", field.DBName, dbName))
+		}
+		columns[i] = columnConfig{
+			DBName:             field.DBName,
+			DBType:             dbType,
+			FullStoryFieldName: field.FullStoryFieldName,
+		}
+	}
+	return columns
+}
+
+// getColumnsToAdd returns the column definitions for the fields of the schema which aren't part
+// of the existing columns. Since new columns can only be appended, the schema must start with the
+// existing columns.
+func getColumnsToAdd(s Schema, existing []string, typeMap map[reflect.Type]string) ([]columnConfig, error) {
+	if len(s) < len(existing) {
+		return nil, fmt.Errorf("incompatible schema: have %v, got %v", existing, s)
+	}
+	missing := make([]columnConfig, len(s)-len(existing))
+	for i := 0; i < len(missing); i++ {
+		field := s[len(existing)+i]
+		missing[i] = columnConfig{
+			DBName:             field.DBName,
+			DBType:             typeMap[field.FieldType],
+			FullStoryFieldName: field.FullStoryFieldName,
+		}
+	}
+	return missing, nil
+}
+
+// boolToInt formats a boolean for databases that store them as integers.
+func boolToInt(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func execInTransaction(ctx context.Context, conn *sql.DB, stmts []string) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// applySQLMigrations runs each migration and inserts a row for it into the migrations table, which is
+// created if it doesn't exist yet. Migrations with several statements are run in a transaction, while
+// single statements run on their own since some databases can't alter columns in a transaction.
+func applySQLMigrations(ctx context.Context, conn *sql.DB, migrations []Migration, table string, typeMap map[reflect.Type]string, placeholders string) error {
+	columns := schemaToColumns(MakeSchema(migrationsTable{}), typeMap, "migrations")
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s(%s);", table, columns)); err != nil {
+		return fmt.Errorf("failed to create migrations table: %s", err)
+	}
+	insert := fmt.Sprintf("INSERT INTO %s VALUES (%s);", table, placeholders)
+	for _, m := range migrations {
+		log.Printf("Migrating column %s", m)
+		var err error
+		if len(m.Statements) == 1 {
+			_, err = conn.ExecContext(ctx, m.Statements[0])
+		} else {
+			err = execInTransaction(ctx, conn, m.Statements)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to migrate column %s: %s", m.Column, err)
+		}
+		if _, err := conn.ExecContext(ctx, insert, time.Now().UTC(), m.Column, m.From, m.To); err != nil {
+			return fmt.Errorf("failed to record migration of column %s: %s", m.Column, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package warehouse
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/fullstorydev/hauser/config"
+	// A pure Go driver, since the release binaries and the Docker image are built without cgo
+	_ "modernc.org/sqlite"
+)
+
+// sqliteTimeFormat is a fixed width format that SQLite's date functions understand. Since SQLite
+// stores timestamps as text, the fixed width is necessary for comparisons and max() to work.
+const sqliteTimeFormat = "2006-01-02 15:04:05.000000"
+
+// SQLite is a file-backed database that allows the full pipeline to be run locally.
+type SQLite struct {
+	conn       *sql.DB
+	conf       *config.LocalConfig
+	syncSchema Schema
+	// schema is the last export schema that was applied, used to find the EventStart column
+	schema Schema
+}
+
+var (
+	sqliteSchemaMap = map[reflect.Type]string{
+		reflect.TypeOf(int64(0)):    "INTEGER",
+		reflect.TypeOf(""):          "TEXT",
+		reflect.TypeOf(time.Time{}): "TIMESTAMP",
+		reflect.TypeOf(float64(0)):  "REAL",
+		reflect.TypeOf(int32(0)):    "INTEGER",
+		reflect.TypeOf(false):       "INTEGER",
+	}
+)
+
+var _ DatabaseV2 = (*SQLite)(nil)
+
+func NewSQLite(c *config.LocalConfig) *SQLite {
+	return &SQLite{
+		conf:       c,
+		syncSchema: MakeSchema(syncTable{}),
+	}
+}
+
+func (sl *SQLite) MakeSQLiteConnection() (*sql.DB, error) {
+	db, err := sql.Open("sqlite", sl.conf.DatabaseFile)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite open error : (%v)", err)
+	}
+	if err = db.Ping(); err != nil {
+		return nil, fmt.Errorf("sqlite ping error : (%v)", err)
+	}
+	return db, nil
+}
+
+// GetExportTableColumns returns all the columns of the export table.
+func (sl *SQLite) GetExportTableColumns(_ context.Context) ([]string, error) {
+	var err error
+	sl.conn, err = sl.MakeSQLiteConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer sl.conn.Close()
+
+	return sl.getTableColumns(sl.conf.ExportTable)
+}
+
+func (sl *SQLite) ValueToString(val interface{}, isTime bool) string {
+	if b, ok := val.(bool); ok {
+		// SQLite doesn't have a boolean type, they are stored as integers
+		return boolToInt(b)
+	}
+	s := fmt.Sprintf("%v", val)
+	if isTime {
+		t, _ := time.Parse(time.RFC3339Nano, s)
+		return t.UTC().Format(sqliteTimeFormat)
+	}
+	return s
+}
+
+// LoadToWarehouse inserts the rows of the local CSV file into the export table in a single transaction.
+func (sl *SQLite) LoadToWarehouse(ctx context.Context, localFile string, _ time.Time) error {
+	f, err := os.Open(localFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sl.conn, err = sl.MakeSQLiteConnection()
+	if err != nil {
+		return err
+	}
+	defer sl.conn.Close()
+
+	return sl.insertData(ctx, f)
+}
+
+func (sl *SQLite) insertData(ctx context.Context, r io.Reader) error {
+	csvIn := csv.NewReader(r)
+	headers, err := csvIn.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read csv header: %s", err)
+	}
+
+	txn, err := sl.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(headers)), ",")
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", sl.conf.ExportTable, strings.Join(headers, ","), placeholders)
+	stmt, err := txn.PrepareContext(ctx, insert)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	args := make([]interface{}, len(headers))
+	for {
+		record, err := csvIn.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read csv record: %s", err)
+		}
+		for i, val := range record {
+			if val == "" {
+				args[i] = nil
+			} else {
+				args[i] = val
+			}
+		}
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return err
+		}
+	}
+	return txn.Commit()
+}
+
+func (sl *SQLite) InitExportTable(_ context.Context, schema Schema) (bool, error) {
+	sl.schema = schema
+	var err error
+	sl.conn, err = sl.MakeSQLiteConnection()
+	if err != nil {
+		return false, err
+	}
+	defer sl.conn.Close()
+
+	exists, err := sl.DoesTableExist(sl.conf.ExportTable)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		log.Printf("Export table %s does not exist! Creating one!", sl.conf.ExportTable)
+		if err = sl.createTable(sl.conf.ExportTable, schema); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func (sl *SQLite) ApplyExportSchema(ctx context.Context, newSchema Schema) error {
+	sl.schema = newSchema
+	var err error
+	sl.conn, err = sl.MakeSQLiteConnection()
+	if err != nil {
+		return err
+	}
+	defer sl.conn.Close()
+
+	existingColumns, err := sl.getTableColumns(sl.conf.ExportTable)
+	if err != nil {
+		return err
+	}
+	missingFields, err := getColumnsToAdd(newSchema, existingColumns, sqliteSchemaMap)
+	if err != nil {
+		return err
+	}
+	if len(missingFields) > 0 {
+		log.Printf("Found %d missing fields. Adding columns for these fields.", len(missingFields))
+		for _, f := range missingFields {
+			// SQLite only allows addition of one column at a time
+			alterStmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", sl.conf.ExportTable, f.DBName, f.DBType)
+			if _, err = sl.conn.ExecContext(ctx, alterStmt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (sl *SQLite) createTable(name string, schema Schema) error {
+	log.Printf("Creating table %s", name)
+
+	stmt := fmt.Sprintf("CREATE TABLE %s(%s);", name, schemaToColumns(schema, sqliteSchemaMap, "sqlite"))
+	_, err := sl.conn.Exec(stmt)
+	return err
+}
+
+func (sl *SQLite) SaveSyncPoint(_ context.Context, endTime time.Time) error {
+	var err error
+	sl.conn, err = sl.MakeSQLiteConnection()
+	if err != nil {
+		log.Printf("Couldn't connect to DB: %s", err)
+		return err
+	}
+	defer sl.conn.Close()
+
+	insert := fmt.Sprintf("INSERT INTO %s VALUES (?, ?, ?);", sl.conf.SyncTable)
+	_, err = sl.conn.Exec(insert, -1, time.Now().UTC().Format(sqliteTimeFormat), endTime.UTC().Format(sqliteTimeFormat))
+	return err
+}
+
+func (sl *SQLite) LastSyncPoint(_ context.Context) (time.Time, error) {
+	t := time.Time{}
+	var err error
+	sl.conn, err = sl.MakeSQLiteConnection()
+	if err != nil {
+		log.Printf("Couldn't connect to DB: %s", err)
+		return t, err
+	}
+	defer sl.conn.Close()
+
+	exists, err := sl.DoesTableExist(sl.conf.SyncTable)
+	if err != nil {
+		return t, err
+	}
+	if !exists {
+		if err := sl.createTable(sl.conf.SyncTable, sl.syncSchema); err != nil {
+			log.Printf("Couldn't create sync table: %s", err)
+			return t, err
+		}
+		return t, nil
+	}
+
+	q := fmt.Sprintf("SELECT max(BundleEndTime) FROM %s;", sl.conf.SyncTable)
+	if t, err = sl.fetchTimeVal(q); err != nil {
+		log.Printf("Couldn't get max(BundleEndTime): %s", err)
+		return t, err
+	}
+
+	if err := sl.RemoveOrphanedRecords(t); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// RemoveOrphanedRecords deletes export records that were loaded after the last sync point, which
+// happens when hauser stops after loading a file but before saving the sync point.
+func (sl *SQLite) RemoveOrphanedRecords(lastSync time.Time) error {
+	exists, err := sl.DoesTableExist(sl.conf.ExportTable)
+	if err != nil || !exists {
+		// A missing table is okay, because the hauser process will ensure that the table exists.
+		return err
+	}
+
+	q := fmt.Sprintf("SELECT max(%s) FROM %s;", sl.schema.ColumnFor("EventStart"), sl.conf.ExportTable)
+	exportTime, err := sl.fetchTimeVal(q)
+	if err != nil {
+		log.Printf("Couldn't get max(%s): %s", sl.schema.ColumnFor("EventStart"), err)
+		return err
+	}
+	if exportTime.After(lastSync) {
+		log.Printf("Export record timestamp after sync time (%s vs %s); cleaning", exportTime, lastSync)
+		stmt := fmt.Sprintf("DELETE FROM %s WHERE %s > ?;", sl.conf.ExportTable, sl.schema.ColumnFor("EventStart"))
+		if _, err := sl.conn.Exec(stmt, lastSync.UTC().Format(sqliteTimeFormat)); err != nil {
+			log.Printf("failed to delete from %s: %s", sl.conf.ExportTable, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchTimeVal runs a query returning a single timestamp. Aggregates lose the declared column
+// type, so the value is returned as text by the driver and needs to be parsed.
+func (sl *SQLite) fetchTimeVal(q string) (time.Time, error) {
+	var val sql.NullString
+	if err := sl.conn.QueryRow(q).Scan(&val); err != nil {
+		return time.Time{}, err
+	}
+	if !val.Valid {
+		return time.Time{}, nil
+	}
+	return time.Parse(sqliteTimeFormat, val.String)
+}
+
+// DoesTableExist checks if a table with a given name exists
+func (sl *SQLite) DoesTableExist(name string) (bool, error) {
+	log.Printf("Checking if table %s exists", name)
+
+	var exists int
+	query := "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND lower(name) = lower(?);"
+	if err := sl.conn.QueryRow(query, name).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists != 0, nil
+}
+
+func (sl *SQLite) getTableColumns(name string) ([]string, error) {
+	log.Printf("Fetching columns for table %s", name)
+	rows, err := sl.conn.Query("SELECT name FROM pragma_table_info(?) ORDER BY cid;", name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
@@ -0,0 +1,32 @@
+//go:build !cgo
+// +build !cgo
+
+package warehouse
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fullstorydev/hauser/config"
+	"github.com/fullstorydev/hauser/testing/testutils"
+)
+
+// TestSQLiteWithoutCgo makes sure that the SQLite driver works in binaries that are built without cgo,
+// like the release binaries and the Docker image. It's run by `make test-nocgo`.
+func TestSQLiteWithoutCgo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hauser-sqlite")
+	testutils.Assert(t, err == nil, "failed to create temp dir: %s", err)
+	defer os.RemoveAll(dir)
+
+	db := NewSQLite(&config.LocalConfig{
+		DatabaseFile: filepath.Join(dir, "hauser.db"),
+		ExportTable:  "fsexport",
+		SyncTable:    "fssync",
+	})
+	created, err := db.InitExportTable(context.Background(), MakeSchema(BaseExportFields{}))
+	testutils.Assert(t, err == nil, "failed to init export table without cgo: %s", err)
+	testutils.Assert(t, created, "expected export table to be created")
+}
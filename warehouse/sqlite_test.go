@@ -0,0 +1,84 @@
+package warehouse
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/hauser/config"
+	"github.com/fullstorydev/hauser/testing/testutils"
+)
+
+func TestSQLiteSchema(t *testing.T) {
+	fs := MakeSchema(BaseExportFields{}, MobileFields{}, syncTable{})
+	for _, field := range fs {
+		_, ok := sqliteSchemaMap[field.FieldType]
+		testutils.Assert(t, ok, "field type %v not found in sqliteSchemaMap", field.FieldType)
+	}
+}
+
+func TestSQLiteLoad(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "hauser-sqlite")
+	testutils.Assert(t, err == nil, "failed to create temp dir: %s", err)
+	defer os.RemoveAll(dir)
+
+	db := NewSQLite(&config.LocalConfig{
+		DatabaseFile: filepath.Join(dir, "hauser.db"),
+		ExportTable:  "fsexport",
+		SyncTable:    "fssync",
+	})
+	schema := MakeSchema(struct {
+		EventStart   time.Time
+		PageDuration int64
+		CustomVars   string
+	}{})
+
+	created, err := db.InitExportTable(ctx, schema)
+	testutils.Assert(t, err == nil, "failed to init export table: %s", err)
+	testutils.Assert(t, created, "expected export table to be created")
+	created, err = db.InitExportTable(ctx, schema)
+	testutils.Assert(t, err == nil, "failed to init export table: %s", err)
+	testutils.Assert(t, !created, "expected export table to exist")
+
+	lastSync, err := db.LastSyncPoint(ctx)
+	testutils.Assert(t, err == nil, "failed to get sync point: %s", err)
+	testutils.Assert(t, lastSync.IsZero(), "expected zero sync point, got %s", lastSync)
+
+	// Add a column to the existing table
+	newSchema := append(schema, WarehouseField{DBName: "PageUrl", FullStoryFieldName: "PageUrl", FieldType: reflect.TypeOf("")})
+	testutils.Assert(t, db.ApplyExportSchema(ctx, newSchema) == nil, "failed to apply schema")
+	columns, err := db.GetExportTableColumns(ctx)
+	testutils.Assert(t, err == nil, "failed to get columns: %s", err)
+	testutils.StrSliceEquals(t, []string{"EventStart", "PageDuration", "CustomVars", "PageUrl"}, columns, "wrong columns")
+
+	csvFile := filepath.Join(dir, "bundle.csv")
+	data := "EventStart,PageDuration,CustomVars,PageUrl\n" +
+		db.ValueToString("2020-08-26T01:00:00.5Z", true) + ",42,{},https://example.com\n" +
+		db.ValueToString("2020-08-26T02:00:00Z", true) + ",,{},\n"
+	testutils.Assert(t, ioutil.WriteFile(csvFile, []byte(data), 0666) == nil, "failed to write csv")
+	testutils.Assert(t, db.LoadToWarehouse(ctx, csvFile, time.Time{}) == nil, "failed to load csv")
+	testutils.Equals(t, 2, countRows(t, db), "wrong number of rows")
+
+	// Without a sync point the loaded rows are orphans
+	end := time.Date(2020, 8, 26, 1, 0, 0, 0, time.UTC)
+	testutils.Assert(t, db.SaveSyncPoint(ctx, end) == nil, "failed to save sync point")
+	lastSync, err = db.LastSyncPoint(ctx)
+	testutils.Assert(t, err == nil, "failed to get sync point: %s", err)
+	testutils.Assert(t, lastSync.Equal(end), "wrong sync point: %s", lastSync)
+	testutils.Equals(t, 0, countRows(t, db), "orphaned rows should be removed")
+}
+
+func countRows(t *testing.T, db *SQLite) int {
+	conn, err := db.MakeSQLiteConnection()
+	testutils.Assert(t, err == nil, "failed to connect: %s", err)
+	defer conn.Close()
+
+	var count int
+	testutils.Assert(t, conn.QueryRow("SELECT count(*) FROM fsexport").Scan(&count) == nil, "failed to count rows")
+	return count
+}
@@ -3,11 +3,15 @@ package warehouse
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -34,6 +38,29 @@ type Storage interface {
 	GetFilePrefix() string
 }
 
+// DatabaseV2 is a database that the exports are loaded into. Its methods take a context and return an error
+// instead of exiting the process, so that the caller can decide whether to retry or stop. ValueToString only
+// formats a value for the load files, so it doesn't need either.
+type DatabaseV2 interface {
+	Syncable
+	LoadToWarehouse(ctx context.Context, storageRef string, start time.Time) error
+	ValueToString(val interface{}, isTime bool) string
+	GetExportTableColumns(ctx context.Context) ([]string, error)
+
+	// InitExportTable should attempt to create the table in the database. If the table doesn't exist, the provided
+	// schema should be applied to the table and this function should return `true`, assuming an error didn't occur.
+	// If the table existed, this should return false to signal that follow-up schema validation is necessary.
+	InitExportTable(ctx context.Context, s Schema) (bool, error)
+
+	// ApplyExportSchema will attempt to update the schema in the database to the provided schema.
+	// The provided schema must be compatible, or this will fail. Compatible schemas will have existing columns
+	// in the same order as they are currently ordered in the table and can also add new columns to the end.
+	ApplyExportSchema(ctx context.Context, s Schema) error
+}
+
+// Database is the original interface of the databases, whose methods don't take a context.
+//
+// Deprecated: Implement DatabaseV2 instead. NewDatabaseAdapter converts a Database to a DatabaseV2.
 type Database interface {
 	Syncable
 	LoadToWarehouse(storageRef string, start time.Time) error
@@ -51,6 +78,13 @@ type Database interface {
 	ApplyExportSchema(Schema) error
 }
 
+// JSONCustomVarsDatabase is implemented by databases that can store the custom vars in a JSON column.
+// If CustomVarsAsJSON returns true, JSON files should contain the custom vars as an object instead
+// of an encoded string.
+type JSONCustomVarsDatabase interface {
+	CustomVarsAsJSON() bool
+}
+
 const RFC3339Micro = "2006-01-02T15:04:05.999999Z07:00"
 
 type ValueToStringFn func(val interface{}, isTime bool) string
@@ -70,6 +104,76 @@ func ValueToString(val interface{}, isTime bool) string {
 	return s
 }
 
+// recordToObject converts a CSV record into an object keyed by column name, using the fields
+// to restore the value types. Empty values are omitted.
+func recordToObject(fields []WarehouseField, record []string) map[string]interface{} {
+	obj := make(map[string]interface{}, len(record))
+	for i, val := range record {
+		if val == "" {
+			continue
+		}
+		obj[fields[i].DBName] = typedValue(fields[i], val)
+	}
+	return obj
+}
+
+// typedValue converts a value from the CSV file back to its type in the schema, so that it can be encoded as JSON.
+// Custom vars are kept as a JSON object. Values that can't be parsed are returned as strings.
+func typedValue(field WarehouseField, val string) interface{} {
+	if field.FullStoryFieldName == "CustomVars" && json.Valid([]byte(val)) {
+		return json.RawMessage(val)
+	}
+	switch field.FieldType {
+	case reflect.TypeOf(int64(0)), reflect.TypeOf(int32(0)):
+		if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return n
+		}
+	case reflect.TypeOf(float64(0)):
+		if n, err := strconv.ParseFloat(val, 64); err == nil {
+			return n
+		}
+	case reflect.TypeOf(false):
+		if b, err := strconv.ParseBool(val); err == nil {
+			return b
+		}
+	}
+	return val
+}
+
+// parseValue strictly converts a value from an export record to the type of the field, for
+// the typed file formats. Times are expected in RFC3339 format.
+func parseValue(field WarehouseField, val string) (interface{}, error) {
+	switch field.FieldType {
+	case reflect.TypeOf(int64(0)):
+		return strconv.ParseInt(val, 10, 64)
+	case reflect.TypeOf(int32(0)):
+		i, err := strconv.ParseInt(val, 10, 32)
+		return int32(i), err
+	case reflect.TypeOf(float64(0)):
+		return strconv.ParseFloat(val, 64)
+	case reflect.TypeOf(false):
+		return strconv.ParseBool(val)
+	case reflect.TypeOf(time.Time{}):
+		return time.Parse(time.RFC3339Nano, val)
+	default:
+		return val, nil
+	}
+}
+
+// parseValueOrNull parses a value like parseValue, but a value that doesn't match the type of the field
+// is logged and returned as nil, so that one bad value doesn't fail the whole bundle.
+func parseValueOrNull(field WarehouseField, val string) interface{} {
+	if val == "" {
+		return nil
+	}
+	v, err := parseValue(field, val)
+	if err != nil {
+		log.Printf("Invalid value for %s, writing null: %s", field.DBName, err)
+		return nil
+	}
+	return v
+}
+
 // SyncViaStorageMixin provides a default implementation for the Syncable interface.
 type SyncViaStorageMixin struct {
 	storage Storage